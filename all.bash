@@ -17,9 +17,14 @@
 
 for sub in manifest \
   gitiles \
+  config \
+  policy \
+  glob \
   cache \
   fs \
   populate \
+  logging \
+cmd/slothfs \
 cmd/slothfs-deref-manifest \
 cmd/slothfs-repofs \
 cmd/slothfs-manifestfs \
@@ -27,6 +32,15 @@ cmd/slothfs-populate \
 cmd/slothfs-gitilesfs \
 cmd/slothfs-deref-repo \
 cmd/slothfs-gitiles-test \
+cmd/slothfs-expand-manifest \
+cmd/slothfs-cache-gc \
+cmd/slothfs-cache-import \
+cmd/slothfs-check \
+cmd/slothfs-query \
+cmd/slothfs-prefetch \
+cmd/slothfs-localgitfs \
+cmd/slothfs-hostfs \
+cmd/slothfs-fsck-cache \
   ; do
   p=github.com/google/slothfs/${sub}
   go clean $p
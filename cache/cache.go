@@ -17,9 +17,16 @@
 package cache
 
 import (
+	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"time"
+
+	"github.com/google/slothfs/logging"
+	"gopkg.in/src-d/go-git.v4/plumbing"
 )
 
 // Cache combines a blob, tree and git repo cache.
@@ -28,17 +35,92 @@ type Cache struct {
 	Tree *TreeCache
 	Blob *CAS
 
-	root string
+	root   string
+	logger *logging.Logger
 }
 
 // Options defines configurable options for the different caches.
 type Options struct {
 	// FetchFrequency controls how often we run git fetch on the
-	// locally cached git repositories.
+	// locally cached git repositories. If negative, no periodic
+	// fetches are done.
 	FetchFrequency time.Duration
+
+	// BlobMemoryLimit is the number of bytes of small blobs to keep
+	// in memory, in front of the on-disk blob store. Blobs larger
+	// than 1/16th of the limit are only kept on disk. If zero,
+	// blobs are not kept in memory.
+	BlobMemoryLimit int64
+
+	// VerifyFraction is the fraction of blob opens, between 0 and
+	// 1, that check the content against the blob's SHA1. Corrupt
+	// blobs are removed, so they are fetched again. If zero, blobs
+	// are not verified on open.
+	VerifyFraction float64
+
+	// Logger receives log messages. If nil, logging.Default() is
+	// used.
+	Logger *logging.Logger
+}
+
+// formatVersion identifies the on-disk layout of the cache. Bump it
+// when the layout changes incompatibly.
+const formatVersion = "1"
+
+// checkVersion verifies that the cache in d has the current format,
+// and records the format if d has none yet. As the version file is
+// always rewritten, this also verifies that d is writable.
+func checkVersion(d string) error {
+	p := filepath.Join(d, "version")
+	c, err := ioutil.ReadFile(p)
+	if err == nil {
+		if got := strings.TrimSpace(string(c)); got != formatVersion {
+			return fmt.Errorf("cache has format version %q, want %q; remove the directory to start over", got, formatVersion)
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	f, err := ioutil.TempFile(d, "version")
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteString(formatVersion + "\n")
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), p)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// blobDir is the directory holding the CAS within the cache.
+const blobDir = "blobs"
+
+// BlobPath returns the path of the file holding blob id in the cache
+// rooted at dir. The file only exists once the blob was fetched.
+func BlobPath(dir string, id plumbing.Hash) string {
+	return casPath(filepath.Join(dir, blobDir), id)
+}
+
+// VerifyBlob checks that the content of blob id in the cache rooted
+// at dir hashes to id.
+func VerifyBlob(dir string, id plumbing.Hash) error {
+	f, err := os.Open(BlobPath(dir, id))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return verifyFile(id, f)
 }
 
 // NewCache sets up a Cache instance according to the given options.
+// It creates the directory layout if needed, and fails if the
+// directory is not writable or holds a cache of a different format.
 func NewCache(d string, opts Options) (*Cache, error) {
 	if opts.FetchFrequency == 0 {
 		opts.FetchFrequency = 12 * time.Hour
@@ -49,28 +131,66 @@ func NewCache(d string, opts Options) (*Cache, error) {
 		return nil, err
 	}
 	if err := os.MkdirAll(d, 0700); err != nil {
-		return nil, err
+		return nil, fmt.Errorf("NewCache(%s): %v", d, err)
+	}
+	if err := checkVersion(d); err != nil {
+		return nil, fmt.Errorf("NewCache(%s): %v", d, err)
 	}
 
-	g, err := newGitCache(filepath.Join(d, "git"), opts)
+	c, err := NewCAS(filepath.Join(d, blobDir))
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("NewCache(%s): %v", d, err)
+	}
+	if opts.BlobMemoryLimit > 0 {
+		c.mem = newBlobLRU(opts.BlobMemoryLimit)
 	}
+	c.verify = opts.VerifyFraction
+	c.logger = opts.Logger.Sub("cache")
 
-	c, err := NewCAS(filepath.Join(d, "blobs"))
+	t, err := NewTreeCache(filepath.Join(d, "tree"))
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("NewCache(%s): %v", d, err)
 	}
 
-	t, err := NewTreeCache(filepath.Join(d, "tree"))
+	// The git cache starts fetching in the background, so create
+	// it last.
+	g, err := newGitCache(filepath.Join(d, "git"), opts)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("NewCache(%s): %v", d, err)
 	}
 
 	return &Cache{Git: g, Tree: t, Blob: c,
-		root: d,
+		root:   d,
+		logger: g.logger,
 	}, nil
 }
 
+// Logger returns the logger for cache messages.
+func (c *Cache) Logger() *logging.Logger { return c.logger }
+
 // Root returns the directory holding the cache storage.
 func (c *Cache) Root() string { return c.root }
+
+// Stats holds counters for cache lookups.
+type Stats struct {
+	TreeHits   int64
+	TreeMisses int64
+	BlobHits   int64
+	BlobMisses int64
+
+	// BlobCorrupt counts blobs that failed verification on open.
+	BlobCorrupt int64
+}
+
+// Stats returns a snapshot of the lookup counters. It is safe for
+// concurrent use.
+func (c *Cache) Stats() Stats {
+	return Stats{
+		TreeHits:   atomic.LoadInt64(&c.Tree.hits),
+		TreeMisses: atomic.LoadInt64(&c.Tree.misses),
+		BlobHits:   atomic.LoadInt64(&c.Blob.hits),
+		BlobMisses: atomic.LoadInt64(&c.Blob.misses),
+
+		BlobCorrupt: atomic.LoadInt64(&c.Blob.corrupt),
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNewCacheLayout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	root := filepath.Join(dir, "cache")
+	if _, err := NewCache(root, Options{FetchFrequency: -1}); err != nil {
+		t.Fatalf("NewCache: %v", err)
+	}
+	for _, sub := range []string{"blobs", "tree", "git"} {
+		if fi, err := os.Stat(filepath.Join(root, sub)); err != nil || !fi.IsDir() {
+			t.Errorf("Stat(%s): %v", sub, err)
+		}
+	}
+	if c, err := ioutil.ReadFile(filepath.Join(root, "version")); err != nil {
+		t.Errorf("ReadFile(version): %v", err)
+	} else if got := strings.TrimSpace(string(c)); got != formatVersion {
+		t.Errorf("got version %q, want %q", got, formatVersion)
+	}
+
+	// Reopening a cache of the same format works.
+	if _, err := NewCache(root, Options{FetchFrequency: -1}); err != nil {
+		t.Fatalf("NewCache(existing): %v", err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(root, "version"), []byte("0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewCache(root, Options{FetchFrequency: -1}); err == nil || !strings.Contains(err.Error(), "format version") {
+		t.Errorf("NewCache(old format): got %v, want version error", err)
+	}
+}
+
+func TestNewCacheReadOnly(t *testing.T) {
+	if os.Getuid() == 0 {
+		t.Skip("permissions are not enforced for root")
+	}
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := os.Chmod(dir, 0500); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(dir, 0700)
+
+	if _, err := NewCache(dir, Options{FetchFrequency: -1}); err == nil {
+		t.Errorf("NewCache on read-only directory succeeded")
+	}
+}
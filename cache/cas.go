@@ -16,10 +16,15 @@ package cache
 
 import (
 	"fmt"
+	"io"
 	"io/ioutil"
+	"math/rand"
 	"os"
 	"path/filepath"
+	"sync/atomic"
+	"time"
 
+	"github.com/google/slothfs/logging"
 	"gopkg.in/src-d/go-git.v4/plumbing"
 )
 
@@ -28,7 +33,21 @@ import (
 // git headers. This means that we can wire up files from the CAS
 // directly with a FUSE file system.
 type CAS struct {
+	// hits and misses are first, so they are 64-bit aligned for
+	// atomic access.
+	hits    int64
+	misses  int64
+	corrupt int64
+
 	dir string
+
+	// mem holds small blobs in memory, if set.
+	mem *blobLRU
+
+	// verify is the fraction of opens that check the content
+	// against the blob ID.
+	verify float64
+	logger *logging.Logger
 }
 
 // NewCAS creates a new CAS object.
@@ -37,44 +56,223 @@ func NewCAS(dir string) (*CAS, error) {
 		return nil, err
 	}
 	return &CAS{
-		dir: dir,
+		dir:    dir,
+		logger: logging.Default().Sub("cache"),
 	}, nil
 }
 
 func (c *CAS) path(id plumbing.Hash) string {
+	return casPath(c.dir, id)
+}
+
+// casPath returns the path of blob id in a CAS rooted at dir.
+func casPath(dir string, id plumbing.Hash) string {
 	str := id.String()
-	return fmt.Sprintf("%s/%s/%s", c.dir, str[:3], str[3:])
+	return fmt.Sprintf("%s/%s/%s", dir, str[:3], str[3:])
+}
+
+// ReadMemory returns the content of a blob if it is held in memory.
+// Unlike OpenMemory, it does not count as a use of the blob.
+func (c *CAS) ReadMemory(id plumbing.Hash) ([]byte, bool) {
+	if c.mem == nil {
+		return nil, false
+	}
+	return c.mem.get(id)
+}
+
+// OpenMemory is like ReadMemory, but for opening a file: it counts a
+// cache hit, and marks the blob on disk as recently used, so Trim
+// does not evict blobs that are only served from memory.
+func (c *CAS) OpenMemory(id plumbing.Hash) ([]byte, bool) {
+	data, ok := c.ReadMemory(id)
+	if !ok {
+		return nil, false
+	}
+	atomic.AddInt64(&c.hits, 1)
+	if c.mem.touchDue(id) {
+		if fi, err := os.Stat(c.path(id)); err == nil {
+			os.Chtimes(c.path(id), time.Now(), fi.ModTime())
+		}
+	}
+	return data, true
 }
 
 // Open returns a file corresponding to the blob, opened for reading.
+// Small blobs are also loaded into memory, if that is enabled. If the
+// blob is verified and found corrupt, it is removed and Open fails.
 func (c *CAS) Open(id plumbing.Hash) (*os.File, bool) {
 	f, err := os.Open(c.path(id))
-	return f, err == nil
+	if err == nil && c.verify > 0 && rand.Float64() < c.verify {
+		if err = verifyFile(id, f); err != nil {
+			f.Close()
+			if _, ok := err.(*corruptError); ok {
+				// Drop the blob, so the caller fetches it again.
+				atomic.AddInt64(&c.corrupt, 1)
+				c.logger.Errorf("removing corrupt blob: %v", err)
+				c.remove(id)
+			} else {
+				c.logger.Errorf("verifying blob %s: %v", id, err)
+			}
+		}
+	}
+	if err != nil {
+		atomic.AddInt64(&c.misses, 1)
+		return nil, false
+	}
+	atomic.AddInt64(&c.hits, 1)
+	c.touch(id, f)
+	if c.mem != nil {
+		c.load(id, f)
+	}
+	return f, true
 }
 
-// Write writes the given data under the given ID atomically.
+// touchInterval is how stale the access time of a blob may get
+// before Open refreshes it. Trim evicts blobs by access time.
+const touchInterval = time.Hour
+
+// touch marks the blob as recently used. It only sets the access
+// time, as the blob may be hardlinked into a checkout, where the
+// modification time matters to build tools.
+func (c *CAS) touch(id plumbing.Hash, f *os.File) {
+	fi, err := f.Stat()
+	if err != nil {
+		return
+	}
+	now := time.Now()
+	if now.Sub(atime(fi)) > touchInterval {
+		os.Chtimes(c.path(id), now, fi.ModTime())
+	}
+}
+
+// remove deletes the blob from disk and memory.
+func (c *CAS) remove(id plumbing.Hash) error {
+	if c.mem != nil {
+		c.mem.remove(id)
+	}
+	return os.Remove(c.path(id))
+}
+
+// load reads f into memory if it is small enough, and rewinds it.
+func (c *CAS) load(id plumbing.Hash, f *os.File) {
+	fi, err := f.Stat()
+	if err != nil || fi.Size() > c.mem.maxEntry {
+		return
+	}
+	data, err := ioutil.ReadAll(f)
+	if _, seekErr := f.Seek(0, io.SeekStart); err == nil && seekErr == nil {
+		c.mem.add(id, data)
+	}
+}
+
+// Write writes the given data under the given ID atomically. It
+// returns an error if the data does not hash to the given ID.
 func (c *CAS) Write(id plumbing.Hash, data []byte) error {
-	// TODO(hanwen): we should run data through the git hash to
-	// verify that it is what it says it is.
+	if got := plumbing.ComputeHash(plumbing.BlobObject, data); got != id {
+		return fmt.Errorf("CAS.Write: content for blob %s hashes to %s", id, got)
+	}
+
 	f, err := ioutil.TempFile(c.dir, "tmp")
 	if err != nil {
 		return err
 	}
+	defer os.Remove(f.Name())
 
-	if err := f.Chmod(0444); err != nil {
+	if _, err := f.Write(data); err != nil {
+		f.Close()
 		return err
 	}
+	if err := c.install(id, f); err != nil {
+		return err
+	}
+	if c.mem != nil {
+		c.mem.add(id, data)
+	}
+	return nil
+}
 
-	if _, err := f.Write(data); err != nil {
+// WriteFrom is like Write, but copies the data from r, so large blobs
+// need not be held in memory.
+func (c *CAS) WriteFrom(id plumbing.Hash, r io.Reader) error {
+	f, err := ioutil.TempFile(c.dir, "tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	size, err := io.Copy(f, r)
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	h := plumbing.NewHasher(plumbing.BlobObject, size)
+	if err == nil {
+		_, err = io.Copy(h, f)
+	}
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if got := h.Sum(); got != id {
+		f.Close()
+		return fmt.Errorf("CAS.WriteFrom: content for blob %s hashes to %s", id, got)
+	}
+	return c.install(id, f)
+}
+
+// Verify checks that the stored content of blob id hashes to id.
+func (c *CAS) Verify(id plumbing.Hash) error {
+	f, err := os.Open(c.path(id))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return verifyFile(id, f)
+}
+
+// verifyFile checks that f, read from the start, hashes to id, and
+// rewinds it.
+func verifyFile(id plumbing.Hash, f *os.File) error {
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	h := plumbing.NewHasher(plumbing.BlobObject, fi.Size())
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if got := h.Sum(); got != id {
+		return &corruptError{id, got}
+	}
+	return nil
+}
+
+// corruptError is returned by verifyFile if the content of a blob
+// does not match its ID. Other errors, eg. EACCES, say nothing about
+// the content.
+type corruptError struct {
+	id, got plumbing.Hash
+}
+
+func (e *corruptError) Error() string {
+	return fmt.Sprintf("content for blob %s hashes to %s", e.id, e.got)
+}
+
+// install closes the temporary file f, and moves it into place as the
+// blob for id.
+func (c *CAS) install(id plumbing.Hash, f *os.File) error {
+	if err := f.Chmod(0444); err != nil {
+		f.Close()
 		return err
 	}
 	if err := f.Close(); err != nil {
 		return err
 	}
 	p := c.path(id)
-	dir := filepath.Dir(p)
-	if err := os.MkdirAll(dir, 0700); err != nil {
+	if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
 		return err
 	}
-	return os.Rename(f.Name(), c.path(id))
+	return os.Rename(f.Name(), p)
 }
@@ -0,0 +1,258 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+)
+
+func TestCASWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	cas, err := NewCAS(dir)
+	if err != nil {
+		t.Fatalf("NewCAS: %v", err)
+	}
+
+	content := []byte("hello")
+
+	// $ echo -n hello | git hash-object --stdin
+	id := plumbing.NewHash("b6fc4c620b67d95f953a5c1c1230aaab5db5a1b0")
+	if err := cas.Write(id, content); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	f, ok := cas.Open(id)
+	if !ok {
+		t.Fatalf("Open(%s) failed", id)
+	}
+	got, err := ioutil.ReadAll(f)
+	f.Close()
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if string(got) != string(content) {
+		t.Errorf("got %q, want %q", got, content)
+	}
+
+	wrongID := plumbing.NewHash("abcd1234abcd1234abcd1234abcd1234abcd1234")
+	if err := cas.Write(wrongID, content); err == nil {
+		t.Errorf("Write with mismatched ID succeeded")
+	}
+	if f, ok := cas.Open(wrongID); ok {
+		f.Close()
+		t.Errorf("Open(%s) succeeded for mismatched content", wrongID)
+	}
+
+	if cas.hits != 1 || cas.misses != 1 {
+		t.Errorf("got hits %d, misses %d, want 1, 1", cas.hits, cas.misses)
+	}
+}
+
+func TestCASWriteFrom(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	cas, err := NewCAS(dir)
+	if err != nil {
+		t.Fatalf("NewCAS: %v", err)
+	}
+
+	id := plumbing.NewHash("b6fc4c620b67d95f953a5c1c1230aaab5db5a1b0")
+	wrongID := plumbing.NewHash("abcd1234abcd1234abcd1234abcd1234abcd1234")
+	if err := cas.WriteFrom(wrongID, strings.NewReader("hello")); err == nil {
+		t.Errorf("WriteFrom with mismatched ID succeeded")
+	}
+	if err := cas.WriteFrom(id, strings.NewReader("hello")); err != nil {
+		t.Fatalf("WriteFrom: %v", err)
+	}
+
+	f, ok := cas.Open(id)
+	if !ok {
+		t.Fatalf("Open(%s) failed", id)
+	}
+	got, err := ioutil.ReadAll(f)
+	f.Close()
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if string(got) != "hello" {
+		t.Errorf("got %q, want %q", got, "hello")
+	}
+
+	if names, err := filepath.Glob(filepath.Join(dir, "tmp*")); err != nil || len(names) > 0 {
+		t.Errorf("temporary files left behind: %v, %v", names, err)
+	}
+}
+
+func TestCASMemory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	cas, err := NewCAS(dir)
+	if err != nil {
+		t.Fatalf("NewCAS: %v", err)
+	}
+	cas.mem = newBlobLRU(160)
+
+	small := []byte("hello")
+	smallID := plumbing.ComputeHash(plumbing.BlobObject, small)
+	large := make([]byte, 11)
+	largeID := plumbing.ComputeHash(plumbing.BlobObject, large)
+	for id, data := range map[plumbing.Hash][]byte{smallID: small, largeID: large} {
+		if err := cas.Write(id, data); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+
+	if got, ok := cas.ReadMemory(smallID); !ok || string(got) != "hello" {
+		t.Errorf("ReadMemory(small): got %q, %v", got, ok)
+	}
+	if _, ok := cas.ReadMemory(largeID); ok {
+		t.Errorf("blob over the entry limit was kept in memory")
+	}
+
+	// Blobs found on disk are loaded into memory when opened.
+	cas.mem = newBlobLRU(160)
+	f, ok := cas.Open(smallID)
+	if !ok {
+		t.Fatalf("Open failed")
+	}
+	got, err := ioutil.ReadAll(f)
+	f.Close()
+	if err != nil || string(got) != "hello" {
+		t.Errorf("read after load: got %q, %v", got, err)
+	}
+	if _, ok := cas.ReadMemory(smallID); !ok {
+		t.Errorf("blob not loaded into memory by Open")
+	}
+
+	// Opening from memory counts a hit, and refreshes the access
+	// time on disk once it is stale; reading does neither.
+	old := time.Now().Add(-10 * time.Hour)
+	if err := os.Chtimes(cas.path(smallID), old, old); err != nil {
+		t.Fatal(err)
+	}
+	cas.mem.entries[smallID].Value.(*lruEntry).touched = old
+	hits := cas.hits
+	cas.ReadMemory(smallID)
+	if _, ok := cas.OpenMemory(smallID); !ok {
+		t.Fatalf("OpenMemory failed")
+	}
+	if got := cas.hits - hits; got != 1 {
+		t.Errorf("got %d hits, want 1", got)
+	}
+	fi, err := os.Stat(cas.path(smallID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if atime(fi).Before(time.Now().Add(-time.Hour)) {
+		t.Errorf("OpenMemory did not refresh the access time")
+	}
+	if !fi.ModTime().Equal(old) {
+		t.Errorf("OpenMemory changed the modification time")
+	}
+}
+
+func TestBlobLRUEvict(t *testing.T) {
+	lru := newBlobLRU(160)
+	ids := []plumbing.Hash{{1}, {2}, {3}}
+	lru.add(ids[0], make([]byte, 8))
+	lru.add(ids[1], make([]byte, 8))
+	lru.get(ids[0])
+	lru.maxBytes = 16
+	lru.add(ids[2], make([]byte, 8))
+
+	if _, ok := lru.get(ids[1]); ok {
+		t.Errorf("least recently used entry was not evicted")
+	}
+	for _, id := range []plumbing.Hash{ids[0], ids[2]} {
+		if _, ok := lru.get(id); !ok {
+			t.Errorf("entry %s was evicted", id)
+		}
+	}
+	if lru.size != 16 {
+		t.Errorf("got size %d, want 16", lru.size)
+	}
+}
+
+// corruptBlob overwrites the stored content of a blob.
+func corruptBlob(t *testing.T, path string) {
+	if err := os.Chmod(path, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte("hellO"), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCASVerify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	cas, err := NewCAS(dir)
+	if err != nil {
+		t.Fatalf("NewCAS: %v", err)
+	}
+	cas.verify = 1
+
+	id := plumbing.NewHash("b6fc4c620b67d95f953a5c1c1230aaab5db5a1b0")
+	if err := cas.Write(id, []byte("hello")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	f, ok := cas.Open(id)
+	if !ok {
+		t.Fatalf("Open(%s) failed", id)
+	}
+	if got, _ := ioutil.ReadAll(f); string(got) != "hello" {
+		t.Errorf("got %q after verifying, want hello", got)
+	}
+	f.Close()
+
+	corruptBlob(t, cas.path(id))
+	if err := cas.Verify(id); err == nil {
+		t.Errorf("Verify succeeded for corrupt blob")
+	}
+	if f, ok := cas.Open(id); ok {
+		f.Close()
+		t.Errorf("Open succeeded for corrupt blob")
+	}
+	if _, err := os.Stat(cas.path(id)); !os.IsNotExist(err) {
+		t.Errorf("corrupt blob was not removed: %v", err)
+	}
+	if cas.corrupt != 1 {
+		t.Errorf("got corrupt count %d, want 1", cas.corrupt)
+	}
+}
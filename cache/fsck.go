@@ -0,0 +1,100 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"os"
+	"runtime"
+	"sync"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+)
+
+// FsckResult is the outcome of Fsck.
+type FsckResult struct {
+	// Checked is the number of blobs verified.
+	Checked int
+
+	// Corrupt lists the blobs whose content does not match their
+	// SHA1.
+	Corrupt []plumbing.Hash
+
+	// Failed lists the blobs that could not be read, eg. for lack
+	// of permission. They are not removed on repair.
+	Failed []plumbing.Hash
+}
+
+// Fsck verifies the content of all blobs in the cache. If repair is
+// set, corrupt blobs are removed, so the file system fetches them
+// again when they are next read. Blobs that cannot be read are
+// reported, but left alone.
+func (c *Cache) Fsck(repair bool) (*FsckResult, error) {
+	var ids []plumbing.Hash
+	if err := walkObjects(c.Blob.dir, func(id plumbing.Hash, fi os.FileInfo) {
+		ids = append(ids, id)
+	}); err != nil {
+		return nil, err
+	}
+
+	todo := make(chan plumbing.Hash, len(ids))
+	for _, id := range ids {
+		todo <- id
+	}
+	close(todo)
+
+	var mu sync.Mutex
+	res := &FsckResult{}
+	var firstErr error
+	var wg sync.WaitGroup
+	for i := 0; i < runtime.GOMAXPROCS(0); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for id := range todo {
+				err := c.Blob.Verify(id)
+				if os.IsNotExist(err) {
+					// Removed while we were scanning.
+					continue
+				}
+
+				mu.Lock()
+				if _, ok := err.(*corruptError); err != nil && !ok {
+					c.logger.Warningf("verifying blob %s: %v", id, err)
+					res.Failed = append(res.Failed, id)
+					mu.Unlock()
+					continue
+				}
+				res.Checked++
+				if err != nil {
+					c.logger.Warningf("%v", err)
+					res.Corrupt = append(res.Corrupt, id)
+					if fi, err := os.Stat(c.Blob.path(id)); err == nil && linkCount(fi) > 1 {
+						// Removing the blob does not fix
+						// checkouts that hardlink it.
+						c.logger.Warningf("blob %s is hardlinked into %d checkout files", id, linkCount(fi)-1)
+					}
+					if repair {
+						if err := c.Blob.remove(id); err != nil && firstErr == nil {
+							firstErr = err
+						}
+					}
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	return res, firstErr
+}
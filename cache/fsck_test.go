@@ -0,0 +1,80 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+)
+
+func TestFsck(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	c, err := NewCache(dir, Options{FetchFrequency: -1})
+	if err != nil {
+		t.Fatalf("NewCache: %v", err)
+	}
+
+	good := plumbing.ComputeHash(plumbing.BlobObject, []byte("good"))
+	bad := plumbing.NewHash("b6fc4c620b67d95f953a5c1c1230aaab5db5a1b0")
+	if err := c.Blob.Write(good, []byte("good")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := c.Blob.Write(bad, []byte("hello")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	corruptBlob(t, c.Blob.path(bad))
+
+	// Reading a directory fails, without saying anything about
+	// the content.
+	unreadable := plumbing.NewHash("3a3ee2e7e4a7b6d4e9c7c2a2b0d8e6f3c1a9b4d5")
+	if err := os.MkdirAll(c.Blob.path(unreadable), 0755); err != nil {
+		t.Fatalf("MkdirAll: %v", err)
+	}
+
+	res, err := c.Fsck(false)
+	if err != nil {
+		t.Fatalf("Fsck: %v", err)
+	}
+	if res.Checked != 2 || len(res.Corrupt) != 1 || res.Corrupt[0] != bad {
+		t.Errorf("got %+v, want 2 checked and %s corrupt", res, bad)
+	}
+	if len(res.Failed) != 1 || res.Failed[0] != unreadable {
+		t.Errorf("got failed %v, want %s", res.Failed, unreadable)
+	}
+	if _, err := os.Stat(c.Blob.path(bad)); err != nil {
+		t.Errorf("Fsck without repair removed the blob: %v", err)
+	}
+
+	if _, err := c.Fsck(true); err != nil {
+		t.Fatalf("Fsck: %v", err)
+	}
+	if _, err := os.Stat(c.Blob.path(bad)); !os.IsNotExist(err) {
+		t.Errorf("corrupt blob was not removed: %v", err)
+	}
+	if _, err := os.Stat(c.Blob.path(good)); err != nil {
+		t.Errorf("good blob was removed: %v", err)
+	}
+	if _, err := os.Stat(c.Blob.path(unreadable)); err != nil {
+		t.Errorf("unreadable blob was removed: %v", err)
+	}
+}
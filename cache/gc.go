@@ -0,0 +1,275 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/slothfs/manifest"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+)
+
+// Reachable holds the objects that garbage collection should keep.
+type Reachable struct {
+	// Trees and Blobs hold the objects to keep. If nil, all
+	// objects of that kind are kept.
+	Trees map[plumbing.Hash]bool
+	Blobs map[plumbing.Hash]bool
+
+	// Repos holds directories of git repositories.
+	Repos map[string]bool
+
+	// Projects holds project names. Git repositories whose path
+	// ends in one of them are kept, as the clone URL usually
+	// comes from Gitiles rather than the manifest.
+	Projects map[string]bool
+}
+
+// Reachable computes the objects used by the given manifests. If a
+// project is not pinned to a commit SHA1 whose tree is in the cache,
+// its objects cannot be determined, so all trees and blobs are kept.
+func (c *Cache) Reachable(mfs []*manifest.Manifest) (*Reachable, error) {
+	r := &Reachable{
+		Trees:    map[plumbing.Hash]bool{},
+		Blobs:    map[plumbing.Hash]bool{},
+		Repos:    map[string]bool{},
+		Projects: map[string]bool{},
+	}
+	complete := true
+	for _, mf := range mfs {
+		for i := range mf.Project {
+			p := &mf.Project[i]
+			r.Projects[p.Name] = true
+			if p.CloneURL != "" {
+				dir, err := c.Git.gitPath(p.CloneURL)
+				if err != nil {
+					return nil, err
+				}
+				r.Repos[dir] = true
+			}
+
+			rev := mf.ProjectRevision(p)
+			id, err := parseID(rev)
+			if err != nil {
+				c.logger.Warningf("project %s: revision %q is not a commit", p.Name, rev)
+				complete = false
+				continue
+			}
+			tree, err := c.Tree.Get(id)
+			if err != nil {
+				c.logger.Warningf("project %s: tree for %s is not cached", p.Name, rev)
+				complete = false
+				continue
+			}
+			r.Trees[*id] = true
+			if treeID, err := parseID(tree.ID); err == nil {
+				r.Trees[*treeID] = true
+			}
+			for _, e := range tree.Entries {
+				if e.Type != "blob" {
+					continue
+				}
+				blobID, err := parseID(e.ID)
+				if err != nil {
+					return nil, fmt.Errorf("project %s: %v", p.Name, err)
+				}
+				r.Blobs[*blobID] = true
+			}
+		}
+	}
+	if !complete {
+		c.logger.Warningf("keeping all trees and blobs; dereference the manifests to collect them")
+		r.Trees = nil
+		r.Blobs = nil
+	}
+	return r, nil
+}
+
+// keepRepo returns whether the git repository in dir should be kept.
+func (r *Reachable) keepRepo(dir string) bool {
+	if r.Repos[dir] {
+		return true
+	}
+	name := strings.TrimSuffix(filepath.ToSlash(dir), ".git")
+	for p := range r.Projects {
+		if strings.HasSuffix(name, "/"+p) {
+			return true
+		}
+	}
+	return false
+}
+
+// walkObjects calls fn for each object stored under dir, which has
+// the layout used by CAS and TreeCache.
+func walkObjects(dir string, fn func(id plumbing.Hash, fi os.FileInfo)) error {
+	prefixes, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range prefixes {
+		if !p.IsDir() || len(p.Name()) != 3 {
+			continue
+		}
+		entries, err := ioutil.ReadDir(filepath.Join(dir, p.Name()))
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			id, err := parseID(p.Name() + e.Name())
+			if err != nil {
+				continue
+			}
+			fn(*id, e)
+		}
+	}
+	return nil
+}
+
+// objectSizes returns the sizes of the objects stored under dir.
+func objectSizes(dir string) (map[plumbing.Hash]int64, error) {
+	sizes := map[plumbing.Hash]int64{}
+	err := walkObjects(dir, func(id plumbing.Hash, fi os.FileInfo) {
+		sizes[id] = fi.Size()
+	})
+	return sizes, err
+}
+
+// dirSize returns the total size of the files under dir.
+func dirSize(dir string) (int64, error) {
+	var total int64
+	err := filepath.Walk(dir, func(n string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !fi.IsDir() {
+			total += fi.Size()
+		}
+		return nil
+	})
+	return total, err
+}
+
+// gcObjects removes the objects under dir that are not in keep. If
+// keep is nil, nothing is removed.
+func gcObjects(dir string, path func(*plumbing.Hash) string, keep map[plumbing.Hash]bool, dryRun bool) (int64, error) {
+	if keep == nil {
+		return 0, nil
+	}
+	sizes, err := objectSizes(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	var total int64
+	for id, sz := range sizes {
+		if keep[id] {
+			continue
+		}
+		if !dryRun {
+			if err := os.Remove(path(&id)); err != nil {
+				return total, err
+			}
+		}
+		total += sz
+	}
+	return total, nil
+}
+
+// GC removes the trees, blobs and git repositories that are not in
+// keep. It returns the number of bytes reclaimed, or with dryRun set,
+// the number of bytes that would be reclaimed.
+func (c *Cache) GC(keep *Reachable, dryRun bool) (int64, error) {
+	var total int64
+	n, err := gcObjects(c.Tree.dir, c.Tree.path, keep.Trees, dryRun)
+	total += n
+	if err != nil {
+		return total, fmt.Errorf("GC(trees): %v", err)
+	}
+
+	n, err = gcObjects(c.Blob.dir, func(id *plumbing.Hash) string { return c.Blob.path(*id) }, keep.Blobs, dryRun)
+	total += n
+	if err != nil {
+		return total, fmt.Errorf("GC(blobs): %v", err)
+	}
+
+	dirs, err := c.Git.repoDirs()
+	if err != nil {
+		return total, fmt.Errorf("GC(repos): %v", err)
+	}
+	for _, d := range dirs {
+		if keep.keepRepo(d) {
+			continue
+		}
+		n, err := dirSize(d)
+		if err != nil {
+			return total, err
+		}
+		if !dryRun {
+			if err := os.RemoveAll(d); err != nil {
+				return total, err
+			}
+		}
+		total += n
+	}
+	return total, nil
+}
+
+// blobInfo describes a blob on disk for Trim.
+type blobInfo struct {
+	id    plumbing.Hash
+	size  int64
+	atime time.Time
+}
+
+// Trim removes the least recently used blobs until the blob store
+// holds at most maxBytes. Recency is tracked through the access
+// time of the blob files, which CAS.Open refreshes. It
+// returns the number of bytes reclaimed, or with dryRun set, the
+// number of bytes that would be reclaimed.
+func (c *Cache) Trim(maxBytes int64, dryRun bool) (int64, error) {
+	var blobs []blobInfo
+	var total int64
+	if err := walkObjects(c.Blob.dir, func(id plumbing.Hash, fi os.FileInfo) {
+		blobs = append(blobs, blobInfo{id, fi.Size(), atime(fi)})
+		total += fi.Size()
+	}); err != nil {
+		return 0, fmt.Errorf("Trim: %v", err)
+	}
+
+	sort.Slice(blobs, func(i, j int) bool {
+		return blobs[i].atime.Before(blobs[j].atime)
+	})
+
+	var reclaimed int64
+	for _, b := range blobs {
+		if total-reclaimed <= maxBytes {
+			break
+		}
+		if !dryRun {
+			if err := c.Blob.remove(b.id); err != nil {
+				return reclaimed, fmt.Errorf("Trim: %v", err)
+			}
+		}
+		reclaimed += b.size
+	}
+	return reclaimed, nil
+}
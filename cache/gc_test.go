@@ -0,0 +1,235 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/slothfs/gitiles"
+	"github.com/google/slothfs/manifest"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+)
+
+func TestGC(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	c, err := NewCache(dir, Options{FetchFrequency: -1})
+	if err != nil {
+		t.Fatalf("NewCache: %v", err)
+	}
+
+	// addCommit stores a commit with a single file, and returns the
+	// IDs of the commit and the blob.
+	addCommit := func(n int) (plumbing.Hash, plumbing.Hash) {
+		content := []byte(fmt.Sprintf("file %d", n))
+		blobID := plumbing.ComputeHash(plumbing.BlobObject, content)
+		if err := c.Blob.Write(blobID, content); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+		commitID := plumbing.NewHash(fmt.Sprintf("%040x", n))
+		tree := &gitiles.Tree{
+			ID: fmt.Sprintf("%040x", 100+n),
+			Entries: []gitiles.TreeEntry{
+				{Name: "file", Type: "blob", Mode: 0100644, ID: blobID.String()},
+			},
+		}
+		if err := c.Tree.Add(&commitID, tree); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+		return commitID, blobID
+	}
+	keptCommit, keptBlob := addCommit(1)
+	oldCommit, oldBlob := addCommit(2)
+
+	for _, repo := range []string{"host/kept.git", "host/old.git", "host/a/platform/build.git"} {
+		if err := os.MkdirAll(filepath.Join(c.Git.dir, repo), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(c.Git.dir, repo, "HEAD"), []byte("ref: refs/heads/master\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mf := &manifest.Manifest{
+		Project: []manifest.Project{{
+			Name:     "kept",
+			Revision: keptCommit.String(),
+			CloneURL: "https://host/kept",
+		}, {
+			// Without a clone URL, the repository is
+			// recognized by name.
+			Name:     "platform/build",
+			Revision: keptCommit.String(),
+		}},
+	}
+	keep, err := c.Reachable([]*manifest.Manifest{mf})
+	if err != nil {
+		t.Fatalf("Reachable: %v", err)
+	}
+
+	dryRun, err := c.GC(keep, true)
+	if err != nil {
+		t.Fatalf("GC(dry run): %v", err)
+	}
+	if _, err := c.Tree.Get(&oldCommit); err != nil {
+		t.Errorf("dry run removed tree: %v", err)
+	}
+
+	reclaimed, err := c.GC(keep, false)
+	if err != nil {
+		t.Fatalf("GC: %v", err)
+	}
+	if reclaimed == 0 || reclaimed != dryRun {
+		t.Errorf("got %d bytes reclaimed, dry run said %d", reclaimed, dryRun)
+	}
+
+	if _, err := c.Tree.Get(&keptCommit); err != nil {
+		t.Errorf("Get(kept): %v", err)
+	}
+	if _, err := c.Tree.Get(&oldCommit); err == nil {
+		t.Errorf("Get(old) succeeded after GC")
+	}
+	if f, ok := c.Blob.Open(keptBlob); !ok {
+		t.Errorf("Open(kept) failed")
+	} else {
+		f.Close()
+	}
+	if f, ok := c.Blob.Open(oldBlob); ok {
+		f.Close()
+		t.Errorf("Open(old) succeeded after GC")
+	}
+	if _, err := os.Stat(filepath.Join(c.Git.dir, "host/kept.git")); err != nil {
+		t.Errorf("Stat(kept.git): %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(c.Git.dir, "host/a/platform/build.git")); err != nil {
+		t.Errorf("Stat(platform/build.git): %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(c.Git.dir, "host/old.git")); !os.IsNotExist(err) {
+		t.Errorf("Stat(old.git): got %v, want ENOENT", err)
+	}
+}
+
+func TestGCUnpinned(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	c, err := NewCache(dir, Options{FetchFrequency: -1})
+	if err != nil {
+		t.Fatalf("NewCache: %v", err)
+	}
+	content := []byte("file")
+	blobID := plumbing.ComputeHash(plumbing.BlobObject, content)
+	if err := c.Blob.Write(blobID, content); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	// The blobs of a project on a branch are unknown, so nothing
+	// may be removed.
+	mf := &manifest.Manifest{
+		Project: []manifest.Project{{
+			Name:     "platform/build",
+			Revision: "master",
+		}},
+	}
+	keep, err := c.Reachable([]*manifest.Manifest{mf})
+	if err != nil {
+		t.Fatalf("Reachable: %v", err)
+	}
+	if n, err := c.GC(keep, false); err != nil || n != 0 {
+		t.Errorf("GC: got %d, %v, want 0", n, err)
+	}
+	if f, ok := c.Blob.Open(blobID); !ok {
+		t.Errorf("Open failed after GC")
+	} else {
+		f.Close()
+	}
+}
+
+func TestTrim(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	c, err := NewCache(dir, Options{FetchFrequency: -1, BlobMemoryLimit: 1 << 20})
+	if err != nil {
+		t.Fatalf("NewCache: %v", err)
+	}
+
+	// Store blobs of 10 bytes, each older than the next.
+	var ids []plumbing.Hash
+	for i := 0; i < 3; i++ {
+		content := []byte(fmt.Sprintf("content %02d", i))
+		id := plumbing.ComputeHash(plumbing.BlobObject, content)
+		if err := c.Blob.Write(id, content); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+		mtime := time.Now().Add(time.Duration(i-10) * time.Hour)
+		if err := os.Chtimes(c.Blob.path(id), mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+
+	// Opening the oldest blob marks it as recently used, without
+	// changing its modification time.
+	before, err := os.Stat(c.Blob.path(ids[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f, ok := c.Blob.Open(ids[0]); !ok {
+		t.Fatalf("Open failed")
+	} else {
+		f.Close()
+	}
+	if after, err := os.Stat(c.Blob.path(ids[0])); err != nil {
+		t.Fatal(err)
+	} else if !after.ModTime().Equal(before.ModTime()) {
+		t.Errorf("Open changed mtime from %v to %v", before.ModTime(), after.ModTime())
+	}
+
+	if n, err := c.Trim(20, true); err != nil || n != 10 {
+		t.Fatalf("Trim(dry run): got %d, %v, want 10", n, err)
+	}
+	if n, err := c.Trim(20, false); err != nil || n != 10 {
+		t.Fatalf("Trim: got %d, %v, want 10", n, err)
+	}
+
+	for i, want := range []bool{true, false, true} {
+		if _, err := os.Stat(c.Blob.path(ids[i])); (err == nil) != want {
+			t.Errorf("blob %d: got Stat error %v, want present=%v", i, err, want)
+		}
+	}
+	if _, ok := c.Blob.ReadMemory(ids[1]); ok {
+		t.Errorf("trimmed blob is still in memory")
+	}
+
+	if n, err := c.Trim(20, false); err != nil || n != 0 {
+		t.Errorf("Trim(under limit): got %d, %v, want 0", n, err)
+	}
+}
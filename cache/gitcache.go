@@ -17,7 +17,6 @@ package cache
 import (
 	"bytes"
 	"fmt"
-	"log"
 	"net"
 	"net/url"
 	"os"
@@ -27,6 +26,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/google/slothfs/logging"
 	git "gopkg.in/src-d/go-git.v4"
 )
 
@@ -39,6 +39,8 @@ type gitCache struct {
 
 	// Directory to store log files for fetches and clones.
 	logDir string
+
+	logger *logging.Logger
 }
 
 // newGitCache constructs a gitCache object.
@@ -46,6 +48,7 @@ func newGitCache(baseDir string, opts Options) (*gitCache, error) {
 	c := gitCache{
 		dir:    filepath.Join(baseDir),
 		logDir: filepath.Join(baseDir, "slothfs-logs"),
+		logger: opts.Logger.Sub("cache"),
 	}
 	if err := os.MkdirAll(c.logDir, 0700); err != nil {
 		return nil, err
@@ -64,7 +67,7 @@ func (c *gitCache) recurringFetch(freq time.Duration) {
 	ticker := time.NewTicker(freq)
 	for {
 		if err := c.FetchAll(); err != nil {
-			log.Printf("FetchAll: %v", err)
+			c.logger.Errorf("FetchAll: %v", err)
 		}
 		<-ticker.C
 	}
@@ -86,21 +89,34 @@ func (c *gitCache) Fetch(dir string) error {
 	return nil
 }
 
-// FetchAll finds all known repos and runs git-fetch on them.
-func (c *gitCache) FetchAll() error {
+// repoDirs returns the directories of all known repos.
+func (c *gitCache) repoDirs() ([]string, error) {
 	dir, err := filepath.EvalSymlinks(c.dir)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	var dirs []string
 	if err := filepath.Walk(dir, func(n string, fi os.FileInfo, err error) error {
 		if fi.IsDir() && strings.HasSuffix(n, ".git") {
-			dirs = append(dirs, n)
+			rel, err := filepath.Rel(dir, n)
+			if err != nil {
+				return err
+			}
+			dirs = append(dirs, filepath.Join(c.dir, rel))
 			return filepath.SkipDir
 		}
 		return nil
 	}); err != nil {
+		return nil, err
+	}
+	return dirs, nil
+}
+
+// FetchAll finds all known repos and runs git-fetch on them.
+func (c *gitCache) FetchAll() error {
+	dirs, err := c.repoDirs()
+	if err != nil {
 		return err
 	}
 
@@ -159,7 +175,7 @@ func (c *gitCache) runGit(dir string, args ...string) error {
 	}
 
 	if err != nil {
-		log.Printf("ran %s exit %v", cmd.Args, err)
+		c.logger.Warningf("ran %s exit %v", cmd.Args, err)
 	}
 	return runErr
 }
@@ -178,8 +194,9 @@ func (c *gitCache) OpenLocal(url string) *git.Repository {
 }
 
 // Open returns an opened repository for the given URL. If necessary,
-// the repository is cloned.
-func (c *gitCache) Open(url string) (*git.Repository, error) {
+// the repository is cloned. If depth is positive, a new clone is
+// shallow with the given depth.
+func (c *gitCache) Open(url string, depth int) (*git.Repository, error) {
 	// TODO(hanwen): multiple concurrent calls to Open() with the
 	// same URL may race, resulting in a double clone. It's unclear
 	// what will happen in that case.
@@ -193,7 +210,12 @@ func (c *gitCache) Open(url string) (*git.Repository, error) {
 		if err := os.MkdirAll(dir, 0755); err != nil {
 			return nil, err
 		}
-		if err := c.runGit(dir, "clone", "--bare", "--progress", "--verbose", url, base); err != nil {
+		args := []string{"clone", "--bare", "--progress", "--verbose"}
+		if depth > 0 {
+			args = append(args, fmt.Sprintf("--depth=%d", depth))
+		}
+		args = append(args, url, base)
+		if err := c.runGit(dir, args...); err != nil {
 			return nil, err
 		}
 	}
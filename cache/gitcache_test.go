@@ -48,7 +48,7 @@ func TestGitCache(t *testing.T) {
 		t.Errorf("OpenLocal(%s) succeeded", url)
 	}
 
-	lazy := newLazyRepo(url, cache)
+	lazy := newLazyRepo(url, 0, cache)
 	if r := lazy.Repository(); r != nil {
 		t.Errorf("got %v for lazy.Repository", r)
 	}
@@ -0,0 +1,168 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/google/slothfs/gitiles"
+	"github.com/google/slothfs/manifest"
+	git "gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+)
+
+// ImportFromRepo seeds the cache with the trees and blobs of the
+// projects of mf from an existing checkout in dir, which holds a git
+// repository at the path of each project. Only projects pinned to a
+// commit SHA1 are imported, and projects whose repository or commit
+// is not available locally are skipped. It returns the number of
+// projects imported.
+func (c *Cache) ImportFromRepo(dir string, mf *manifest.Manifest) (int, error) {
+	n := 0
+	for i := range mf.Project {
+		p := &mf.Project[i]
+		rev := mf.ProjectRevision(p)
+		id, err := parseID(rev)
+		if err != nil {
+			c.logger.Warningf("project %s: revision %q is not a commit, skipping", p.Name, rev)
+			continue
+		}
+		repo, err := git.PlainOpen(filepath.Join(dir, p.GetPath()))
+		if err != nil {
+			c.logger.Warningf("project %s: %v, skipping", p.Name, err)
+			continue
+		}
+		tree, err := GetTree(repo, id)
+		if err != nil {
+			c.logger.Warningf("project %s: revision %s: %v, skipping", p.Name, rev, err)
+			continue
+		}
+
+		for _, e := range tree.Entries {
+			if e.Type != "blob" {
+				continue
+			}
+			if err := c.importBlob(repo, e.ID); err != nil {
+				return n, fmt.Errorf("ImportFromRepo(%s): project %s: %v", dir, p.Name, err)
+			}
+		}
+		// Add the tree last, so its blobs are present once it is
+		// found in the cache.
+		if err := c.Tree.Add(id, tree); err != nil {
+			return n, fmt.Errorf("ImportFromRepo(%s): project %s: %v", dir, p.Name, err)
+		}
+		n++
+	}
+	return n, nil
+}
+
+// importBlob copies the blob with the given ID from repo into the
+// CAS, unless it is there already.
+func (c *Cache) importBlob(repo *git.Repository, idStr string) error {
+	id := plumbing.NewHash(idStr)
+	if c.hasBlob(id) {
+		return nil
+	}
+
+	blob, err := repo.BlobObject(id)
+	if err != nil {
+		return err
+	}
+	r, err := blob.Reader()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return c.Blob.Write(id, data)
+}
+
+// hasBlob returns whether the CAS holds the blob with the given ID.
+// Unlike Blob.Open, it does not count a hit or miss, or touch the
+// blob.
+func (c *Cache) hasBlob(id plumbing.Hash) bool {
+	_, err := os.Stat(c.Blob.path(id))
+	return err == nil
+}
+
+// ImportArchive downloads a gzipped tarball of the project at
+// revision, and adds its files and symlink targets to the CAS. This
+// fills a cold cache in one request rather than one per blob. It
+// returns the number of blobs added.
+func (c *Cache) ImportArchive(service *gitiles.RepoService, revision string) (int, error) {
+	r, err := service.GetArchive(revision, "", gitiles.ArchiveTgz)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+
+	n, err := c.importTarGz(r)
+	if err != nil {
+		return n, fmt.Errorf("ImportArchive(%s, %s): %v", service.Name, revision, err)
+	}
+	return n, nil
+}
+
+// importTarGz adds the blobs of a gzipped tarball to the CAS.
+func (c *Cache) importTarGz(r io.Reader) (int, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return 0, err
+	}
+	defer gz.Close()
+
+	n := 0
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return n, err
+		}
+
+		var data []byte
+		switch hdr.Typeflag {
+		case tar.TypeReg:
+			if data, err = ioutil.ReadAll(tr); err != nil {
+				return n, err
+			}
+		case tar.TypeSymlink:
+			data = []byte(hdr.Linkname)
+		default:
+			continue
+		}
+
+		id := plumbing.ComputeHash(plumbing.BlobObject, data)
+		if c.hasBlob(id) {
+			continue
+		}
+		if err := c.Blob.Write(id, data); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
@@ -0,0 +1,168 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/slothfs/gitiles"
+	"github.com/google/slothfs/manifest"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+)
+
+func TestImportFromRepo(t *testing.T) {
+	testRepo, err := initTest()
+	if err != nil {
+		t.Fatalf("init: %v", err)
+	}
+	defer testRepo.Cleanup()
+
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	checkout := filepath.Join(dir, "checkout")
+	if err := os.MkdirAll(checkout, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(testRepo.dir, filepath.Join(checkout, "build")); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := NewCache(filepath.Join(dir, "cache"), Options{FetchFrequency: -1})
+	if err != nil {
+		t.Fatalf("NewCache: %v", err)
+	}
+
+	mf := &manifest.Manifest{
+		Project: []manifest.Project{
+			{Name: "platform/build", Path: newString("build"), Revision: testRepo.treeID.String()},
+			{Name: "platform/art", Path: newString("art"), Revision: testRepo.treeID.String()},
+			{Name: "platform/bionic", Path: newString("build"), Revision: "master"},
+		},
+	}
+	n, err := c.ImportFromRepo(checkout, mf)
+	if err != nil {
+		t.Fatalf("ImportFromRepo: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("got %d projects imported, want 1", n)
+	}
+
+	tree, err := c.Tree.Get(testRepo.treeID)
+	if err != nil {
+		t.Fatalf("Tree.Get: %v", err)
+	}
+	if len(tree.Entries) != 3 {
+		t.Errorf("got %d entries, want 3", len(tree.Entries))
+	}
+	for _, e := range tree.Entries {
+		id, err := parseID(e.ID)
+		if err != nil {
+			t.Fatalf("parseID: %v", err)
+		}
+		f, ok := c.Blob.Open(*id)
+		if !ok {
+			t.Errorf("blob %s for %s not imported", e.ID, e.Name)
+			continue
+		}
+		f.Close()
+	}
+}
+
+func TestImportArchive(t *testing.T) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	files := map[string]string{
+		"README":    "hello",
+		"dir/a.txt": "goedemiddag",
+		"dir/b.txt": "hello",
+	}
+	for name, content := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: "dir/", Mode: 0755, Typeflag: tar.TypeDir}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: "link", Linkname: "dir/a.txt", Typeflag: tar.TypeSymlink}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/platform/build/+archive/master.tar.gz" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(buf.Bytes())
+	}))
+	defer ts.Close()
+	service, err := gitiles.NewService(gitiles.Options{Address: ts.URL})
+	if err != nil {
+		t.Fatalf("NewService: %v", err)
+	}
+
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	c, err := NewCache(dir, Options{FetchFrequency: -1})
+	if err != nil {
+		t.Fatalf("NewCache: %v", err)
+	}
+
+	n, err := c.ImportArchive(service.NewRepoService("platform/build"), "master")
+	if err != nil {
+		t.Fatalf("ImportArchive: %v", err)
+	}
+	if n != 3 {
+		t.Errorf("got %d blobs added, want 3", n)
+	}
+	for _, content := range []string{"hello", "goedemiddag", "dir/a.txt"} {
+		id := plumbing.ComputeHash(plumbing.BlobObject, []byte(content))
+		if !c.hasBlob(id) {
+			t.Errorf("blob for %q not imported", content)
+		}
+	}
+
+	if n, err := c.ImportArchive(service.NewRepoService("platform/build"), "master"); err != nil || n != 0 {
+		t.Errorf("second ImportArchive: got %d, %v, want 0 blobs", n, err)
+	}
+	if _, err := c.ImportArchive(service.NewRepoService("platform/art"), "master"); err == nil {
+		t.Errorf("ImportArchive for missing project succeeded")
+	}
+}
@@ -15,7 +15,6 @@
 package cache
 
 import (
-	"log"
 	"sync"
 
 	git "gopkg.in/src-d/go-git.v4"
@@ -25,6 +24,7 @@ import (
 // demand.
 type LazyRepo struct {
 	url   string
+	depth int
 	cache *gitCache
 
 	repoMu  sync.Mutex
@@ -32,9 +32,10 @@ type LazyRepo struct {
 	repo    *git.Repository
 }
 
-func newLazyRepo(url string, cache *gitCache) *LazyRepo {
+func newLazyRepo(url string, depth int, cache *gitCache) *LazyRepo {
 	r := &LazyRepo{
 		url:   url,
+		depth: depth,
 		cache: cache,
 		repo:  cache.OpenLocal(url),
 	}
@@ -43,9 +44,16 @@ func newLazyRepo(url string, cache *gitCache) *LazyRepo {
 }
 
 // NewLazyRepo creates a new repository. If the repository is never to
-// be cloned, url should be set to empty string.
-func NewLazyRepo(url string, cache *Cache) *LazyRepo {
-	return newLazyRepo(url, cache.Git)
+// be cloned, url should be set to empty string. If depth is positive,
+// the clone is shallow with the given depth.
+func NewLazyRepo(url string, depth int, cache *Cache) *LazyRepo {
+	return newLazyRepo(url, depth, cache.Git)
+}
+
+// NewLocalLazyRepo returns a LazyRepo for a repository that is
+// already available, and is never cloned.
+func NewLocalLazyRepo(repo *git.Repository) *LazyRepo {
+	return &LazyRepo{repo: repo}
 }
 
 // Repository returns a git.Repository for this repo, or nil if it
@@ -61,7 +69,7 @@ func (r *LazyRepo) Repository() *git.Repository {
 // runClone initiates a clone. It makes sure that only one clone
 // process runs at any time.
 func (r *LazyRepo) runClone() {
-	repo, err := r.cache.Open(r.url)
+	repo, err := r.cache.Open(r.url, r.depth)
 
 	r.repoMu.Lock()
 	defer r.repoMu.Unlock()
@@ -70,7 +78,7 @@ func (r *LazyRepo) runClone() {
 	r.repo = repo
 
 	if err != nil {
-		log.Printf("runClone: %v", err)
+		r.cache.logger.Errorf("runClone: %v", err)
 	}
 }
 
@@ -0,0 +1,124 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+)
+
+// blobLRU keeps the content of small blobs in memory. When over its
+// byte limit, it evicts the least recently used blobs.
+type blobLRU struct {
+	mu sync.Mutex
+
+	maxBytes int64
+
+	// maxEntry is the largest blob that is kept, so a few large
+	// blobs cannot evict everything else.
+	maxEntry int64
+
+	size int64
+
+	// order holds *lruEntry, most recently used first.
+	order   *list.List
+	entries map[plumbing.Hash]*list.Element
+}
+
+type lruEntry struct {
+	id   plumbing.Hash
+	data []byte
+
+	// touched is when the access time of the blob on disk was
+	// last refreshed.
+	touched time.Time
+}
+
+func newBlobLRU(maxBytes int64) *blobLRU {
+	return &blobLRU{
+		maxBytes: maxBytes,
+		maxEntry: maxBytes / 16,
+		order:    list.New(),
+		entries:  map[plumbing.Hash]*list.Element{},
+	}
+}
+
+func (c *blobLRU) get(id plumbing.Hash) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[id]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(e)
+	return e.Value.(*lruEntry).data, true
+}
+
+// touchDue returns whether the access time of blob id on disk should
+// be refreshed, and if so, records that it is.
+func (c *blobLRU) touchDue(id plumbing.Hash) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[id]
+	if !ok {
+		return false
+	}
+	entry := e.Value.(*lruEntry)
+	now := time.Now()
+	if now.Sub(entry.touched) <= touchInterval {
+		return false
+	}
+	entry.touched = now
+	return true
+}
+
+func (c *blobLRU) add(id plumbing.Hash, data []byte) {
+	if int64(len(data)) > c.maxEntry {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[id]; ok {
+		c.order.MoveToFront(e)
+		return
+	}
+	// The blob was just written or opened, which refreshes the
+	// access time.
+	c.entries[id] = c.order.PushFront(&lruEntry{id, data, time.Now()})
+	c.size += int64(len(data))
+	for c.size > c.maxBytes {
+		last := c.order.Back()
+		victim := last.Value.(*lruEntry)
+		c.order.Remove(last)
+		delete(c.entries, victim.id)
+		c.size -= int64(len(victim.data))
+	}
+}
+
+func (c *blobLRU) remove(id plumbing.Hash) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[id]
+	if !ok {
+		return
+	}
+	c.order.Remove(e)
+	delete(c.entries, id)
+	c.size -= int64(len(e.Value.(*lruEntry).data))
+}
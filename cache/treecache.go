@@ -22,6 +22,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sync/atomic"
 
 	"github.com/google/slothfs/gitiles"
 	"gopkg.in/src-d/go-git.v4/plumbing"
@@ -33,6 +34,11 @@ import (
 
 // A TreeCache caches recursively expanded trees by their git commit and tree IDs.
 type TreeCache struct {
+	// hits and misses are first, so they are 64-bit aligned for
+	// atomic access.
+	hits   int64
+	misses int64
+
 	dir string
 }
 
@@ -53,8 +59,10 @@ func (c *TreeCache) path(id *plumbing.Hash) string {
 func (c *TreeCache) Get(id *plumbing.Hash) (*gitiles.Tree, error) {
 	content, err := ioutil.ReadFile(c.path(id))
 	if err != nil {
+		atomic.AddInt64(&c.misses, 1)
 		return nil, err
 	}
+	atomic.AddInt64(&c.hits, 1)
 	var t gitiles.Tree
 	if err := json.Unmarshal(content, &t); err != nil {
 		return nil, err
@@ -91,7 +91,7 @@ func TestTreeCache(t *testing.T) {
 		t.Fatalf("TempDir: %v", err)
 	}
 
-	cache := &TreeCache{dir}
+	cache := &TreeCache{dir: dir}
 
 	treeResp, err := GetTree(testRepo.repo, testRepo.treeID)
 	if err != nil {
@@ -0,0 +1,106 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// slothfs-cache-gc removes trees, blobs and git repositories from
+// the slothfs cache that are not used by any of the given manifests.
+// With -max_size, it also evicts the least recently used blobs until
+// the blob store fits.
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/google/slothfs/cache"
+	"github.com/google/slothfs/config"
+	"github.com/google/slothfs/logging"
+	"github.com/google/slothfs/manifest"
+)
+
+func main() {
+	cacheDir := flag.String("cache", filepath.Join(os.Getenv("HOME"), ".cache", "slothfs"),
+		"Set the directory holding the filesystem cache.")
+	manifestDir := flag.String("manifest_dir", filepath.Join(os.Getenv("HOME"), ".config", "slothfs", "manifests"),
+		"Keep objects for all manifests in this directory. Set to empty to only use the arguments.")
+	dryRun := flag.Bool("dry_run", false, "Only report how many bytes would be reclaimed.")
+	maxSize := flag.Int64("max_size", 0, "If positive, evict least recently used blobs until the blob store holds at most this many bytes.")
+	logOptions := logging.DefineFlags()
+	if err := config.Parse(); err != nil {
+		log.Fatal(err)
+	}
+
+	logger, err := logging.New(*logOptions)
+	if err != nil {
+		log.Fatal(err)
+	}
+	logging.SetDefault(logger)
+
+	names := flag.Args()
+	if *manifestDir != "" {
+		entries, err := ioutil.ReadDir(*manifestDir)
+		if err != nil {
+			log.Fatalf("ReadDir: %v", err)
+		}
+		for _, e := range entries {
+			names = append(names, filepath.Join(*manifestDir, e.Name()))
+		}
+	}
+	if len(names) == 0 && *maxSize <= 0 {
+		log.Fatal("no manifests given; refusing to remove the entire cache.")
+	}
+
+	var mfs []*manifest.Manifest
+	for _, nm := range names {
+		mf, err := manifest.ParseFile(nm)
+		if err != nil {
+			log.Fatalf("ParseFile(%s): %v", nm, err)
+		}
+		mfs = append(mfs, mf)
+	}
+
+	c, err := cache.NewCache(*cacheDir, cache.Options{FetchFrequency: -1})
+	if err != nil {
+		log.Fatalf("NewCache: %v", err)
+	}
+
+	var n int64
+	if len(mfs) > 0 {
+		keep, err := c.Reachable(mfs)
+		if err != nil {
+			log.Fatalf("Reachable: %v", err)
+		}
+
+		n, err = c.GC(keep, *dryRun)
+		if err != nil {
+			log.Fatalf("GC: %v", err)
+		}
+	}
+	if *maxSize > 0 {
+		// In a dry run, blobs that GC would remove are still on
+		// disk, so the total may be overestimated.
+		trimmed, err := c.Trim(*maxSize, *dryRun)
+		if err != nil {
+			log.Fatalf("Trim: %v", err)
+		}
+		n += trimmed
+	}
+	if *dryRun {
+		log.Printf("%d bytes reclaimable", n)
+	} else {
+		log.Printf("reclaimed %d bytes", n)
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// slothfs-cache-import seeds the slothfs cache with the trees and
+// blobs of a manifest, either from an existing checkout, or from
+// Gitiles archives, which take one request per project.
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/google/slothfs/cache"
+	"github.com/google/slothfs/config"
+	"github.com/google/slothfs/gitiles"
+	"github.com/google/slothfs/logging"
+	"github.com/google/slothfs/manifest"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+)
+
+func main() {
+	cacheDir := flag.String("cache", filepath.Join(os.Getenv("HOME"), ".cache", "slothfs"),
+		"Set the directory holding the filesystem cache.")
+	manifestFile := flag.String("manifest", "", "Import the projects of this manifest.")
+	archive := flag.Bool("archive", false, "Download the projects as archives from Gitiles, rather than reading a checkout.")
+	gitilesOptions := gitiles.DefineFlags()
+	logOptions := logging.DefineFlags()
+	if err := config.Parse(); err != nil {
+		log.Fatal(err)
+	}
+
+	logger, err := logging.New(*logOptions)
+	if err != nil {
+		log.Fatal(err)
+	}
+	logging.SetDefault(logger)
+
+	if *manifestFile == "" {
+		log.Fatal("must set -manifest")
+	}
+	if *archive && len(flag.Args()) != 0 || !*archive && len(flag.Args()) != 1 {
+		log.Fatal("usage: slothfs-cache-import -manifest FILE (CHECKOUT-DIR | -archive)")
+	}
+
+	mf, err := manifest.ParseFile(*manifestFile)
+	if err != nil {
+		log.Fatalf("ParseFile(%s): %v", *manifestFile, err)
+	}
+
+	c, err := cache.NewCache(*cacheDir, cache.Options{FetchFrequency: -1})
+	if err != nil {
+		log.Fatalf("NewCache: %v", err)
+	}
+
+	if *archive {
+		service, err := gitiles.NewService(*gitilesOptions)
+		if err != nil {
+			log.Fatalf("NewService: %v", err)
+		}
+		importArchives(c, service, mf)
+		return
+	}
+
+	n, err := c.ImportFromRepo(flag.Arg(0), mf)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("imported %d of %d projects", n, len(mf.Project))
+}
+
+// importArchives fetches the tree and an archive of each project of mf
+// that is pinned to a commit.
+func importArchives(c *cache.Cache, service *gitiles.Service, mf *manifest.Manifest) {
+	blobs := 0
+	for i := range mf.Project {
+		p := &mf.Project[i]
+		rev := mf.ProjectRevision(p)
+		id := plumbing.NewHash(rev)
+		if id.String() != rev {
+			log.Printf("project %s: revision %q is not a commit, skipping", p.Name, rev)
+			continue
+		}
+
+		repo := service.NewRepoService(p.Name)
+		n, err := c.ImportArchive(repo, rev)
+		if err != nil {
+			log.Fatal(err)
+		}
+		blobs += n
+		tree, err := repo.GetTree(rev, "", true)
+		if err != nil {
+			log.Fatalf("GetTree(%s, %s): %v", p.Name, rev, err)
+		}
+		if err := c.Tree.Add(&id, tree); err != nil {
+			log.Fatalf("Tree.Add: %v", err)
+		}
+	}
+	log.Printf("imported %d blobs", blobs)
+}
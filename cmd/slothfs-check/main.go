@@ -0,0 +1,102 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// slothfs-check verifies that manifests can be mounted, without
+// mounting them: all project trees must be fetchable, and all
+// copyfile and linkfile sources must exist. It exits with a nonzero
+// status if there are problems.
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/slothfs/cache"
+	"github.com/google/slothfs/config"
+	"github.com/google/slothfs/fs"
+	"github.com/google/slothfs/gitiles"
+	"github.com/google/slothfs/logging"
+	"github.com/google/slothfs/manifest"
+)
+
+func main() {
+	cacheDir := flag.String("cache", filepath.Join(os.Getenv("HOME"), ".cache", "slothfs"),
+		"Set the directory holding the filesystem cache.")
+	gitilesOptions := gitiles.DefineFlags()
+	remoteURLs := flag.String("remote_gitiles_urls", "",
+		"Set comma separated REMOTE=URL pairs, to fetch projects on these manifest remotes from a different Gitiles server.")
+	logOptions := logging.DefineFlags()
+	if err := config.Parse(); err != nil {
+		log.Fatal(err)
+	}
+
+	logger, err := logging.New(*logOptions)
+	if err != nil {
+		log.Fatal(err)
+	}
+	logging.SetDefault(logger)
+
+	if len(flag.Args()) == 0 {
+		log.Fatal("usage: slothfs-check MANIFEST...")
+	}
+
+	c, err := cache.NewCache(*cacheDir, cache.Options{FetchFrequency: -1})
+	if err != nil {
+		log.Fatalf("NewCache: %v", err)
+	}
+
+	service, err := gitiles.NewService(*gitilesOptions)
+	if err != nil {
+		log.Fatalf("NewService: %v", err)
+	}
+	if err := service.Ping(); err != nil {
+		log.Fatal(err)
+	}
+
+	remotes := map[string]*gitiles.Service{}
+	if *remoteURLs != "" {
+		for _, pair := range strings.Split(*remoteURLs, ",") {
+			kv := strings.SplitN(pair, "=", 2)
+			if len(kv) != 2 {
+				log.Fatalf("-remote_gitiles_urls: %q is not REMOTE=URL", pair)
+			}
+			opts := *gitilesOptions
+			opts.Address = kv[1]
+			remotes[kv[0]], err = gitiles.NewService(opts)
+			if err != nil {
+				log.Fatalf("NewService(%s): %v", kv[1], err)
+			}
+		}
+	}
+
+	failed := false
+	for _, nm := range flag.Args() {
+		mf, err := manifest.ParseFile(nm)
+		if err == nil {
+			err = fs.CheckManifest(c, service, remotes, mf)
+		}
+		if err != nil {
+			log.Printf("%s: %v", nm, err)
+			failed = true
+			continue
+		}
+		log.Printf("%s: OK", nm)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
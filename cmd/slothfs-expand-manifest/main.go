@@ -0,0 +1,110 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// slothfs-expand-manifest fetches a manifest and pins all projects to
+// the commits their branches point to, filling in clone URLs.
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/slothfs/config"
+	"github.com/google/slothfs/gitiles"
+	"github.com/google/slothfs/logging"
+	"github.com/google/slothfs/manifest"
+	"github.com/google/slothfs/populate"
+)
+
+func main() {
+	gitilesOptions := gitiles.DefineFlags()
+	repo := flag.String("repo", "platform/manifest", "Set the repository holding the manifest.")
+	branch := flag.String("branch", "master", "Set the branch or commit SHA1 of the manifest repository.")
+	manifestFile := flag.String("manifest_file", "", "Read the manifest from this file instead of fetching it. Use - for stdin.")
+	groups := flag.String("groups", "", "Select projects by comma separated groups, as for repo init -g. Prefix a group with - to exclude it. By default, notdefault projects are dropped.")
+	platform := flag.String("platform", "auto", "Also select projects for this platform: auto, all, none, linux, darwin or windows.")
+	output := flag.String("output", "", "Write the expanded manifest to this file. Defaults to stdout.")
+	logOptions := logging.DefineFlags()
+	if err := config.Parse(); err != nil {
+		log.Fatal(err)
+	}
+
+	logger, err := logging.New(*logOptions)
+	if err != nil {
+		log.Fatal(err)
+	}
+	logging.SetDefault(logger)
+
+	service, err := gitiles.NewService(*gitilesOptions)
+	if err != nil {
+		log.Fatalf("NewService: %v", err)
+	}
+	if err := service.Ping(); err != nil {
+		log.Fatal(err)
+	}
+
+	opts := populate.ExpandOptions{
+		Repo:     *repo,
+		Branch:   *branch,
+		Platform: *platform,
+	}
+	if *groups != "" {
+		opts.Groups = strings.Split(*groups, ",")
+	}
+
+	if *manifestFile == "-" {
+		opts.Manifest, err = manifest.ParseReader(os.Stdin, "<stdin>")
+		if err != nil {
+			log.Fatalf("ParseReader: %v", err)
+		}
+	} else if *manifestFile != "" {
+		opts.Manifest, err = manifest.ParseFile(*manifestFile)
+		if err != nil {
+			log.Fatalf("ParseFile(%s): %v", *manifestFile, err)
+		}
+	}
+	if opts.Manifest != nil {
+		// Included files are read relative to the manifest file,
+		// or for stdin, the current directory.
+		if err := opts.Manifest.Resolve(manifest.ResolveOptions{
+			Name: *manifestFile,
+			Load: manifest.DirLoader(filepath.Dir(*manifestFile)),
+		}); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	mf, err := populate.ExpandManifest(service, opts)
+	if err != nil {
+		log.Fatalf("ExpandManifest: %v", err)
+	}
+
+	content, err := mf.MarshalXMLIndent()
+	if err != nil {
+		log.Fatalf("MarshalXMLIndent: %v", err)
+	}
+
+	if *output == "" {
+		_, err = os.Stdout.Write(content)
+	} else {
+		err = ioutil.WriteFile(*output, content, 0644)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
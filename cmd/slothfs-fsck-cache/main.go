@@ -0,0 +1,69 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// slothfs-fsck-cache verifies that the blobs in the slothfs cache
+// match their SHA1, and with -repair, removes the corrupt ones so they
+// are fetched again.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/google/slothfs/cache"
+	"github.com/google/slothfs/config"
+	"github.com/google/slothfs/logging"
+)
+
+func main() {
+	cacheDir := flag.String("cache", filepath.Join(os.Getenv("HOME"), ".cache", "slothfs"),
+		"Set the directory holding the filesystem cache.")
+	repair := flag.Bool("repair", false, "Remove corrupt blobs, so they are fetched again when read.")
+	logOptions := logging.DefineFlags()
+	if err := config.Parse(); err != nil {
+		log.Fatal(err)
+	}
+
+	logger, err := logging.New(*logOptions)
+	if err != nil {
+		log.Fatal(err)
+	}
+	logging.SetDefault(logger)
+
+	c, err := cache.NewCache(*cacheDir, cache.Options{FetchFrequency: -1})
+	if err != nil {
+		log.Fatalf("NewCache: %v", err)
+	}
+
+	res, err := c.Fsck(*repair)
+	if err != nil {
+		log.Fatalf("Fsck: %v", err)
+	}
+	for _, id := range res.Corrupt {
+		fmt.Println(id)
+	}
+	log.Printf("checked %d blobs, %d corrupt", res.Checked, len(res.Corrupt))
+	if len(res.Failed) > 0 {
+		log.Printf("could not read %d blobs; check the cache permissions", len(res.Failed))
+	}
+	if len(res.Corrupt) > 0 && *repair {
+		log.Printf("removed corrupt blobs")
+	}
+	if len(res.Failed) > 0 || (len(res.Corrupt) > 0 && !*repair) {
+		os.Exit(1)
+	}
+}
@@ -25,13 +25,24 @@ import (
 	"os"
 	"sync"
 
+	"github.com/google/slothfs/config"
 	"github.com/google/slothfs/gitiles"
+	"github.com/google/slothfs/logging"
 )
 
 func main() {
 	tap := flag.Bool("tap", false, "Tap traffic exchanged with $http_proxy")
 	gitilesOptions := gitiles.DefineFlags()
-	flag.Parse()
+	logOptions := logging.DefineFlags()
+	if err := config.Parse(); err != nil {
+		log.Fatal(err)
+	}
+
+	logger, err := logging.New(*logOptions)
+	if err != nil {
+		log.Fatal(err)
+	}
+	logging.SetDefault(logger)
 
 	if *tap {
 		tapTraffic()
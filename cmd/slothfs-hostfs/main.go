@@ -19,20 +19,39 @@ import (
 	"log"
 	"os"
 	"path/filepath"
-	"time"
 
 	"github.com/google/slothfs/cache"
+	"github.com/google/slothfs/config"
 	"github.com/google/slothfs/fs"
 	"github.com/google/slothfs/gitiles"
+	"github.com/google/slothfs/logging"
+	"github.com/google/slothfs/policy"
 	fusefs "github.com/hanwen/go-fuse/fs"
 )
 
 func main() {
 	debug := flag.Bool("debug", false, "Print FUSE debug info.")
+	metricsAddr := flag.String("metrics_addr", "", "If set, serve Prometheus metrics at /metrics on this address.")
 	cacheDir := flag.String("cache", filepath.Join(os.Getenv("HOME"), ".cache", "slothfs"),
 		"Set directory for file system cache.")
+	verifyFraction := flag.Float64("cache_verify", 0, "Set the fraction of cached blob opens, between 0 and 1, that verify the SHA1. Corrupt blobs are fetched again.")
+	policyFile := flag.String("clone_policy", "", "Read the policy deciding which files trigger a git clone from this file.")
 	gitilesOptions := gitiles.DefineFlags()
-	flag.Parse()
+	kernelCache := fs.DefineKernelCacheFlags()
+	serveOptions := fs.DefineServeFlags()
+	logOptions := logging.DefineFlags()
+	if err := config.Parse(); err != nil {
+		log.Fatal(err)
+	}
+	if err := fs.Daemonize(*serveOptions); err != nil {
+		log.Fatal(err)
+	}
+
+	logger, err := logging.New(*logOptions)
+	if err != nil {
+		log.Fatal(err)
+	}
+	logging.SetDefault(logger)
 
 	if *cacheDir == "" {
 		log.Fatal("must set --cache")
@@ -42,7 +61,7 @@ func main() {
 	}
 
 	mntDir := flag.Arg(0)
-	cache, err := cache.NewCache(*cacheDir, cache.Options{})
+	cache, err := cache.NewCache(*cacheDir, cache.Options{VerifyFraction: *verifyFraction})
 	if err != nil {
 		log.Fatalf("NewCache: %v", err)
 	}
@@ -52,22 +71,32 @@ func main() {
 		log.Fatalf("NewService: %v", err)
 	}
 
-	root, err := fs.NewHostFS(cache, service, nil)
+	var clonePolicy *policy.Policy
+	if *policyFile != "" {
+		clonePolicy, err = policy.ReadFile(*policyFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	root, err := fs.NewHostFS(cache, service, nil, clonePolicy)
 	if err != nil {
 		log.Fatalf("NewService: %v", err)
 	}
 
-	h := time.Hour
-	fuseOpts := &fusefs.Options{
-		EntryTimeout:    &h,
-		NegativeTimeout: &h,
-		AttrTimeout:     &h,
+	if *metricsAddr != "" {
+		if err := fs.ServeMetrics(*metricsAddr, cache, service); err != nil {
+			log.Fatal(err)
+		}
 	}
-	fuseOpts.Debug = *debug
+
+	fuseOpts := fs.MountOptions("slothfs", *debug, *kernelCache)
 	server, err := fusefs.Mount(mntDir, root, fuseOpts)
 	if err != nil {
 		log.Fatalf("MountFileSystem: %v", err)
 	}
 	log.Printf("Started gitiles fs FUSE on %s", mntDir)
-	server.Serve()
+	if err := fs.Serve(server, mntDir, *serveOptions); err != nil {
+		log.Fatal(err)
+	}
 }
@@ -0,0 +1,91 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// slothfs-localgitfs mounts a revision of a local git repository,
+// without contacting a Gitiles server.
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/google/slothfs/cache"
+	"github.com/google/slothfs/config"
+	"github.com/google/slothfs/fs"
+	"github.com/google/slothfs/logging"
+	fusefs "github.com/hanwen/go-fuse/fs"
+)
+
+func main() {
+	revision := flag.String("revision", "HEAD", "Set the revision to mount.")
+	debug := flag.Bool("debug", false, "Print FUSE debug info.")
+	metricsAddr := flag.String("metrics_addr", "", "If set, serve Prometheus metrics at /metrics on this address.")
+	cacheDir := flag.String("cache", filepath.Join(os.Getenv("HOME"), ".cache", "slothfs"),
+		"Set directory for file system cache.")
+	verifyFraction := flag.Float64("cache_verify", 0, "Set the fraction of cached blob opens, between 0 and 1, that verify the SHA1. Corrupt blobs are fetched again.")
+	metaDir := flag.String("meta_dir", fs.DefaultMetaDir, "Set the name of the metadata directory.")
+	traceAccess := flag.Bool("trace_access", false, "Record file accesses in access.log in the metadata directory.")
+	kernelCache := fs.DefineKernelCacheFlags()
+	serveOptions := fs.DefineServeFlags()
+	logOptions := logging.DefineFlags()
+	if err := config.Parse(); err != nil {
+		log.Fatal(err)
+	}
+	if err := fs.Daemonize(*serveOptions); err != nil {
+		log.Fatal(err)
+	}
+
+	logger, err := logging.New(*logOptions)
+	if err != nil {
+		log.Fatal(err)
+	}
+	logging.SetDefault(logger)
+
+	if len(flag.Args()) != 2 {
+		log.Fatal("usage: slothfs-localgitfs [-revision REV] REPO-DIR MOUNT-POINT")
+	}
+	repoDir, mntDir := flag.Arg(0), flag.Arg(1)
+
+	c, err := cache.NewCache(*cacheDir, cache.Options{FetchFrequency: -1, VerifyFraction: *verifyFraction})
+	if err != nil {
+		log.Fatalf("NewCache: %v", err)
+	}
+
+	root, err := fs.NewLocalGitRoot(c, repoDir, *revision, fs.GitilesOptions{
+		MetaDir:     *metaDir,
+		TraceAccess: *traceAccess,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *metricsAddr != "" {
+		if err := fs.ServeMetrics(*metricsAddr, c, nil); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	fuseOpts := fs.MountOptions(filepath.Base(repoDir), *debug, *kernelCache)
+
+	server, err := fusefs.Mount(mntDir, root, fuseOpts)
+	if err != nil {
+		log.Fatalf("MountFileSystem: %v", err)
+	}
+	log.Printf("Started local git fs FUSE on %s", mntDir)
+	if err := fs.Serve(server, mntDir, *serveOptions); err != nil {
+		log.Fatal(err)
+	}
+}
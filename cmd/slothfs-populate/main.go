@@ -16,7 +16,9 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"flag"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
@@ -24,7 +26,9 @@ import (
 	"strings"
 	"time"
 
+	"github.com/google/slothfs/config"
 	"github.com/google/slothfs/gitiles"
+	"github.com/google/slothfs/logging"
 	"github.com/google/slothfs/populate"
 )
 
@@ -55,15 +59,15 @@ func syncManifest(opts *gitiles.Options, mountPoint, repo, branch string) (strin
 	if err != nil {
 		return "", err
 	}
-
-	mf, err := populate.FetchManifest(service, repo, branch)
-	if err != nil {
+	if err := service.Ping(); err != nil {
 		return "", err
 	}
 
-	mf.Filter()
-
-	if err := populate.DerefManifest(service, mf); err != nil {
+	mf, err := populate.ExpandManifest(service, populate.ExpandOptions{
+		Repo:   repo,
+		Branch: branch,
+	})
+	if err != nil {
 		return "", err
 	}
 
@@ -93,11 +97,50 @@ func syncManifest(opts *gitiles.Options, mountPoint, repo, branch string) (strin
 func main() {
 	gitilesOptions := gitiles.DefineFlags()
 	newROWorkspace := flag.String("ro", "", "Set path to slothfs-repofs mount.")
-	mount := flag.String("mount", "", "Set slothfs mountpoint for -sync option. Autodetected if empty.")
+	mount := flag.String("mount", "", "Set slothfs mountpoint for -sync and -list options. Autodetected if empty.")
 	sync := flag.Bool("sync", false, "Sync checkout to latest manifest version.")
 	syncBranch := flag.String("sync_branch", "master", "Use this branch for -sync.")
 	syncRepo := flag.String("sync_repo", "platform/manifest", "Use this repo for -sync.")
-	flag.Parse()
+	timeout := flag.Duration("timeout", 0, "Give up if reading the trees takes longer than this. Zero means no limit.")
+	ignoreDirs := flag.String("ignore_dirs", strings.Join(populate.DefaultIgnoreDirs, ","),
+		"Skip these comma separated directory patterns in the workspace. Patterns starting with / are relative to the repository root.")
+	jobs := flag.Int("j", 0, "Read at most this many directories in parallel. Defaults to GOMAXPROCS.")
+	incremental := flag.Bool("incremental", false, "Only update symlinks that changed, rather than recreating all of them.")
+	plainRO := flag.Bool("plain_ro", false, "Allow a -ro directory without slothfs metadata, eg. a read-only snapshot of a checkout.")
+	metaDir := flag.String("meta_dir", populate.DefaultMetaDir, "Read slothfs metadata from directories with this name in the -ro checkout.")
+	touch := flag.String("touch", string(populate.TouchNow), "Set how changed files are touched: now, committime or none.")
+	linkMode := flag.String("link_mode", string(populate.LinkSymlink), "Set how files are placed in the checkout: symlink, copy or hardlink.")
+	cacheDir := flag.String("cache", filepath.Join(os.Getenv("HOME"), ".cache", "slothfs"),
+		"Set the directory holding the filesystem cache, for -link_mode=hardlink.")
+	dryRun := flag.Bool("dry_run", false, "Print the symlinks that would be created and removed and the files that would be touched, without changing the checkout.")
+	list := flag.Bool("list", false, "List the workspaces configured in the slothfs mount, and exit.")
+	logOptions := logging.DefineFlags()
+	if err := config.Parse(); err != nil {
+		log.Fatal(err)
+	}
+
+	logger, err := logging.New(*logOptions)
+	if err != nil {
+		log.Fatal(err)
+	}
+	logging.SetDefault(logger)
+
+	if *list {
+		if *mount == "" {
+			*mount = findSlothFSMount()
+			if *mount == "" {
+				log.Fatal("could not autodetect mount point. Pass --mount option.")
+			}
+		}
+		workspaces, err := populate.ListWorkspaces(*mount)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, ws := range workspaces {
+			fmt.Printf("%s\t%s\t%d projects\n", ws.Name, ws.Fingerprint, ws.Projects)
+		}
+		return
+	}
 
 	dir := "."
 	if len(flag.Args()) == 1 {
@@ -106,6 +149,10 @@ func main() {
 		log.Fatal("too many arguments.")
 	}
 
+	if *sync && *dryRun {
+		log.Fatal("-dry_run cannot be combined with -sync, which configures a new workspace. Pass -ro instead.")
+	}
+
 	if *sync {
 		if *mount == "" {
 			*mount = findSlothFSMount()
@@ -125,34 +172,68 @@ func main() {
 		log.Fatalf("no readonly checkout given. Specify -ro DIR or -sync.")
 	}
 
-	log.Printf("creating symlinks to %s", *newROWorkspace)
+	if !*dryRun {
+		log.Printf("creating symlinks to %s", *newROWorkspace)
+	}
 
-	added, changed, err := populate.Checkout(*newROWorkspace, dir)
+	opts := populate.CheckoutOptions{
+		Incremental: *incremental,
+		IgnoreDirs:  []string{},
+		Jobs:        *jobs,
+		PlainRO:     *plainRO,
+		MetaDir:     *metaDir,
+		TouchMode:   populate.TouchMode(*touch),
+		LinkMode:    populate.LinkMode(*linkMode),
+		CacheDir:    *cacheDir,
+		DryRun:      *dryRun,
+	}
+	if opts.TouchMode == populate.TouchCommitTime {
+		service, err := gitiles.NewService(*gitilesOptions)
+		if err != nil {
+			log.Fatalf("NewService: %v", err)
+		}
+		opts.Service = service
+	}
+	if *ignoreDirs != "" {
+		opts.IgnoreDirs = strings.Split(*ignoreDirs, ",")
+	}
+	ctx := context.Background()
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+	res, err := populate.Checkout(ctx, *newROWorkspace, dir, opts)
 	if err != nil {
 		log.Fatalf("populate.Checkout: %v", err)
 	}
 
-	if len(changed) > 0 {
-		now := time.Now()
-		n := 0
-		for _, slice := range [][]string{added, changed} {
-			for _, c := range slice {
-				err := os.Chtimes(c, now, now)
-				if os.IsNotExist(err) {
-					fi, statErr := os.Lstat(c)
-					if statErr == nil && fi.Mode()&os.ModeSymlink != 0 {
-						// Ignore broken symlinks.
-						err = nil
-					}
-				}
-				if err != nil {
-					log.Fatalf("Chtimes(%s): %v", c, err)
+	if *dryRun {
+		for _, p := range res.Removed {
+			fmt.Printf("remove %s\n", p)
+		}
+		for _, p := range res.Created {
+			fmt.Printf("create %s\n", p)
+		}
+		if len(res.Changed) > 0 && opts.TouchMode != populate.TouchNone {
+			for _, files := range [][]string{res.Added, res.Changed} {
+				for _, p := range files {
+					fmt.Printf("touch %s\n", p)
 				}
-				n++
 			}
 		}
-		log.Printf("touched %d files", n)
+		return
+	}
+
+	if res.PreviousWorkspace != "" {
+		log.Printf("switched from %s: created %d symlinks, removed %d", res.PreviousWorkspace, len(res.Created), len(res.Removed))
+	} else {
+		log.Printf("created %d symlinks, removed %d", len(res.Created), len(res.Removed))
+	}
+
+	if len(res.Changed) > 0 {
+		log.Printf("touched %d files", res.Touched)
 	} else {
-		log.Printf("no files were changed, %d were added; assuming fresh checkout.", len(added))
+		log.Printf("no files were changed, %d were added; assuming fresh checkout.", len(res.Added))
 	}
 }
@@ -0,0 +1,102 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// slothfs-prefetch fetches the blobs for a list of workspace paths,
+// eg. the files read by a previous build, into the slothfs cache, so
+// a cold mount does not fetch them one at a time.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/slothfs/cache"
+	"github.com/google/slothfs/config"
+	"github.com/google/slothfs/fs"
+	"github.com/google/slothfs/gitiles"
+	"github.com/google/slothfs/logging"
+	"github.com/google/slothfs/manifest"
+)
+
+// readPaths reads one path per line, skipping empty lines.
+func readPaths(r io.Reader) ([]string, error) {
+	var paths []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if p := strings.TrimSpace(scanner.Text()); p != "" {
+			paths = append(paths, p)
+		}
+	}
+	return paths, scanner.Err()
+}
+
+func main() {
+	cacheDir := flag.String("cache", filepath.Join(os.Getenv("HOME"), ".cache", "slothfs"),
+		"Set the directory holding the filesystem cache.")
+	manifestFile := flag.String("manifest", "", "Set the manifest describing the workspace.")
+	gitilesOptions := gitiles.DefineFlags()
+	logOptions := logging.DefineFlags()
+	if err := config.Parse(); err != nil {
+		log.Fatal(err)
+	}
+
+	logger, err := logging.New(*logOptions)
+	if err != nil {
+		log.Fatal(err)
+	}
+	logging.SetDefault(logger)
+
+	if *manifestFile == "" || len(flag.Args()) != 1 {
+		log.Fatal("usage: slothfs-prefetch -manifest FILE PATH-LIST")
+	}
+
+	mf, err := manifest.ParseFile(*manifestFile)
+	if err != nil {
+		log.Fatalf("ParseFile(%s): %v", *manifestFile, err)
+	}
+
+	in := os.Stdin
+	if nm := flag.Arg(0); nm != "-" {
+		in, err = os.Open(nm)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer in.Close()
+	}
+	paths, err := readPaths(in)
+	if err != nil {
+		log.Fatalf("reading %s: %v", flag.Arg(0), err)
+	}
+
+	c, err := cache.NewCache(*cacheDir, cache.Options{FetchFrequency: -1})
+	if err != nil {
+		log.Fatalf("NewCache: %v", err)
+	}
+
+	service, err := gitiles.NewService(*gitilesOptions)
+	if err != nil {
+		log.Fatalf("NewService: %v", err)
+	}
+
+	n, err := fs.Prefetch(c, service, nil, mf, paths)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("fetched %d blobs for %d paths", n, len(paths))
+}
@@ -0,0 +1,117 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// slothfs-query reports which blobs of a workspace match a glob
+// pattern, and how many bytes they take, without downloading them.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/slothfs/cache"
+	"github.com/google/slothfs/config"
+	"github.com/google/slothfs/fs"
+	"github.com/google/slothfs/gitiles"
+	"github.com/google/slothfs/logging"
+	"github.com/google/slothfs/manifest"
+)
+
+func main() {
+	cacheDir := flag.String("cache", filepath.Join(os.Getenv("HOME"), ".cache", "slothfs"),
+		"Set the directory holding the filesystem cache.")
+	manifestFile := flag.String("manifest", "", "Set the manifest describing the workspace. Comma separated manifests are merged.")
+	list := flag.Bool("list", false, "Print each matching blob.")
+	gitilesOptions := gitiles.DefineFlags()
+	logOptions := logging.DefineFlags()
+	if err := config.Parse(); err != nil {
+		log.Fatal(err)
+	}
+
+	logger, err := logging.New(*logOptions)
+	if err != nil {
+		log.Fatal(err)
+	}
+	logging.SetDefault(logger)
+
+	if *manifestFile == "" {
+		log.Fatal("must set -manifest")
+	}
+	if len(flag.Args()) != 1 {
+		log.Fatal("usage: slothfs-query -manifest FILE PATTERN")
+	}
+	pattern := flag.Arg(0)
+
+	var mfs []*manifest.Manifest
+	for _, nm := range strings.Split(*manifestFile, ",") {
+		mf, err := manifest.ParseFile(nm)
+		if err != nil {
+			log.Fatalf("ParseFile(%s): %v", nm, err)
+		}
+		if err := mf.Resolve(manifest.ResolveOptions{
+			Name: nm,
+			Load: manifest.DirLoader(filepath.Dir(nm)),
+		}); err != nil {
+			log.Fatal(err)
+		}
+		mfs = append(mfs, mf)
+	}
+	mf, err := manifest.Merge(mfs...)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	c, err := cache.NewCache(*cacheDir, cache.Options{FetchFrequency: -1})
+	if err != nil {
+		log.Fatalf("NewCache: %v", err)
+	}
+
+	service, err := gitiles.NewService(*gitilesOptions)
+	if err != nil {
+		log.Fatalf("NewService: %v", err)
+	}
+
+	trees, err := fs.FetchTrees(c, service, nil, mf)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	blobs, err := fs.MatchBlobs(trees, pattern)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var total int64
+	unknown := 0
+	for _, b := range blobs {
+		if *list {
+			fmt.Printf("%s %d %s\n", b.ID, b.Size, b.Path)
+		}
+		if b.Size < 0 {
+			unknown++
+			continue
+		}
+		total += b.Size
+	}
+	distinct, distinctSize := fs.DistinctBlobs(blobs)
+	fmt.Printf("%d blobs, %d bytes", len(blobs), total)
+	if unknown > 0 {
+		fmt.Printf(" (%d blobs of unknown size)", unknown)
+	}
+	fmt.Printf("; %d distinct blobs, %d bytes\n", distinct, distinctSize)
+}
@@ -16,7 +16,6 @@ package main
 
 import (
 	"flag"
-	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
@@ -35,6 +34,9 @@ func main() {
 	debug := flag.Bool("debug", false, "Print FUSE debug info")
 	config := flag.String("config", filepath.Join(os.Getenv("HOME"), ".config", "slothfs"),
 		"Set the directory with configuration files.")
+	blobMemoryLimit := flag.Int64("blob_memory_limit", 0, "Keep up to this many bytes of small blobs in memory.")
+	cloneConfig := flag.String("clone_config", "",
+		"Set the JSON file with clone options. Defaults to clone.json in the -config directory.")
 	gitilesOptions := gitiles.DefineFlags()
 	flag.Parse()
 
@@ -48,7 +50,7 @@ func main() {
 
 	mntDir := flag.Arg(0)
 
-	cache, err := cache.NewCache(*cacheDir, cache.Options{})
+	cache, err := cache.NewCache(*cacheDir, cache.Options{BlobMemoryLimit: *blobMemoryLimit})
 	if err != nil {
 		log.Printf("NewCache: %v", err)
 	}
@@ -59,17 +61,16 @@ func main() {
 	}
 
 	opts := fs.MultiManifestFSOptions{}
-	if *config != "" {
-		cloneJS := filepath.Join(*config, "clone.json")
-		configContents, err := ioutil.ReadFile(cloneJS)
-		if err != nil {
-			log.Fatal(err)
-		}
-		opts.RepoCloneOption, opts.FileCloneOption, err = fs.ReadConfig(configContents)
+	if *cloneConfig == "" && *config != "" {
+		*cloneConfig = filepath.Join(*config, "clone.json")
+	}
+	if *cloneConfig != "" {
+		opts.RepoCloneOption, opts.FileCloneOption, err = fs.ReadCloneConfig(*cloneConfig)
 		if err != nil {
 			log.Fatal(err)
 		}
-
+	}
+	if *config != "" {
 		opts.ManifestDir = filepath.Join(*config, "manifests")
 		if err := os.MkdirAll(opts.ManifestDir, 0755); err != nil {
 			log.Fatal(err)
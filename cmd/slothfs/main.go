@@ -0,0 +1,125 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// slothfs runs the slothfs commands as subcommands, like git: "slothfs
+// populate -sync" runs "slothfs-populate -sync". The commands are
+// looked up next to this binary, and then in $PATH.
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+const prefix = "slothfs-"
+
+// aliases maps short subcommand names to command names.
+var aliases = map[string]string{
+	"gc":     "cache-gc",
+	"import": "cache-import",
+	"mount":  "hostfs",
+}
+
+// searchPath returns the directories to look for commands in.
+func searchPath() []string {
+	var dirs []string
+	if exe, err := os.Executable(); err == nil {
+		dirs = append(dirs, filepath.Dir(exe))
+	}
+	return append(dirs, filepath.SplitList(os.Getenv("PATH"))...)
+}
+
+// findCommand returns the path of the binary for the subcommand name.
+func findCommand(name string) (string, error) {
+	if a, ok := aliases[name]; ok {
+		name = a
+	}
+	for _, dir := range searchPath() {
+		p, err := exec.LookPath(filepath.Join(dir, prefix+name))
+		if err == nil {
+			return p, nil
+		}
+	}
+	return "", fmt.Errorf("unknown command %q", name)
+}
+
+// listCommands returns the names of all subcommands that are
+// installed.
+func listCommands() []string {
+	seen := map[string]bool{}
+	for _, dir := range searchPath() {
+		fis, err := ioutil.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, fi := range fis {
+			if strings.HasPrefix(fi.Name(), prefix) && !fi.IsDir() && fi.Mode()&0111 != 0 {
+				seen[strings.TrimPrefix(fi.Name(), prefix)] = true
+			}
+		}
+	}
+	var names []string
+	for nm := range seen {
+		names = append(names, nm)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: slothfs COMMAND [ARGS]\n\ncommands:\n")
+	for _, nm := range listCommands() {
+		fmt.Fprintf(os.Stderr, "  %s\n", nm)
+	}
+	var names []string
+	for a := range aliases {
+		names = append(names, a)
+	}
+	sort.Strings(names)
+	fmt.Fprintf(os.Stderr, "\naliases:\n")
+	for _, a := range names {
+		fmt.Fprintf(os.Stderr, "  %s = %s\n", a, aliases[a])
+	}
+	fmt.Fprintf(os.Stderr, "\nRun \"slothfs COMMAND -help\" for the options of a command.\n")
+}
+
+func main() {
+	log.SetFlags(0)
+	if len(os.Args) < 2 || os.Args[1] == "help" || os.Args[1] == "-help" || os.Args[1] == "--help" {
+		usage()
+		os.Exit(2)
+	}
+
+	path, err := findCommand(os.Args[1])
+	if err != nil {
+		log.Printf("slothfs: %v", err)
+		usage()
+		os.Exit(2)
+	}
+
+	// Replace this process, so the command receives signals
+	// (eg. SIGTERM from a service manager) directly and its exit
+	// status is ours.
+	argv := append([]string{path}, os.Args[2:]...)
+	if err := syscall.Exec(path, argv, os.Environ()); err != nil {
+		log.Fatalf("slothfs: exec %s: %v", path, err)
+	}
+}
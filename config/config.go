@@ -0,0 +1,164 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package config loads default flag values from a configuration file,
+// so settings such as the Gitiles URL or the cache directory need not
+// be repeated on every invocation.
+package config
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// DefaultFile returns the default location of the configuration file.
+func DefaultFile() string {
+	return filepath.Join(os.Getenv("HOME"), ".config", "slothfs", "config.json")
+}
+
+// Config holds flag values. A configuration file is a JSON object
+// whose keys are flag names without the leading dash, eg.
+//
+//	{"cache": "/big/disk/slothfs",
+//	 "log_level": "warning",
+//	 "hosts": {"chromium.googlesource.com": {"gitiles_qps": 10}}}
+//
+// Values may be strings, numbers or booleans, or lists of these for
+// flags that may be repeated. The object under "hosts" holds values
+// that apply when -gitiles_url points to the given host.
+type Config struct {
+	Flags map[string]interface{}
+	Hosts map[string]map[string]interface{}
+}
+
+// Load reads a configuration file. If the file does not exist, the
+// error satisfies os.IsNotExist.
+func Load(file string) (*Config, error) {
+	c, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg Config
+	if err := json.Unmarshal(c, &cfg.Flags); err != nil {
+		return nil, fmt.Errorf("Load(%s): %v", file, err)
+	}
+	if hosts, ok := cfg.Flags["hosts"]; ok {
+		delete(cfg.Flags, "hosts")
+		hostMap, ok := hosts.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("Load(%s): hosts must be an object", file)
+		}
+		cfg.Hosts = map[string]map[string]interface{}{}
+		for h, v := range hostMap {
+			flags, ok := v.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("Load(%s): hosts.%s must be an object", file, h)
+			}
+			cfg.Hosts[h] = flags
+		}
+	}
+	return &cfg, nil
+}
+
+// Apply sets the flags in fs that were not given on the command line
+// to the values in c. Values for flags that fs does not define are
+// ignored, as one file serves all commands. Host specific values take
+// precedence over the others; for a list, the host's list replaces
+// the general one.
+func (c *Config) Apply(fs *flag.FlagSet) error {
+	given := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { given[f.Name] = true })
+
+	values := map[string]interface{}{}
+	for name, v := range c.Flags {
+		values[name] = v
+	}
+	if f := fs.Lookup("gitiles_url"); f != nil {
+		addr := f.Value.String()
+		if v, ok := c.Flags["gitiles_url"]; ok && !given["gitiles_url"] {
+			if s, err := flagValue(v); err == nil {
+				addr = s
+			}
+		}
+		if u, err := url.Parse(addr); err == nil {
+			for name, v := range c.Hosts[u.Host] {
+				values[name] = v
+			}
+		}
+	}
+	return setFlags(fs, given, values)
+}
+
+// setFlags sets the flags in values, skipping those in given.
+func setFlags(fs *flag.FlagSet, given map[string]bool, values map[string]interface{}) error {
+	for name, v := range values {
+		if given[name] || fs.Lookup(name) == nil {
+			continue
+		}
+		list, ok := v.([]interface{})
+		if !ok {
+			list = []interface{}{v}
+		}
+		for _, elt := range list {
+			s, err := flagValue(elt)
+			if err == nil {
+				err = fs.Set(name, s)
+			}
+			if err != nil {
+				return fmt.Errorf("config: flag %s: %v", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// flagValue formats a JSON scalar as a flag value.
+func flagValue(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	}
+	return "", fmt.Errorf("unsupported value %v", v)
+}
+
+// Parse parses the command line like flag.Parse, and then sets the
+// flags that were not given to their values in the configuration
+// file. It defines a -config_file flag to select the file.
+func Parse() error {
+	file := flag.String("config_file", DefaultFile(), "Read default flag values from this JSON file. Flags on the command line take precedence.")
+	flag.Parse()
+	if *file == "" {
+		return nil
+	}
+
+	c, err := Load(*file)
+	if os.IsNotExist(err) && *file == DefaultFile() {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return c.Apply(flag.CommandLine)
+}
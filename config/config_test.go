@@ -0,0 +1,126 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type listFlag []string
+
+func (l *listFlag) String() string { return strings.Join(*l, ",") }
+
+func (l *listFlag) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+func TestApply(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "config.json")
+	if err := ioutil.WriteFile(file, []byte(`{
+  "cache": "/big/cache",
+  "gitiles_qps": 4,
+  "debug": true,
+  "header": ["A: 1", "B: 2"],
+  "gitiles_header": ["X-Token: global", "X-Trace: 1"],
+  "unknown": "ignored",
+  "hosts": {
+    "example.com": {"gitiles_qps": 10, "gitiles_header": ["X-Token: host"]},
+    "other.com": {"cache": "/other"}
+  }
+}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c, err := Load(file)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	cache := fs.String("cache", "", "")
+	debug := fs.Bool("debug", false, "")
+	qps := fs.Float64("gitiles_qps", 1, "")
+	url := fs.String("gitiles_url", "", "")
+	var headers, gitilesHeaders listFlag
+	fs.Var(&headers, "header", "")
+	fs.Var(&gitilesHeaders, "gitiles_header", "")
+	if err := fs.Parse([]string{"-cache", "/cmdline", "-gitiles_url", "https://example.com/"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Apply(fs); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	if *cache != "/cmdline" {
+		t.Errorf("got cache %q, want the command line value", *cache)
+	}
+	if !*debug {
+		t.Errorf("debug not set")
+	}
+	if *qps != 10 {
+		t.Errorf("got gitiles_qps %v, want the host value 10", *qps)
+	}
+	if *url != "https://example.com/" {
+		t.Errorf("got gitiles_url %q", *url)
+	}
+	if len(headers) != 2 || headers[0] != "A: 1" || headers[1] != "B: 2" {
+		t.Errorf("got headers %q", headers)
+	}
+	if len(gitilesHeaders) != 1 || gitilesHeaders[0] != "X-Token: host" {
+		t.Errorf("got gitiles_header %q, want only the host value", gitilesHeaders)
+	}
+}
+
+func TestApplyHostFromConfig(t *testing.T) {
+	c := &Config{
+		Flags: map[string]interface{}{"gitiles_url": "https://example.com/", "gitiles_qps": 4.0},
+		Hosts: map[string]map[string]interface{}{"example.com": {"gitiles_qps": 10.0}},
+	}
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	qps := fs.Float64("gitiles_qps", 1, "")
+	fs.String("gitiles_url", "", "")
+	if err := c.Apply(fs); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	if *qps != 10 {
+		t.Errorf("got gitiles_qps %v, want the host value 10", *qps)
+	}
+}
+
+func TestApplyBadValue(t *testing.T) {
+	c := &Config{Flags: map[string]interface{}{"j": "many"}}
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.Int("j", 0, "")
+	if err := c.Apply(fs); err == nil {
+		t.Errorf("Apply succeeded for invalid integer")
+	}
+}
+
+func TestLoadMissing(t *testing.T) {
+	if _, err := Load("/nonexistent/config.json"); !os.IsNotExist(err) {
+		t.Errorf("got %v, want a not-exist error", err)
+	}
+}
@@ -17,7 +17,9 @@ package fs
 import (
 	"regexp"
 
+	"github.com/google/slothfs/logging"
 	"github.com/google/slothfs/manifest"
+	"github.com/google/slothfs/policy"
 )
 
 // CloneOption configures for which files we should trigger a git clone.
@@ -39,10 +41,37 @@ type GitilesOptions struct {
 	// If set, clone the repo on reads from here.
 	CloneURL string
 
+	// If positive, make a shallow clone with this depth.
+	CloneDepth int
+
 	// List of filename options. We use the first matching option
 	CloneOption []CloneOption
+
+	// If set, decides which files trigger a clone, ahead of
+	// CloneOption. It can be replaced at runtime through
+	// .slothfs/config/clone_policy.
+	Policy *policy.Policy
+
+	// MetaDir is the name of the directory holding metadata such
+	// as tree.json. If empty, DefaultMetaDir is used.
+	MetaDir string
+
+	// If set, record every open and read in access.log in the
+	// metadata directory.
+	TraceAccess bool
+
+	// If set, mount submodules that are hosted on the same Gitiles
+	// server. Otherwise, submodules are empty directories.
+	Submodules bool
+
+	// Logger receives log messages. If nil, logging.Default() is
+	// used.
+	Logger *logging.Logger
 }
 
+// DefaultMetaDir is the default name of the metadata directory.
+const DefaultMetaDir = ".slothfs"
+
 // ManifestOptions holds options for a Manifest file system.
 type ManifestOptions struct {
 	Manifest *manifest.Manifest
@@ -0,0 +1,120 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fs
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/google/slothfs/cache"
+	"github.com/google/slothfs/gitiles"
+	"github.com/google/slothfs/manifest"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+)
+
+// fetchTreesParallelism bounds the number of concurrent tree
+// fetches in FetchTrees.
+const fetchTreesParallelism = 8
+
+// getTree returns the recursive tree for a project revision, using
+// the cache if possible. Trees for commit SHA1s are added to the
+// cache.
+func getTree(c *cache.Cache, service *gitiles.Service, name, rev string) (*gitiles.Tree, error) {
+	id := plumbing.NewHash(rev)
+	pinned := id.String() == rev
+	if pinned {
+		if tree, err := c.Tree.Get(&id); err == nil {
+			return tree, nil
+		}
+	}
+
+	tree, err := service.NewRepoService(name).GetTree(rev, "/", true)
+	if err != nil {
+		return nil, err
+	}
+	if pinned {
+		if err := c.Tree.Add(&id, tree); err != nil {
+			c.Logger().Warningf("TreeCache.Add(%s): %v", rev, err)
+		}
+	}
+	return tree, nil
+}
+
+// projectService returns the service for the remote of p, or def if
+// remotes has none.
+func projectService(mf *manifest.Manifest, p *manifest.Project, def *gitiles.Service, remotes map[string]*gitiles.Service) *gitiles.Service {
+	remote := p.Remote
+	if remote == "" {
+		remote = mf.Default.Remote
+	}
+	if s, ok := remotes[remote]; ok {
+		return s
+	}
+	return def
+}
+
+// FetchTrees returns the trees of all projects in the manifest, keyed
+// by project path. Projects are fetched from the service for their
+// remote in remotes, or from service. It does not need a mounted file
+// system. All projects whose tree could not be fetched are reported
+// in a single error.
+func FetchTrees(c *cache.Cache, service *gitiles.Service, remotes map[string]*gitiles.Service, mf *manifest.Manifest) (map[string]*gitiles.Tree, error) {
+	var mu sync.Mutex
+	trees := map[string]*gitiles.Tree{}
+	var msgs []string
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, fetchTreesParallelism)
+	for i := range mf.Project {
+		p := &mf.Project[i]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			rev := mf.ProjectRevision(p)
+			tree, err := getTree(c, projectService(mf, p, service, remotes), p.Name, rev)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				msgs = append(msgs, fmt.Sprintf("project %s: GetTree(%s): %v", p.Name, rev, err))
+				return
+			}
+			trees[p.GetPath()] = tree
+		}()
+	}
+	wg.Wait()
+
+	if len(msgs) > 0 {
+		sort.Strings(msgs)
+		return nil, fmt.Errorf("%d trees could not be fetched:\n%s", len(msgs), strings.Join(msgs, "\n"))
+	}
+	return trees, nil
+}
+
+// CheckManifest verifies that a manifest could be mounted: all trees
+// can be fetched, and all copyfile and linkfile sources exist. It does
+// not need FUSE. The services are selected as in FetchTrees.
+func CheckManifest(c *cache.Cache, service *gitiles.Service, remotes map[string]*gitiles.Service, mf *manifest.Manifest) error {
+	trees, err := FetchTrees(c, service, remotes, mf)
+	if err != nil {
+		return err
+	}
+	return checkCopyfiles(mf, trees)
+}
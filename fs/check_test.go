@@ -0,0 +1,96 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fs
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/google/slothfs/gitiles"
+	"github.com/google/slothfs/manifest"
+)
+
+func TestFetchTreesRemotes(t *testing.T) {
+	treeHandler := func(treeID string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`)]}'
+{"id": "` + treeID + `", "entries": []}`))
+		}
+	}
+	c, service1, cleanup := newTestService(t, treeHandler("1111111111111111111111111111111111111111"))
+	defer cleanup()
+	_, service2, cleanup2 := newTestService(t, treeHandler("2222222222222222222222222222222222222222"))
+	defer cleanup2()
+
+	mf, err := manifest.Parse([]byte(`<manifest>
+ <remote name="aosp" fetch=".."/>
+ <remote name="partner" fetch=".."/>
+ <default revision="master" remote="aosp"/>
+ <project path="build" name="platform/build"/>
+ <project path="vendor" name="vendor/blob" remote="partner"/>
+</manifest>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	trees, err := FetchTrees(c, service1, map[string]*gitiles.Service{"partner": service2}, mf)
+	if err != nil {
+		t.Fatalf("FetchTrees: %v", err)
+	}
+	if got := trees["build"].ID; got != "1111111111111111111111111111111111111111" {
+		t.Errorf("build: got tree %s from the wrong server", got)
+	}
+	if got := trees["vendor"].ID; got != "2222222222222222222222222222222222222222" {
+		t.Errorf("vendor: got tree %s from the wrong server", got)
+	}
+}
+
+func TestCheckManifest(t *testing.T) {
+	const rev = "ce34badf691d36e8048b63f89d1a86ee5fa4325c"
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/platform/build/+/"+rev+"/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`)]}'
+{"id": "58d9fdae2c26d82e04f3fcafc4358b99109f0e70",
+ "entries": [{"mode": 33188, "type": "blob", "id": "787d767f94fd634ed29cd69ec9f93bab2b25f5d4", "name": "core/root.mk", "size": 3}]}`))
+	}
+	c, service, cleanup := newTestService(t, handler)
+	defer cleanup()
+
+	mf, err := manifest.Parse([]byte(`<manifest>
+ <project path="build" name="platform/build" revision="` + rev + `">
+  <copyfile src="core/root.mk" dest="Makefile"/>
+ </project>
+</manifest>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckManifest(c, service, nil, mf); err != nil {
+		t.Errorf("CheckManifest: %v", err)
+	}
+
+	mf.Project[0].Copyfile[0].Src = "core/roto.mk"
+	if err := CheckManifest(c, service, nil, mf); err == nil || !strings.Contains(err.Error(), "core/roto.mk") {
+		t.Errorf("CheckManifest with missing copyfile source: got %v", err)
+	}
+
+	mf.Project = append(mf.Project, manifest.Project{Name: "platform/missing", Revision: "0000000000000000000000000000000000000001"})
+	if err := CheckManifest(c, service, nil, mf); err == nil || !strings.Contains(err.Error(), "project platform/missing") {
+		t.Errorf("CheckManifest with missing project: got %v", err)
+	}
+}
@@ -0,0 +1,48 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fs
+
+import (
+	"strconv"
+
+	"github.com/google/slothfs/logging"
+	"github.com/google/slothfs/manifest"
+)
+
+// cloneDepth returns the clone depth configured for the project, or
+// 0 for a full clone. A malformed clone-depth also yields a full
+// clone, with a warning.
+func cloneDepth(p *manifest.Project) int {
+	if p.CloneDepth == "" {
+		return 0
+	}
+	depth, err := strconv.Atoi(p.CloneDepth)
+	if err != nil || depth < 0 {
+		logging.Default().Sub("fs").Warningf("project %s: ignoring malformed clone-depth %q, doing a full clone", p.Name, p.CloneDepth)
+		return 0
+	}
+	return depth
+}
+
+// ProjectOptions returns the options for mounting the project p of
+// o.Manifest: the file clone options, and the clone depth from the
+// clone-depth attribute. The clone URL depends on the remote, and is
+// left to the caller.
+func (o *ManifestOptions) ProjectOptions(p *manifest.Project) GitilesOptions {
+	return GitilesOptions{
+		CloneOption: o.FileCloneOption,
+		CloneDepth:  cloneDepth(p),
+	}
+}
@@ -0,0 +1,53 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fs
+
+import (
+	"testing"
+
+	"github.com/google/slothfs/manifest"
+)
+
+func TestCloneDepth(t *testing.T) {
+	for in, want := range map[string]int{
+		"":    0,
+		"1":   1,
+		"50":  50,
+		"-1":  0,
+		"abc": 0,
+	} {
+		p := &manifest.Project{Name: "p", CloneDepth: in}
+		if got := cloneDepth(p); got != want {
+			t.Errorf("cloneDepth(%q): got %d, want %d", in, got, want)
+		}
+	}
+}
+
+func TestProjectOptions(t *testing.T) {
+	mf, err := manifest.Parse([]byte(`<manifest>
+ <project path="build" name="platform/build" clone-depth="1"/>
+ <project path="art" name="platform/art" clone-depth="deep"/>
+</manifest>`))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	opts := ManifestOptions{Manifest: mf}
+	if got := opts.ProjectOptions(&mf.Project[0]).CloneDepth; got != 1 {
+		t.Errorf("build: got depth %d, want 1", got)
+	}
+	if got := opts.ProjectOptions(&mf.Project[1]).CloneDepth; got != 0 {
+		t.Errorf("art: got depth %d for a malformed clone-depth, want 0", got)
+	}
+}
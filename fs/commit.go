@@ -0,0 +1,142 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/google/slothfs/gitiles"
+	"github.com/hanwen/go-fuse/fs"
+	"github.com/hanwen/go-fuse/fuse"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+)
+
+// commitNode serves the commit a repository is pinned to as JSON.
+// The commit is fetched on the first open, and kept afterwards.
+type commitNode struct {
+	fs.Inode
+
+	root *gitilesRoot
+
+	mu   sync.Mutex
+	data []byte
+}
+
+// load returns the JSON for the commit, fetching it if needed.
+func (n *commitNode) load(ctx context.Context) ([]byte, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.data != nil {
+		return n.data, nil
+	}
+
+	c, err := n.root.commit(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// The diff is not useful for identifying the revision.
+	c.TreeDiff = nil
+	data, err := json.MarshalIndent(c, "", " ")
+	if err != nil {
+		return nil, err
+	}
+	n.data = data
+	return data, nil
+}
+
+var _ = (fs.NodeGetattrer)((*commitNode)(nil))
+
+func (n *commitNode) Getattr(ctx context.Context, file fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	out.Mode = fuse.S_IFREG | 0444
+	if h, ok := file.(*snapshotHandle); ok {
+		out.Size = uint64(len(h.data))
+	}
+	t := time.Unix(1, 0)
+	out.SetTimes(nil, &t, nil)
+	return 0
+}
+
+var _ = (fs.NodeOpener)((*commitNode)(nil))
+
+func (n *commitNode) Open(ctx context.Context, flags uint32) (fs.FileHandle, uint32, syscall.Errno) {
+	if flags&syscall.O_ACCMODE != syscall.O_RDONLY {
+		return nil, 0, syscall.EPERM
+	}
+	data, err := n.load(ctx)
+	if ctx.Err() != nil {
+		return nil, 0, syscall.EINTR
+	}
+	if err != nil {
+		n.root.logger.Errorf("%s: commit %s: %v", n.root.name, n.root.opts.Revision, err)
+		return nil, 0, syscall.EIO
+	}
+	return &snapshotHandle{data}, fuse.FOPEN_DIRECT_IO, 0
+}
+
+var _ = (fs.NodeReader)((*commitNode)(nil))
+
+func (n *commitNode) Read(ctx context.Context, file fs.FileHandle, dest []byte, off int64) (fuse.ReadResult, syscall.Errno) {
+	h, ok := file.(*snapshotHandle)
+	if !ok {
+		return nil, syscall.EBADF
+	}
+	return h.read(dest, off)
+}
+
+// commit returns the commit for the revision of the root, from Gitiles
+// or for a local repository, from git.
+func (r *gitilesRoot) commit(ctx context.Context) (*gitiles.Commit, error) {
+	if r.service != nil {
+		return r.service.GetCommitContext(ctx, r.opts.Revision)
+	}
+
+	repo := r.lazyRepo.Repository()
+	if repo == nil {
+		return nil, fmt.Errorf("no repository")
+	}
+	c, err := repo.CommitObject(plumbing.NewHash(r.opts.Revision))
+	if err != nil {
+		return nil, err
+	}
+	return gitilesCommit(c), nil
+}
+
+// gitilesCommit converts a git commit to the Gitiles representation.
+func gitilesCommit(c *object.Commit) *gitiles.Commit {
+	person := func(s object.Signature) gitiles.Person {
+		return gitiles.Person{
+			Name:  s.Name,
+			Email: s.Email,
+			Time:  s.When.Format(gitiles.CommitTimeFormat),
+		}
+	}
+	result := &gitiles.Commit{
+		Commit:    c.Hash.String(),
+		Tree:      c.TreeHash.String(),
+		Author:    person(c.Author),
+		Committer: person(c.Committer),
+		Message:   c.Message,
+	}
+	for _, p := range c.ParentHashes {
+		result.Parents = append(result.Parents, p.String())
+	}
+	return result
+}
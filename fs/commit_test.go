@@ -0,0 +1,84 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fs
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/slothfs/gitiles"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+)
+
+func TestCommitNode(t *testing.T) {
+	const rev = "ce34badf691d36e8048b63f89d1a86ee5fa4325c"
+	requests := 0
+	c, service, cleanup := newTestService(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/platform/build/+/"+rev {
+			http.NotFound(w, r)
+			return
+		}
+		requests++
+		w.Write([]byte(`)]}'
+{"commit": "` + rev + `", "parents": ["58d9fdae2c26d82e04f3fcafc4358b99109f0e70"],
+ "author": {"name": "A U Thor", "email": "author@example.com", "time": "Tue Oct 11 10:00:00 2016 +0200"},
+ "message": "Fix the build\n",
+ "tree_diff": [{"type": "modify", "new_path": "core/main.mk"}]}`))
+	})
+	defer cleanup()
+
+	root := NewGitilesRoot(c, &gitiles.Tree{}, service.NewRepoService("platform/build"), GitilesRevisionOptions{Revision: rev})
+	n := &commitNode{root: root}
+	for i := 0; i < 2; i++ {
+		data, err := n.load(context.Background())
+		if err != nil {
+			t.Fatalf("load: %v", err)
+		}
+		var got gitiles.Commit
+		if err := json.Unmarshal(data, &got); err != nil {
+			t.Fatalf("Unmarshal: %v", err)
+		}
+		if got.Commit != rev || got.Author.Email != "author@example.com" || len(got.Parents) != 1 || got.TreeDiff != nil {
+			t.Errorf("got %+v", got)
+		}
+	}
+	if requests != 1 {
+		t.Errorf("got %d requests, want 1", requests)
+	}
+}
+
+func TestGitilesCommit(t *testing.T) {
+	when := time.Date(2016, 10, 11, 10, 0, 0, 0, time.FixedZone("", 2*3600))
+	got := gitilesCommit(&object.Commit{
+		Hash:         plumbing.NewHash("ce34badf691d36e8048b63f89d1a86ee5fa4325c"),
+		TreeHash:     plumbing.NewHash("58d9fdae2c26d82e04f3fcafc4358b99109f0e70"),
+		ParentHashes: []plumbing.Hash{plumbing.NewHash("787d767f94fd634ed29cd69ec9f93bab2b25f5d4")},
+		Author:       object.Signature{Name: "A U Thor", Email: "author@example.com", When: when},
+		Message:      "msg\n",
+	})
+	if got.Author.Time != "Tue Oct 11 10:00:00 2016 +0200" {
+		t.Errorf("got author time %q", got.Author.Time)
+	}
+	if parsed, err := got.Author.GetTime(); err != nil || !parsed.Equal(when) {
+		t.Errorf("GetTime: got %v, %v, want %v", parsed, err, when)
+	}
+	if len(got.Parents) != 1 || got.Parents[0] != "787d767f94fd634ed29cd69ec9f93bab2b25f5d4" || got.Tree != "58d9fdae2c26d82e04f3fcafc4358b99109f0e70" {
+		t.Errorf("got %+v", got)
+	}
+}
@@ -17,6 +17,7 @@ package fs
 import (
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"regexp"
 )
 
@@ -33,26 +34,40 @@ func ReadConfig(contents []byte) (repo []CloneOption, file []CloneOption, err er
 		return nil, nil, err
 	}
 
-	for _, e := range cfg {
+	for i, e := range cfg {
 		if e.File != "" {
 			re, err := regexp.Compile(e.File)
 			if err != nil {
-				return nil, nil, err
+				return nil, nil, fmt.Errorf("entry %d: %v", i, err)
 			}
 
 			file = append(file, CloneOption{re, e.Clone})
 		} else if e.Repo != "" {
 			re, err := regexp.Compile(e.Repo)
 			if err != nil {
-				return nil, nil, err
+				return nil, nil, fmt.Errorf("entry %d: %v", i, err)
 			}
 
 			repo = append(repo, CloneOption{re, e.Clone})
 
 		} else {
-			return nil, nil, fmt.Errorf("must set either File or Repo")
+			return nil, nil, fmt.Errorf("entry %d: must set either File or Repo", i)
 		}
 	}
 
 	return repo, file, nil
 }
+
+// ReadCloneConfig reads clone options from a JSON file, see
+// ReadConfig for the format.
+func ReadCloneConfig(path string) (repo []CloneOption, file []CloneOption, err error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	repo, file, err = ReadConfig(contents)
+	if err != nil {
+		return nil, nil, fmt.Errorf("ReadCloneConfig(%s): %v", path, err)
+	}
+	return repo, file, nil
+}
@@ -14,7 +14,13 @@
 
 package fs
 
-import "testing"
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
 
 func TestReadConfig(t *testing.T) {
 	in := `[{ "File": ".*\\.mk$", "Clone": false},
@@ -24,3 +30,36 @@ func TestReadConfig(t *testing.T) {
 		t.Fatalf("ReadConfig: %v", err)
 	}
 }
+
+func TestReadCloneConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "clone.json")
+	in := `[{ "File": ".*\\.mk$", "Clone": false},
+ { "Repo": "darwin", "Clone": true}]`
+	if err := ioutil.WriteFile(path, []byte(in), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	repo, file, err := ReadCloneConfig(path)
+	if err != nil {
+		t.Fatalf("ReadCloneConfig: %v", err)
+	}
+	if len(repo) != 1 || !repo[0].RE.MatchString("prebuilts/darwin") || !repo[0].Clone {
+		t.Errorf("got repo options %v", repo)
+	}
+	if len(file) != 1 || !file[0].RE.MatchString("Android.mk") || file[0].Clone {
+		t.Errorf("got file options %v", file)
+	}
+
+	if err := ioutil.WriteFile(path, []byte(`[{"File": "("}]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := ReadCloneConfig(path); err == nil || !strings.Contains(err.Error(), path) {
+		t.Errorf("got error %v, want error mentioning %s", err, path)
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fs
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/google/slothfs/gitiles"
+	"github.com/google/slothfs/manifest"
+)
+
+// treeIndex holds the files and directories of a tree.
+type treeIndex struct {
+	files map[string]bool
+	dirs  map[string]bool
+}
+
+func newTreeIndex(tree *gitiles.Tree) *treeIndex {
+	idx := &treeIndex{
+		files: map[string]bool{},
+		dirs:  map[string]bool{"": true},
+	}
+	for _, e := range tree.Entries {
+		idx.files[e.Name] = true
+		for d := path.Dir(e.Name); d != "."; d = path.Dir(d) {
+			idx.dirs[d] = true
+		}
+	}
+	return idx
+}
+
+// nearestDir returns the deepest existing directory that contains p.
+func (idx *treeIndex) nearestDir(p string) string {
+	for d := path.Dir(p); d != "."; d = path.Dir(d) {
+		if idx.dirs[d] {
+			return d
+		}
+	}
+	return ""
+}
+
+// checkCopyfiles verifies that the sources of all copyfile and
+// linkfile entries exist in the trees, which are keyed by project
+// path. All missing sources are reported in a single error; each
+// names the nearest existing directory, to help spot typos.
+func checkCopyfiles(mf *manifest.Manifest, trees map[string]*gitiles.Tree) error {
+	var msgs []string
+	for _, p := range mf.Project {
+		if len(p.Copyfile) == 0 && len(p.Linkfile) == 0 {
+			continue
+		}
+		tree := trees[p.GetPath()]
+		if tree == nil {
+			msgs = append(msgs, fmt.Sprintf("project %s: tree not found", p.GetPath()))
+			continue
+		}
+
+		idx := newTreeIndex(tree)
+		check := func(kind, src string, dirOK bool) {
+			if idx.files[src] || (dirOK && idx.dirs[src]) {
+				return
+			}
+			msgs = append(msgs, fmt.Sprintf("project %s: %s source %q does not exist (nearest existing directory: %q)",
+				p.GetPath(), kind, src, idx.nearestDir(src)))
+		}
+		for _, c := range p.Copyfile {
+			check("copyfile", c.Src, false)
+		}
+		for _, l := range p.Linkfile {
+			check("linkfile", l.Src, true)
+		}
+	}
+
+	if len(msgs) > 0 {
+		return fmt.Errorf("%d copyfile/linkfile errors:\n%s", len(msgs), strings.Join(msgs, "\n"))
+	}
+	return nil
+}
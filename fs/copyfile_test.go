@@ -0,0 +1,71 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fs
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/slothfs/gitiles"
+	"github.com/google/slothfs/manifest"
+)
+
+func TestCheckCopyfiles(t *testing.T) {
+	mf := &manifest.Manifest{
+		Project: []manifest.Project{
+			{
+				Name: "build",
+				Copyfile: []manifest.Copyfile{
+					{Src: "core/root.mk", Dest: "Makefile"},
+					{Src: "core/roto.mk", Dest: "Makefile2"},
+				},
+				Linkfile: []manifest.Linkfile{
+					{Src: "core", Dest: "core"},
+					{Src: "tools/misc/x", Dest: "x"},
+				},
+			},
+			{Name: "art"},
+		},
+	}
+	trees := map[string]*gitiles.Tree{
+		"build": {
+			Entries: []gitiles.TreeEntry{
+				{Name: "core/root.mk"},
+				{Name: "tools/README"},
+			},
+		},
+	}
+
+	err := checkCopyfiles(mf, trees)
+	if err == nil {
+		t.Fatal("checkCopyfiles succeeded")
+	}
+	msg := err.Error()
+	for _, want := range []string{
+		"2 copyfile/linkfile errors",
+		`copyfile source "core/roto.mk" does not exist (nearest existing directory: "core")`,
+		`linkfile source "tools/misc/x" does not exist (nearest existing directory: "tools")`,
+	} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("got %q, want it to contain %q", msg, want)
+		}
+	}
+
+	mf.Project[0].Copyfile = mf.Project[0].Copyfile[:1]
+	mf.Project[0].Linkfile = mf.Project[0].Linkfile[:1]
+	if err := checkCopyfiles(mf, trees); err != nil {
+		t.Errorf("checkCopyfiles: %v", err)
+	}
+}
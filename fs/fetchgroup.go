@@ -0,0 +1,115 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fs
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"github.com/google/slothfs/cache"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+)
+
+// fetchKey identifies a blob in a cache.
+type fetchKey struct {
+	cas *cache.CAS
+	id  plumbing.Hash
+}
+
+// fetchCall is a fetch in progress.
+type fetchCall struct {
+	done chan struct{}
+	err  error
+
+	// waiters is the number of callers waiting for the result.
+	// When it drops to zero, the fetch is canceled.
+	waiters int
+	cancel  context.CancelFunc
+}
+
+// fetchGroup coalesces concurrent fetches of the same blob, eg. when
+// a parallel build opens a cold file from many processes at once, so
+// only one request goes out and the other callers wait for it.
+type fetchGroup struct {
+	mu    sync.Mutex
+	calls map[fetchKey]*fetchCall
+
+	// joined, if set, receives a value whenever a caller joins a
+	// fetch in progress. It is for tests.
+	joined chan struct{}
+}
+
+// blobFetches is shared by all roots, as nodes for the same blob may
+// belong to different roots.
+var blobFetches = &fetchGroup{calls: map[fetchKey]*fetchCall{}}
+
+// do runs fetch for key, unless a fetch for key is in progress, in
+// which case it waits for that one. If that fetch fails, do runs
+// fetch itself, as the failure may be specific to the root that
+// started it, eg. a missing path in its tree. If ctx is done first,
+// do returns ctx.Err(); the fetch goes on as long as other callers
+// wait for it.
+func (g *fetchGroup) do(ctx context.Context, key fetchKey, fetch func(ctx context.Context) error) error {
+	g.mu.Lock()
+	c, ok := g.calls[key]
+	if ok {
+		atomic.AddInt64(&opStats.CoalescedFetches, 1)
+	} else {
+		fetchCtx, cancel := context.WithCancel(context.Background())
+		c = &fetchCall{done: make(chan struct{}), cancel: cancel}
+		g.calls[key] = c
+		go func() {
+			c.err = fetch(fetchCtx)
+			g.mu.Lock()
+			g.forget(key, c)
+			g.mu.Unlock()
+			cancel()
+			close(c.done)
+		}()
+	}
+	c.waiters++
+	g.mu.Unlock()
+	if ok && g.joined != nil {
+		g.joined <- struct{}{}
+	}
+
+	select {
+	case <-c.done:
+		if c.err != nil && ok {
+			return fetch(ctx)
+		}
+		return c.err
+	case <-ctx.Done():
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		c.waiters--
+		if c.waiters == 0 {
+			// Nobody needs the result anymore. Later
+			// callers must start a new fetch.
+			c.cancel()
+			g.forget(key, c)
+		}
+		return ctx.Err()
+	}
+}
+
+// forget removes c, unless it was already replaced. It must be called
+// with g.mu held.
+func (g *fetchGroup) forget(key fetchKey, c *fetchCall) {
+	if g.calls[key] == c {
+		delete(g.calls, key)
+	}
+}
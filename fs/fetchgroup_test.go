@@ -0,0 +1,150 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fs
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+)
+
+func TestFetchGroupCoalesces(t *testing.T) {
+	const n = 50
+	g := &fetchGroup{
+		calls:  map[fetchKey]*fetchCall{},
+		joined: make(chan struct{}, n),
+	}
+	key := fetchKey{id: plumbing.NewHash("ce34badf691d36e8048b63f89d1a86ee5fa4325c")}
+
+	var fetches int32
+	started := make(chan struct{})
+	release := make(chan struct{})
+	fetch := func(ctx context.Context) error {
+		atomic.AddInt32(&fetches, 1)
+		close(started)
+		<-release
+		return nil
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	call := func() {
+		defer wg.Done()
+		errs <- g.do(context.Background(), key, fetch)
+	}
+	wg.Add(1)
+	go call()
+	<-started
+	for i := 1; i < n; i++ {
+		wg.Add(1)
+		go call()
+	}
+
+	// Wait until all other callers joined the fetch.
+	for i := 1; i < n; i++ {
+		<-g.joined
+	}
+	close(release)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("do: %v", err)
+		}
+	}
+	if fetches != 1 {
+		t.Errorf("got %d fetches, want 1", fetches)
+	}
+}
+
+func TestFetchGroupCancel(t *testing.T) {
+	g := &fetchGroup{calls: map[fetchKey]*fetchCall{}}
+	key := fetchKey{id: plumbing.NewHash("ce34badf691d36e8048b63f89d1a86ee5fa4325c")}
+
+	started := make(chan struct{})
+	canceled := make(chan struct{})
+	fetch := func(ctx context.Context) error {
+		close(started)
+		<-ctx.Done()
+		close(canceled)
+		return ctx.Err()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-started
+		cancel()
+	}()
+	if err := g.do(ctx, key, fetch); err != context.Canceled {
+		t.Errorf("do: got %v, want Canceled", err)
+	}
+
+	// The last waiter leaving cancels the fetch.
+	<-canceled
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if len(g.calls) != 0 {
+		t.Errorf("canceled fetch still registered")
+	}
+}
+
+func TestFetchGroupRetriesOnError(t *testing.T) {
+	g := &fetchGroup{
+		calls:  map[fetchKey]*fetchCall{},
+		joined: make(chan struct{}, 1),
+	}
+	key := fetchKey{id: plumbing.NewHash("ce34badf691d36e8048b63f89d1a86ee5fa4325c")}
+
+	// The first root cannot fetch the blob; the second one can.
+	started := make(chan struct{})
+	release := make(chan struct{})
+	failing := func(ctx context.Context) error {
+		close(started)
+		<-release
+		return errors.New("not in this tree")
+	}
+	var fetched int32
+	working := func(ctx context.Context) error {
+		atomic.AddInt32(&fetched, 1)
+		return nil
+	}
+
+	errA := make(chan error, 1)
+	go func() {
+		errA <- g.do(context.Background(), key, failing)
+	}()
+	<-started
+	errB := make(chan error, 1)
+	go func() {
+		errB <- g.do(context.Background(), key, working)
+	}()
+	<-g.joined
+	close(release)
+
+	if err := <-errA; err == nil {
+		t.Errorf("do(failing) succeeded")
+	}
+	if err := <-errB; err != nil {
+		t.Errorf("do(working): %v", err)
+	}
+	if fetched != 1 {
+		t.Errorf("got %d retries, want 1", fetched)
+	}
+}
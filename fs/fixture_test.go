@@ -17,8 +17,11 @@ package fs
 import (
 	"fmt"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"testing"
 	"time"
 
 	"github.com/google/slothfs/cache"
@@ -70,6 +73,33 @@ func newTestFixture() (*testFixture, error) {
 	return fixture, nil
 }
 
+// newTestService returns a cache in a temporary directory and a
+// service for a Gitiles test server running handler. The returned
+// function stops the server and removes the cache.
+func newTestService(t *testing.T, handler http.HandlerFunc) (*cache.Cache, *gitiles.Service, func()) {
+	ts := httptest.NewServer(handler)
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		ts.Close()
+		t.Fatal(err)
+	}
+	cleanup := func() {
+		ts.Close()
+		os.RemoveAll(dir)
+	}
+	c, err := cache.NewCache(dir, cache.Options{FetchFrequency: -1})
+	if err != nil {
+		cleanup()
+		t.Fatalf("NewCache: %v", err)
+	}
+	service, err := gitiles.NewService(gitiles.Options{Address: ts.URL})
+	if err != nil {
+		cleanup()
+		t.Fatalf("NewService: %v", err)
+	}
+	return c, service, cleanup
+}
+
 func (f *testFixture) mount(root fs.InodeEmbedder) error {
 	f.mntDir = filepath.Join(f.dir, "mnt")
 	if err := os.Mkdir(f.mntDir, 0755); err != nil {
@@ -18,8 +18,9 @@ import (
 	"context"
 	"encoding/hex"
 	"fmt"
-	"log"
+	"sync"
 	"syscall"
+	"time"
 
 	"gopkg.in/src-d/go-git.v4/plumbing"
 
@@ -32,9 +33,46 @@ import (
 type gitilesConfigFSRoot struct {
 	fs.Inode
 
-	cache   *cache.Cache
-	service *gitiles.RepoService
-	options GitilesOptions
+	cache     *cache.Cache
+	nodeCache *nodeCache
+	service   *gitiles.RepoService
+	options   GitilesOptions
+
+	// missing records when fetching a tree failed, so builds
+	// probing the same bad name do not hit the server each time.
+	missingMu sync.Mutex
+	missing   map[plumbing.Hash]time.Time
+}
+
+// missingTreeTimeout is how long a failed tree fetch is remembered.
+// It is short, as the failure may be transient.
+const missingTreeTimeout = time.Minute
+
+// isMissing returns whether fetching the tree id failed recently.
+func (r *gitilesConfigFSRoot) isMissing(id plumbing.Hash) bool {
+	r.missingMu.Lock()
+	defer r.missingMu.Unlock()
+	t, ok := r.missing[id]
+	if ok && time.Since(t) > missingTreeTimeout {
+		delete(r.missing, id)
+		ok = false
+	}
+	return ok
+}
+
+// setMissing records that fetching the tree id failed. It drops
+// expired entries, so names that are never looked up again do not
+// accumulate.
+func (r *gitilesConfigFSRoot) setMissing(id plumbing.Hash) {
+	r.missingMu.Lock()
+	defer r.missingMu.Unlock()
+	now := time.Now()
+	for k, t := range r.missing {
+		if now.Sub(t) > missingTreeTimeout {
+			delete(r.missing, k)
+		}
+	}
+	r.missing[id] = now
 }
 
 func parseID(s string) (*plumbing.Hash, error) {
@@ -62,14 +100,18 @@ func (r *gitilesConfigFSRoot) Lookup(ctx context.Context, name string, out *fuse
 
 	tree, err := r.cache.Tree.Get(id)
 	if err != nil {
+		if r.isMissing(*id) {
+			return nil, syscall.EIO
+		}
 		tree, err = r.service.GetTree(id.String(), "/", true)
 		if err != nil {
-			log.Printf("GetTree(%s): %v", id, err)
+			r.options.Logger.Sub("fs").Errorf("GetTree(%s): %v", id, err)
+			r.setMissing(*id)
 			return nil, syscall.EIO
 		}
 
 		if err := r.cache.Tree.Add(id, tree); err != nil {
-			log.Printf("TreeCache.Add(%s): %v", id, err)
+			r.cache.Logger().Warningf("TreeCache.Add(%s): %v", id, err)
 		}
 	}
 
@@ -78,6 +120,7 @@ func (r *gitilesConfigFSRoot) Lookup(ctx context.Context, name string, out *fuse
 		GitilesOptions: r.options,
 	}
 	newRoot := NewGitilesRoot(r.cache, tree, r.service, gro)
+	newRoot.nodeCache = r.nodeCache
 	ch := r.NewPersistentInode(
 		ctx,
 		newRoot,
@@ -97,8 +140,10 @@ func NewGitilesConfigFSRoot(c *cache.Cache, service *gitiles.RepoService, option
 	// a periodic removal of all subtrees trees. Since the FS is
 	// read-only that should cause no ill effects.
 	return &gitilesConfigFSRoot{
-		cache:   c,
-		service: service,
-		options: *options,
+		cache:     c,
+		nodeCache: newNodeCache(),
+		service:   service,
+		options:   *options,
+		missing:   map[plumbing.Hash]time.Time{},
 	}
 }
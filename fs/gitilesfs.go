@@ -34,6 +34,7 @@ import (
 
 	"github.com/google/slothfs/cache"
 	"github.com/google/slothfs/gitiles"
+	"github.com/google/slothfs/logging"
 	"github.com/hanwen/go-fuse/fs"
 	"github.com/hanwen/go-fuse/fuse"
 )
@@ -45,11 +46,17 @@ type gitilesRoot struct {
 
 	nodeCache *nodeCache
 
-	cache   *cache.Cache
+	cache *cache.Cache
+
+	// service is nil for a local repository.
 	service *gitiles.RepoService
 	tree    *gitiles.Tree
 	opts    GitilesRevisionOptions
 
+	// name identifies the repository in log messages.
+	name   string
+	logger *logging.Logger
+
 	handleLessIO bool
 
 	// OID => path
@@ -57,8 +64,14 @@ type gitilesRoot struct {
 
 	lazyRepo *cache.LazyRepo
 
-	fetchingCond *sync.Cond
-	fetching     map[plumbing.Hash]bool
+	// File accesses are recorded in trace while tracing is
+	// nonzero.
+	trace   *accessLog
+	tracing int32
+
+	// If zero, opening files does not trigger a clone. It is set
+	// at runtime through .slothfs/config/clone.
+	cloning int32
 }
 
 // gitilesNode represents a read-only blob in the FUSE filesystem.
@@ -73,8 +86,10 @@ type gitilesNode struct {
 	id         plumbing.Hash
 	linkTarget []byte
 
-	// if set, clone the repo on reading this file.
-	clone bool
+	// links lists the roots holding this node and its path in
+	// each, as nodes may be shared between roots.
+	linksMu sync.Mutex
+	links   []nodeLink
 
 	// The timestamp is writable; protect it with a mutex.
 	mtimeMu sync.Mutex
@@ -82,6 +97,33 @@ type gitilesNode struct {
 
 	// This is to verify that FOPEN_KEEP_CACHE is working as expected.
 	readCount uint32
+
+	// opened is set on the first open, for the dedup statistics.
+	opened int32
+}
+
+// nodeLink is a place where a gitilesNode appears.
+type nodeLink struct {
+	root *gitilesRoot
+	path string
+
+	// clone is set if the clone options of root ask for a clone
+	// on reading path.
+	clone bool
+}
+
+// addLink records that n appears in r at path.
+func (n *gitilesNode) addLink(r *gitilesRoot, path string, clone bool) {
+	n.linksMu.Lock()
+	defer n.linksMu.Unlock()
+	n.links = append(n.links, nodeLink{r, path, clone})
+}
+
+// linkList returns the places where n appears.
+func (n *gitilesNode) linkList() []nodeLink {
+	n.linksMu.Lock()
+	defer n.linksMu.Unlock()
+	return n.links
 }
 
 var _ = (fs.NodeReadlinker)((*gitilesNode)(nil))
@@ -90,10 +132,33 @@ func (n *gitilesNode) Readlink(ctx context.Context) ([]byte, syscall.Errno) {
 	return n.linkTarget, 0
 }
 
+// treeEntryAttr returns the mode and size of a file from its entry in
+// the long tree listing, so stat never has to fetch the blob. Like
+// git, only the executable bit of the permissions is kept.
+func treeEntryAttr(e *gitiles.TreeEntry) (mode uint32, size int64) {
+	if e.Size != nil {
+		size = int64(*e.Size)
+	}
+	switch {
+	case e.Mode&syscall.S_IFMT == syscall.S_IFLNK:
+		mode = syscall.S_IFLNK | 0777
+		if e.Target != nil {
+			size = int64(len(*e.Target))
+		}
+	case e.Mode&0111 != 0:
+		mode = syscall.S_IFREG | 0755
+	default:
+		mode = syscall.S_IFREG | 0644
+	}
+	return mode, size
+}
+
 var _ = (fs.NodeGetattrer)((*gitilesNode)(nil))
 
+// Getattr only uses the tree metadata, and never fetches the blob.
 func (n *gitilesNode) Getattr(ctx context.Context, h fs.FileHandle, out *fuse.AttrOut) (code syscall.Errno) {
 	out.Size = uint64(n.size)
+	out.Blocks = (out.Size + 511) / 512
 	out.Mode = n.mode
 
 	n.mtimeMu.Lock()
@@ -148,15 +213,24 @@ func (n *gitilesNode) Listxattr(ctx context.Context, dest []byte) (uint32, sysca
 var _ = (fs.NodeOpener)((*gitilesNode)(nil))
 
 func (n *gitilesNode) Open(ctx context.Context, flags uint32) (h fs.FileHandle, fuseFlags uint32, code syscall.Errno) {
+	atomic.AddInt64(&opStats.Opens, 1)
+	n.traceAccess("open")
 	if n.root.handleLessIO {
 		// We say ENOSYS so FUSE on Linux uses handle-less I/O.
 		return nil, 0, syscall.ENOSYS
 	}
 
-	f, err := n.root.openFile(n.id, n.clone)
+	if data, ok := n.root.cache.Blob.OpenMemory(n.id); ok {
+		n.countOpen(true)
+		return &memHandle{data}, fuse.FOPEN_KEEP_CACHE, 0
+	}
+
+	r, clone := n.source()
+	f, cached, err := r.openFile(ctx, n.id, clone)
 	if err != nil {
 		return nil, 0, fs.ToErrno(err)
 	}
+	n.countOpen(cached)
 
 	return fs.NewLoopbackFile(int(f.Fd())), fuse.FOPEN_KEEP_CACHE, 0
 }
@@ -166,19 +240,48 @@ var _ = (fs.NodeReader)((*gitilesNode)(nil))
 func (n *gitilesNode) Read(ctx context.Context, file fs.FileHandle, dest []byte, off int64) (fuse.ReadResult, syscall.Errno) {
 	if off == 0 {
 		atomic.AddUint32(&n.readCount, 1)
+		atomic.AddInt64(&opStats.Reads, 1)
+		n.traceAccess("read")
 	}
 
 	if n.root.handleLessIO {
-		return n.handleLessRead(file, dest, off)
+		return n.handleLessRead(ctx, file, dest, off)
 	}
 
 	return file.(fs.FileReader).Read(ctx, dest, off)
 }
 
-func (n *gitilesNode) handleLessRead(file fs.FileHandle, dest []byte, off int64) (fuse.ReadResult, syscall.Errno) {
+// countOpen updates the dedup statistics for an open, which was
+// served from the cache if cached is set. Only the first open of a
+// node counts: if its blob was in the cache by then, it was stored
+// for another file with the same content.
+func (n *gitilesNode) countOpen(cached bool) {
+	if atomic.CompareAndSwapInt32(&n.opened, 0, 1) && cached {
+		atomic.AddInt64(&opStats.DedupOpens, 1)
+		atomic.AddInt64(&opStats.DedupBytes, n.size)
+	}
+}
+
+// traceAccess records an access to n in the roots holding it that
+// have tracing enabled. The kernel does not say through which path a
+// shared node was reached, so the access is recorded at all of them.
+func (n *gitilesNode) traceAccess(op string) {
+	for _, l := range n.linkList() {
+		if atomic.LoadInt32(&l.root.tracing) != 0 {
+			l.root.trace.add(op, l.path)
+		}
+	}
+}
+
+func (n *gitilesNode) handleLessRead(ctx context.Context, file fs.FileHandle, dest []byte, off int64) (fuse.ReadResult, syscall.Errno) {
+	if data, ok := n.root.cache.Blob.ReadMemory(n.id); ok {
+		return (&memHandle{data}).Read(ctx, dest, off)
+	}
+
 	// TODO(hanwen): for large files this is not efficient. Should
 	// have a cache of open file handles.
-	f, err := n.root.openFile(n.id, n.clone)
+	r, clone := n.source()
+	f, _, err := r.openFile(ctx, n.id, clone)
 	if err != nil {
 		return nil, fs.ToErrno(err)
 	}
@@ -191,52 +294,108 @@ func (n *gitilesNode) handleLessRead(file fs.FileHandle, dest []byte, off int64)
 	return fuse.ReadResultData(dest[:m]), fs.ToErrno(err)
 }
 
-// openFile returns a file handle for the given blob. If `clone` is
-// given, we may try a clone of the git repository
-func (r *gitilesRoot) openFile(id plumbing.Hash, clone bool) (*os.File, error) {
+// memHandle serves a blob held in memory.
+type memHandle struct {
+	data []byte
+}
+
+var _ = (fs.FileReader)((*memHandle)(nil))
+
+func (h *memHandle) Read(ctx context.Context, dest []byte, off int64) (fuse.ReadResult, syscall.Errno) {
+	if off >= int64(len(h.data)) {
+		return fuse.ReadResultData(nil), 0
+	}
+	end := off + int64(len(dest))
+	if end > int64(len(h.data)) {
+		end = int64(len(h.data))
+	}
+	return fuse.ReadResultData(h.data[off:end]), 0
+}
+
+// source returns the root to read n through, and whether that should
+// clone the root's repository. The kernel does not say through which
+// path a shared node was opened, so the first root holding n that
+// would clone for its own path is used.
+func (n *gitilesNode) source() (*gitilesRoot, bool) {
+	for _, l := range n.linkList() {
+		if l.root.shouldClone(n, l) {
+			return l.root, true
+		}
+	}
+	return n.root, false
+}
+
+// shouldClone returns whether reading n at the link l in r should
+// trigger a clone. The clone policy takes precedence over the clone
+// options, as it may change while mounted.
+func (r *gitilesRoot) shouldClone(n *gitilesNode, l nodeLink) bool {
+	if atomic.LoadInt32(&r.cloning) == 0 {
+		return false
+	}
+	if r.opts.Policy != nil && r.opts.CloneURL != "" {
+		if clone, ok := r.opts.Policy.Match(r.name, l.path, n.size); ok {
+			return clone
+		}
+	}
+	return l.clone
+}
+
+// openFile returns a file handle for the given blob, and whether it
+// was already in the cache. If `clone` is given, we may try a clone
+// of the git repository. If ctx is done before the blob is fetched,
+// it returns EINTR; a blob that arrived regardless is still returned.
+func (r *gitilesRoot) openFile(ctx context.Context, id plumbing.Hash, clone bool) (f *os.File, cached bool, err error) {
 	f, ok := r.cache.Blob.Open(id)
 	if ok {
-		return f, nil
+		return f, true, nil
 	}
 
-	f, err := r.fetchFile(id, clone)
+	f, err = r.fetchFile(ctx, id, clone)
+	if err != nil && ctx.Err() != nil {
+		return nil, false, syscall.EINTR
+	}
 	if err != nil {
-		log.Printf("fetchFile(%s): %v", id.String(), err)
-		return nil, syscall.ESPIPE
+		r.logger.Errorf("fetchFile(%s): %v", id.String(), err)
+		return nil, false, syscall.ESPIPE
 	}
 
-	return f, nil
+	return f, false, nil
 }
 
-func (r *gitilesRoot) fetchFile(id plumbing.Hash, clone bool) (*os.File, error) {
-	r.fetchingCond.L.Lock()
-	defer r.fetchingCond.L.Unlock()
-
-	for r.fetching[id] {
-		r.fetchingCond.Wait()
+// fetchFile fetches a blob into the cache, and opens it. Concurrent
+// fetches of the same blob are coalesced.
+func (r *gitilesRoot) fetchFile(ctx context.Context, id plumbing.Hash, clone bool) (*os.File, error) {
+	err := blobFetches.do(ctx, fetchKey{r.cache.Blob, id}, func(ctx context.Context) error {
+		// A fetch may have finished between our cache lookup
+		// and joining the group.
+		if f, ok := r.cache.Blob.Open(id); ok {
+			f.Close()
+			return nil
+		}
+		return r.fetchFileExpensive(ctx, id, clone)
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	f, ok := r.cache.Blob.Open(id)
-	if ok {
-		return f, nil
+	if !ok {
+		return nil, fmt.Errorf("fetch succeeded, but blob %s not there", id.String())
 	}
+	return f, nil
+}
 
-	r.fetching[id] = true
-	defer func() { delete(r.fetching, id) }()
-	r.fetchingCond.L.Unlock()
-	err := r.fetchFileExpensive(id, clone)
-	r.fetchingCond.L.Lock()
-	r.fetchingCond.Broadcast()
-
-	if err == nil {
-		f, ok = r.cache.Blob.Open(id)
-		if !ok {
-			return nil, fmt.Errorf("fetch succeeded, but blob %s not there", id.String())
-		}
-		return f, nil
+// readLinkTarget returns the content of the blob holding a symlink
+// target.
+func (r *gitilesRoot) readLinkTarget(ctx context.Context, id plumbing.Hash) (string, error) {
+	f, _, err := r.openFile(ctx, id, false)
+	if err != nil {
+		return "", err
 	}
+	defer f.Close()
 
-	return nil, err
+	c, err := ioutil.ReadAll(f)
+	return string(c), err
 }
 
 func readBlob(blob *object.Blob) ([]byte, error) {
@@ -249,9 +408,15 @@ func readBlob(blob *object.Blob) ([]byte, error) {
 	return ioutil.ReadAll(r)
 }
 
-func (r *gitilesRoot) fetchFileExpensive(id plumbing.Hash, clone bool) error {
+func (r *gitilesRoot) fetchFileExpensive(ctx context.Context, id plumbing.Hash, clone bool) error {
+	start := time.Now()
+	defer func() {
+		atomic.AddInt64(&opStats.Fetches, 1)
+		atomic.AddInt64((*int64)(&opStats.FetchTime), int64(time.Since(start)))
+	}()
+
 	repo := r.lazyRepo.Repository()
-	if clone && repo == nil {
+	if clone && repo == nil && atomic.LoadInt32(&r.cloning) != 0 {
 		r.lazyRepo.Clone()
 	}
 
@@ -267,13 +432,22 @@ func (r *gitilesRoot) fetchFileExpensive(id plumbing.Hash, clone bool) error {
 	}
 
 	if content == nil {
+		if r.service == nil {
+			return fmt.Errorf("blob %s not found in %s", id, r.name)
+		}
 		path := r.shaMap[id]
 
-		var err error
-		content, err = r.service.GetBlob(r.opts.Revision, path)
+		// Stream the blob into the cache, as it may be too large
+		// to hold in memory.
+		blob, err := r.service.GetBlobReader(ctx, r.opts.Revision, path)
 		if err != nil {
 			return fmt.Errorf("GetBlob(%s, %s): %v", r.opts.Revision, path, err)
 		}
+		defer blob.Close()
+		if err := r.cache.Blob.WriteFrom(id, blob); err != nil {
+			return fmt.Errorf("GetBlob(%s, %s): %v", r.opts.Revision, path, err)
+		}
+		return nil
 	}
 
 	if err := r.cache.Blob.Write(id, content); err != nil {
@@ -314,15 +488,22 @@ func (n *dataNode) GetXAttr(ctx context.Context, attribute string) (data []byte,
 // NewGitilesRoot returns the root node for a file system.
 func NewGitilesRoot(c *cache.Cache, tree *gitiles.Tree, service *gitiles.RepoService, options GitilesRevisionOptions) *gitilesRoot {
 	r := &gitilesRoot{
-		service:      service,
-		nodeCache:    newNodeCache(),
-		cache:        c,
-		shaMap:       map[plumbing.Hash]string{},
-		tree:         tree,
-		opts:         options,
-		lazyRepo:     cache.NewLazyRepo(options.CloneURL, c),
-		fetchingCond: sync.NewCond(&sync.Mutex{}),
-		fetching:     map[plumbing.Hash]bool{},
+		service:   service,
+		nodeCache: newNodeCache(),
+		cache:     c,
+		shaMap:    map[plumbing.Hash]string{},
+		tree:      tree,
+		opts:      options,
+		lazyRepo:  cache.NewLazyRepo(options.CloneURL, options.CloneDepth, c),
+		logger:    options.Logger.Sub("fs"),
+		trace:     &accessLog{},
+		cloning:   1,
+	}
+	if service != nil {
+		r.name = service.Name
+	}
+	if options.TraceAccess {
+		r.tracing = 1
 	}
 
 	return r
@@ -352,29 +533,54 @@ func (r *gitilesRoot) pathTo(dir string) *fs.Inode {
 	return p
 }
 
+// skipReason returns why a tree entry other than a submodule cannot
+// be mounted, or "" if it can. Only regular files and symlinks are
+// supported.
+func skipReason(e *gitiles.TreeEntry) string {
+	if e.Type != "blob" {
+		return fmt.Sprintf("unexpected object type %q", e.Type)
+	}
+	switch e.Mode & syscall.S_IFMT {
+	case syscall.S_IFREG, syscall.S_IFLNK:
+	default:
+		return fmt.Sprintf("unsupported mode %o", e.Mode)
+	}
+	if _, err := parseID(e.ID); err != nil {
+		return err.Error()
+	}
+	return ""
+}
+
 var _ = (fs.NodeOnAdder)((*gitilesRoot)(nil))
 
 func (r *gitilesRoot) OnAdd(ctx context.Context) {
-	for _, e := range r.tree.Entries {
+	var submodules []gitiles.TreeEntry
+	var gitmodules *gitiles.TreeEntry
+	for i, e := range r.tree.Entries {
 		if e.Type == "commit" {
-			// TODO(hanwen): support submodules.  For now,
-			// we pretend we are plain git, which also
-			// leaves an empty directory in the place of a submodule.
-			r.pathTo(e.Name)
+			if r.opts.Submodules {
+				submodules = append(submodules, e)
+			} else {
+				// Pretend we are plain git, which leaves
+				// an empty directory in the place of a
+				// submodule.
+				r.pathTo(e.Name)
+			}
 			continue
 		}
-		if e.Type != "blob" {
-			log.Panicf("unexpected object type %s", e.Type)
+		if e.Name == ".gitmodules" {
+			gitmodules = &r.tree.Entries[i]
+		}
+		if reason := skipReason(&e); reason != "" {
+			r.logger.Warningf("%s: skipping %s: %s", r.name, e.Name, reason)
+			continue
 		}
 
 		p := e.Name
 		dir, base := filepath.Split(p)
 
 		parent := r.pathTo(dir)
-		id, err := parseID(e.ID)
-		if err != nil {
-			return
-		}
+		id, _ := parseID(e.ID)
 
 		// Determine if file should trigger a clone.
 		clone := r.opts.CloneURL != ""
@@ -387,43 +593,59 @@ func (r *gitilesRoot) OnAdd(ctx context.Context) {
 			}
 		}
 
-		xbit := e.Mode&0111 != 0
-		n := r.nodeCache.get(id, xbit)
+		mode, size := treeEntryAttr(&e)
+		r.shaMap[*id] = p
+		n := r.nodeCache.get(id, mode)
 		if n == nil {
 			n = &gitilesNode{
-				id:    *id,
-				mode:  uint32(e.Mode),
-				clone: clone,
-				root:  r,
+				id:   *id,
+				mode: mode,
+				size: size,
+				root: r,
 				// Ninja uses mtime == 0 as "doesn't exist"
 				// flag, (see ninja/files/src/graph.h:66), so
 				// use a nonzero timestamp here.
 				mtime: time.Unix(1, 0),
 			}
-			if e.Size != nil {
-				n.size = int64(*e.Size)
-			}
 
-			mode := uint32(syscall.S_IFREG)
-			if e.Target != nil {
-				n.linkTarget = []byte(*e.Target)
+			fileType := uint32(syscall.S_IFREG)
+			target := e.Target
+			if target == nil && mode&syscall.S_IFMT == syscall.S_IFLNK {
+				// The tree did not include the target, so
+				// fetch the (small) blob holding it.
+				if t, err := r.readLinkTarget(ctx, *id); err != nil {
+					r.logger.Errorf("readLinkTarget(%s): %v", p, err)
+				} else {
+					target = &t
+				}
+			}
+			if target != nil {
+				n.linkTarget = []byte(*target)
 				n.size = int64(len(n.linkTarget))
-				mode = syscall.S_IFLNK
+				fileType = syscall.S_IFLNK
 			}
 
-			r.shaMap[*id] = p
+			parent.NewPersistentInode(ctx, n, fs.StableAttr{Mode: fileType})
 
-			ch := parent.NewPersistentInode(ctx, n, fs.StableAttr{Mode: mode})
-			parent.AddChild(base, ch, true)
-			r.nodeCache.add(n)
-		} else {
-			parent.AddChild(base, n.EmbeddedInode(), true)
+			// Another root may have added a node for the same
+			// blob in the meantime; use the cached one, so the
+			// blob has a single inode.
+			n = r.nodeCache.add(n)
 		}
+		n.addLink(r, p, clone)
+		parent.AddChild(base, n.EmbeddedInode(), true)
+	}
 
+	if len(submodules) > 0 {
+		r.mountSubmodules(ctx, submodules, gitmodules)
 	}
 
+	metaDir := r.opts.MetaDir
+	if metaDir == "" {
+		metaDir = DefaultMetaDir
+	}
 	slothfsNode := r.NewPersistentInode(ctx, &fs.Inode{}, fs.StableAttr{Mode: syscall.S_IFDIR})
-	r.AddChild(".slothfs", slothfsNode, true)
+	r.AddChild(metaDir, slothfsNode, true)
 	idFile := r.NewPersistentInode(ctx, &fs.MemRegularFile{
 		Data: []byte(r.tree.ID)}, fs.StableAttr{Mode: syscall.S_IFREG})
 
@@ -438,6 +660,42 @@ func (r *gitilesRoot) OnAdd(ctx context.Context) {
 
 	slothfsNode.AddChild("tree.json", jsonFile, false)
 
+	statsFile := r.NewPersistentInode(ctx, &statsNode{
+		cache:   r.cache,
+		service: r.service,
+		logger:  r.logger,
+	}, fs.StableAttr{Mode: syscall.S_IFREG})
+	slothfsNode.AddChild("stats.json", statsFile, false)
+
+	if r.opts.Revision != "" {
+		commitFile := r.NewPersistentInode(ctx, &commitNode{
+			root: r,
+		}, fs.StableAttr{Mode: syscall.S_IFREG})
+		slothfsNode.AddChild("commit.json", commitFile, false)
+	}
+
+	traceFile := r.NewPersistentInode(ctx, &accessLogNode{
+		log: r.trace,
+	}, fs.StableAttr{Mode: syscall.S_IFREG})
+	slothfsNode.AddChild("access.log", traceFile, false)
+
+	configNode := r.NewPersistentInode(ctx, &fs.Inode{}, fs.StableAttr{Mode: syscall.S_IFDIR})
+	slothfsNode.AddChild("config", configNode, false)
+	configNode.AddChild("trace_access", r.NewPersistentInode(ctx, &knobNode{
+		value: &r.tracing,
+	}, fs.StableAttr{Mode: syscall.S_IFREG}), false)
+	if r.opts.CloneURL != "" {
+		configNode.AddChild("clone", r.NewPersistentInode(ctx, &knobNode{
+			value: &r.cloning,
+		}, fs.StableAttr{Mode: syscall.S_IFREG}), false)
+		if r.opts.Policy != nil {
+			configNode.AddChild("clone_policy", r.NewPersistentInode(ctx, &policyNode{
+				policy: r.opts.Policy,
+				logger: r.logger,
+			}, fs.StableAttr{Mode: syscall.S_IFREG}), false)
+		}
+	}
+
 	// We don't need the tree data anymore.
 	r.tree = nil
 
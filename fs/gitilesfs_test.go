@@ -16,7 +16,9 @@ package fs
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -35,6 +37,9 @@ import (
 
 	"github.com/google/slothfs/gitiles"
 	"github.com/google/slothfs/manifest"
+	"github.com/hanwen/go-fuse/fs"
+	"github.com/hanwen/go-fuse/fuse"
+	"gopkg.in/src-d/go-git.v4/plumbing"
 )
 
 const fuseDebug = false
@@ -92,7 +97,8 @@ func init() {
 }
 
 var testGitiles = map[string]string{
-	"/platform/manifest/+show/master/default.xml?format=TEXT": testManifestXML,
+	"/platform/build/kati/+show/ce34badf691d36e8048b63f89d1a86ee5fa4325c/link?format=TEXT": "AUTHORS",
+	"/platform/manifest/+show/master/default.xml?format=TEXT":                              testManifestXML,
 	"/?format=JSON": `)]}'
 {
   "platform/build/kati": {
@@ -315,6 +321,60 @@ func TestGitilesFSSharedNodes(t *testing.T) {
 	}
 }
 
+// twoRoots mounts two file systems side by side.
+type twoRoots struct {
+	fs.Inode
+
+	a, b fs.InodeEmbedder
+}
+
+func (r *twoRoots) OnAdd(ctx context.Context) {
+	r.AddChild("a", r.NewPersistentInode(ctx, r.a, fs.StableAttr{Mode: syscall.S_IFDIR}), true)
+	r.AddChild("b", r.NewPersistentInode(ctx, r.b, fs.StableAttr{Mode: syscall.S_IFDIR}), true)
+}
+
+func TestGitilesFSSharedNodesAcrossRoots(t *testing.T) {
+	fix, err := newTestFixture()
+	if err != nil {
+		t.Fatal("newTestFixture", err)
+	}
+	defer fix.cleanup()
+
+	repoService := fix.service.NewRepoService("platform/build/kati")
+	treeResp, err := repoService.GetTree("ce34badf691d36e8048b63f89d1a86ee5fa4325c", "", true)
+	if err != nil {
+		t.Fatal("Tree:", err)
+	}
+
+	options := GitilesRevisionOptions{
+		Revision: "ce34badf691d36e8048b63f89d1a86ee5fa4325c",
+	}
+
+	a := NewGitilesRoot(fix.cache, treeResp, repoService, options)
+	b := NewGitilesRoot(fix.cache, treeResp, repoService, options)
+	b.nodeCache = a.nodeCache
+
+	if err := fix.mount(&twoRoots{a: a, b: b}); err != nil {
+		t.Fatal("mount", err)
+	}
+
+	for _, nm := range []string{"a/AUTHORS", "b/AUTHORS"} {
+		if _, err := ioutil.ReadFile(filepath.Join(fix.mntDir, nm)); err != nil {
+			t.Fatalf("ReadFile(%s): %v", nm, err)
+		}
+	}
+
+	if a.GetChild("AUTHORS") != b.GetChild("AUTHORS") {
+		t.Error("equal blobs in different roots did not share inodes.")
+	}
+
+	for key, got := range fix.testServer.requests {
+		if got != 1 {
+			t.Errorf("got request count %d for %s, want 1", got, key)
+		}
+	}
+}
+
 func TestGitilesFSTreeID(t *testing.T) {
 	fix, err := newTestFixture()
 	if err != nil {
@@ -343,6 +403,15 @@ func TestGitilesFSTreeID(t *testing.T) {
 		t.Errorf("got %q, want %q", got, want)
 	}
 
+	var stats Stats
+	if content, err := ioutil.ReadFile(filepath.Join(fix.mntDir, ".slothfs/stats.json")); err != nil {
+		t.Errorf("ReadFile(.slothfs/stats.json): %v", err)
+	} else if err := json.Unmarshal(content, &stats); err != nil {
+		t.Errorf("Unmarshal(%q): %v", content, err)
+	} else if stats.Gitiles.Requests == 0 {
+		t.Errorf("got stats %+v, want nonzero gitiles requests", stats)
+	}
+
 	data := make([]byte, 1024)
 	sz, err := syscall.Listxattr(filepath.Join(fix.mntDir, "AUTHORS"), data)
 	if err != nil {
@@ -390,6 +459,144 @@ func TestGitilesFSSubmodule(t *testing.T) {
 	}
 }
 
+func TestGitilesFSSymlinkBlob(t *testing.T) {
+	fix, err := newTestFixture()
+	if err != nil {
+		t.Fatal("newTestFixture", err)
+	}
+	defer fix.cleanup()
+
+	repoService := fix.service.NewRepoService("platform/build/kati")
+
+	// A tree without the "target" field, as returned by servers
+	// that don't provide it.
+	tree := &gitiles.Tree{
+		ID: "ffffbadf691d36e8048b63f89d1a86ee5fa4325c",
+		Entries: []gitiles.TreeEntry{{
+			Name: "link",
+			Type: "blob",
+			Mode: 0120000,
+			ID:   "61cc726c89ed1be7935452ffd79dfb8a20cee640",
+		}},
+	}
+	fs := NewGitilesRoot(fix.cache, tree, repoService, GitilesRevisionOptions{
+		Revision: "ce34badf691d36e8048b63f89d1a86ee5fa4325c",
+	})
+	if err := fix.mount(fs); err != nil {
+		t.Fatal("mount", err)
+	}
+
+	fn := filepath.Join(fix.mntDir, "link")
+	if fi, err := os.Lstat(fn); err != nil {
+		t.Fatalf("Lstat(%q): %v", fn, err)
+	} else if fi.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("Lstat(%q): got mode %v, want symlink", fn, fi.Mode())
+	}
+	if got, err := os.Readlink(fn); err != nil {
+		t.Fatalf("Readlink(%q): %v", fn, err)
+	} else if got != "AUTHORS" {
+		t.Errorf("Readlink(%q): got %q, want %q", fn, got, "AUTHORS")
+	}
+}
+
+func TestSkipReason(t *testing.T) {
+	const id = "61cc726c89ed1be7935452ffd79dfb8a20cee640"
+	for _, tc := range []struct {
+		entry gitiles.TreeEntry
+		skip  bool
+	}{
+		{gitiles.TreeEntry{Type: "blob", Mode: 0100644, ID: id}, false},
+		{gitiles.TreeEntry{Type: "blob", Mode: 0100755, ID: id}, false},
+		{gitiles.TreeEntry{Type: "blob", Mode: 0120000, ID: id}, false},
+		{gitiles.TreeEntry{Type: "tree", Mode: 040000, ID: id}, true},
+		{gitiles.TreeEntry{Type: "tag", Mode: 0100644, ID: id}, true},
+		{gitiles.TreeEntry{Type: "blob", Mode: 0160000, ID: id}, true},
+		{gitiles.TreeEntry{Type: "blob", Mode: 0100644, ID: "xyz"}, true},
+	} {
+		if got := skipReason(&tc.entry); (got != "") != tc.skip {
+			t.Errorf("skipReason(%v): got %q, want skip %v", tc.entry, got, tc.skip)
+		}
+	}
+}
+
+func TestTreeEntryAttr(t *testing.T) {
+	size := 42
+	target := "../target"
+	for _, tc := range []struct {
+		entry gitiles.TreeEntry
+		mode  uint32
+		size  int64
+	}{
+		{gitiles.TreeEntry{Mode: 0100644, Size: &size}, syscall.S_IFREG | 0644, 42},
+		{gitiles.TreeEntry{Mode: 0100664, Size: &size}, syscall.S_IFREG | 0644, 42},
+		{gitiles.TreeEntry{Mode: 0100755, Size: &size}, syscall.S_IFREG | 0755, 42},
+		{gitiles.TreeEntry{Mode: 0120000, Size: &size, Target: &target}, syscall.S_IFLNK | 0777, 9},
+		{gitiles.TreeEntry{Mode: 0100644}, syscall.S_IFREG | 0644, 0},
+	} {
+		mode, size := treeEntryAttr(&tc.entry)
+		if mode != tc.mode || size != tc.size {
+			t.Errorf("treeEntryAttr(%v): got %o, %d, want %o, %d", tc.entry, mode, size, tc.mode, tc.size)
+		}
+	}
+}
+
+func TestGitilesFSUnsupportedEntries(t *testing.T) {
+	fix, err := newTestFixture()
+	if err != nil {
+		t.Fatal("newTestFixture", err)
+	}
+	defer fix.cleanup()
+
+	repoService := fix.service.NewRepoService("platform/build/kati")
+	tree := &gitiles.Tree{
+		ID: "ffffbadf691d36e8048b63f89d1a86ee5fa4325c",
+		Entries: []gitiles.TreeEntry{
+			{
+				Name: "sub",
+				Type: "commit",
+				Mode: 0160000,
+				ID:   "ce34badf691d36e8048b63f89d1a86ee5fa4325c",
+			},
+			{
+				Name: "odd",
+				Type: "blob",
+				Mode: 0140000,
+				ID:   "61cc726c89ed1be7935452ffd79dfb8a20cee640",
+			},
+			{
+				Name: "bad-id",
+				Type: "blob",
+				Mode: 0100644,
+				ID:   "not-a-sha1",
+			},
+			{
+				Name: "AUTHORS",
+				Type: "blob",
+				Mode: 0100644,
+				ID:   "787d767f94fd634ed29cd69ec9f93bab2b25f5d4",
+			},
+		},
+	}
+	fs := NewGitilesRoot(fix.cache, tree, repoService, GitilesRevisionOptions{
+		Revision: "ce34badf691d36e8048b63f89d1a86ee5fa4325c",
+	})
+	if err := fix.mount(fs); err != nil {
+		t.Fatal("mount", err)
+	}
+
+	if fi, err := os.Lstat(filepath.Join(fix.mntDir, "sub")); err != nil || !fi.IsDir() {
+		t.Errorf("Lstat(sub): got %v, %v, want directory", fi, err)
+	}
+	for _, n := range []string{"odd", "bad-id"} {
+		if _, err := os.Lstat(filepath.Join(fix.mntDir, n)); !os.IsNotExist(err) {
+			t.Errorf("Lstat(%s): got %v, want ENOENT", n, err)
+		}
+	}
+	if c, err := ioutil.ReadFile(filepath.Join(fix.mntDir, "AUTHORS")); err != nil || !bytes.Equal(c, testBlob) {
+		t.Errorf("ReadFile(AUTHORS): got %q, %v, want %q", c, err, testBlob)
+	}
+}
+
 func TestGitilesFSBasic(t *testing.T) {
 	fix, err := newTestFixture()
 	if err != nil {
@@ -444,7 +651,7 @@ func TestGitilesFSBasic(t *testing.T) {
 		t.Fatalf("got node type %T, want *gitilesNode", ch.Operations())
 	}
 
-	if giNode.clone {
+	if _, clone := giNode.source(); clone {
 		t.Errorf(".mk file had clone set.")
 	}
 }
@@ -602,7 +809,7 @@ func TestGitilesHostFS(t *testing.T) {
 	}
 	defer fix.cleanup()
 
-	if fs, err := NewHostFS(fix.cache, fix.service, nil); err != nil {
+	if fs, err := NewHostFS(fix.cache, fix.service, nil, nil); err != nil {
 		t.Fatalf("NewHostFS: %v", err)
 	} else if err := fix.mount(fs); err != nil {
 		t.Fatalf("mount: %v", err)
@@ -617,3 +824,78 @@ func TestGitilesHostFS(t *testing.T) {
 		t.Errorf("blob for %s differs", fn)
 	}
 }
+
+func TestMemHandleRead(t *testing.T) {
+	h := &memHandle{[]byte("hello world")}
+	for _, tc := range []struct {
+		off  int64
+		size int
+		want string
+	}{
+		{0, 5, "hello"},
+		{6, 100, "world"},
+		{11, 5, ""},
+		{20, 5, ""},
+	} {
+		res, errno := h.Read(context.Background(), make([]byte, tc.size), tc.off)
+		if errno != 0 {
+			t.Fatalf("Read(%d, %d): %v", tc.off, tc.size, errno)
+		}
+		got, _ := res.Bytes(nil)
+		if string(got) != tc.want {
+			t.Errorf("Read(%d, %d): got %q, want %q", tc.off, tc.size, got, tc.want)
+		}
+	}
+}
+
+func TestOpenFileCanceled(t *testing.T) {
+	started := make(chan struct{})
+	c, service, cleanup := newTestService(t, func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-r.Context().Done()
+	})
+	defer cleanup()
+
+	root := NewGitilesRoot(c, &gitiles.Tree{}, service.NewRepoService("platform/build"), GitilesRevisionOptions{Revision: "master"})
+	id := plumbing.NewHash("ce34badf691d36e8048b63f89d1a86ee5fa4325c")
+	root.shaMap[id] = "core/main.mk"
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-started
+		cancel()
+	}()
+	if _, _, err := root.openFile(ctx, id, false); err != syscall.EINTR {
+		t.Errorf("openFile: got %v, want EINTR", err)
+	}
+	if _, ok := c.Blob.Open(id); ok {
+		t.Errorf("blob %s was cached", id)
+	}
+}
+
+func TestGitilesConfigFSMissingTree(t *testing.T) {
+	var requests int32
+	c, service, cleanup := newTestService(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		http.NotFound(w, r)
+	})
+	defer cleanup()
+
+	root := NewGitilesConfigFSRoot(c, service.NewRepoService("platform/build"), &GitilesOptions{}).(*gitilesConfigFSRoot)
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		if _, errno := root.Lookup(ctx, "ce34badf691d36e8048b63f89d1a86ee5fa4325c", &fuse.EntryOut{}); errno != syscall.EIO {
+			t.Errorf("Lookup: got %v, want EIO", errno)
+		}
+	}
+	if got := atomic.LoadInt32(&requests); got != 1 {
+		t.Errorf("got %d requests, want 1", got)
+	}
+
+	stale := plumbing.NewHash("58d9fdae2c26d82e04f3fcafc4358b99109f0e70")
+	root.missing[stale] = time.Now().Add(-2 * missingTreeTimeout)
+	root.setMissing(plumbing.NewHash("787d767f94fd634ed29cd69ec9f93bab2b25f5d4"))
+	if _, ok := root.missing[stale]; ok || len(root.missing) != 2 {
+		t.Errorf("got missing %v, want the expired entry dropped", root.missing)
+	}
+}
@@ -24,6 +24,7 @@ import (
 
 	"github.com/google/slothfs/cache"
 	"github.com/google/slothfs/gitiles"
+	"github.com/google/slothfs/policy"
 	"github.com/hanwen/go-fuse/fs"
 )
 
@@ -31,9 +32,11 @@ type hostFS struct {
 	fs.Inode
 
 	cache        *cache.Cache
+	nodeCache    *nodeCache
 	service      *gitiles.Service
 	projects     map[string]*gitiles.Project
 	cloneOptions []CloneOption
+	policy       *policy.Policy
 }
 
 func parents(projMap map[string]*gitiles.Project) map[string]struct{} {
@@ -48,7 +51,9 @@ func parents(projMap map[string]*gitiles.Project) map[string]struct{} {
 	return dirs
 }
 
-func NewHostFS(cache *cache.Cache, service *gitiles.Service, cloneOptions []CloneOption) (*hostFS, error) {
+// NewHostFS returns a file system holding all projects of a Gitiles
+// host. The clone policy p, if non-nil, is shared by all projects.
+func NewHostFS(cache *cache.Cache, service *gitiles.Service, cloneOptions []CloneOption, p *policy.Policy) (*hostFS, error) {
 	projMap, err := service.List(nil)
 	if err != nil {
 		return nil, err
@@ -64,8 +69,10 @@ func NewHostFS(cache *cache.Cache, service *gitiles.Service, cloneOptions []Clon
 	return &hostFS{
 		projects:     projMap,
 		cloneOptions: cloneOptions,
+		policy:       p,
 		service:      service,
 		cache:        cache,
+		nodeCache:    newNodeCache(),
 	}, nil
 }
 
@@ -121,6 +128,14 @@ func (h *hostFS) newProjectNode(parent *fs.Inode, proj *gitiles.Project) fs.Inod
 	opts := GitilesOptions{
 		CloneURL:    proj.CloneURL,
 		CloneOption: h.cloneOptions,
+		Policy:      h.policy,
 	}
-	return NewGitilesConfigFSRoot(h.cache, repoService, &opts)
+	root := NewGitilesConfigFSRoot(h.cache, repoService, &opts).(*gitilesConfigFSRoot)
+
+	// Share nodes between projects, so blobs that are vendored
+	// into multiple repositories are only fetched once. Each
+	// project still decides for its own paths whether a read
+	// clones.
+	root.nodeCache = h.nodeCache
+	return root
 }
@@ -0,0 +1,152 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fs
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/google/slothfs/logging"
+	"github.com/google/slothfs/policy"
+	"github.com/hanwen/go-fuse/fs"
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+// knobNode is a boolean setting that can be changed while the file
+// system is mounted, by writing "0" or "1" to its file.
+type knobNode struct {
+	fs.Inode
+
+	// value is accessed atomically; nonzero means on.
+	value *int32
+}
+
+func (n *knobNode) content() []byte {
+	if atomic.LoadInt32(n.value) != 0 {
+		return []byte("1\n")
+	}
+	return []byte("0\n")
+}
+
+var _ = (fs.NodeGetattrer)((*knobNode)(nil))
+
+func (n *knobNode) Getattr(ctx context.Context, file fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	out.Mode = fuse.S_IFREG | 0644
+	out.Size = uint64(len(n.content()))
+	t := time.Unix(1, 0)
+	out.SetTimes(nil, &t, nil)
+	return 0
+}
+
+var _ = (fs.NodeSetattrer)((*knobNode)(nil))
+
+// Setattr ignores truncation, so the knob can be set with a shell
+// redirect.
+func (n *knobNode) Setattr(ctx context.Context, file fs.FileHandle, in *fuse.SetAttrIn, out *fuse.AttrOut) syscall.Errno {
+	if 0 != in.Valid&(fuse.FATTR_MODE|
+		fuse.FATTR_UID|
+		fuse.FATTR_GID) {
+		return syscall.ENOTSUP
+	}
+	return n.Getattr(ctx, file, out)
+}
+
+var _ = (fs.NodeOpener)((*knobNode)(nil))
+
+func (n *knobNode) Open(ctx context.Context, flags uint32) (fs.FileHandle, uint32, syscall.Errno) {
+	return nil, fuse.FOPEN_DIRECT_IO, 0
+}
+
+var _ = (fs.NodeReader)((*knobNode)(nil))
+
+func (n *knobNode) Read(ctx context.Context, file fs.FileHandle, dest []byte, off int64) (fuse.ReadResult, syscall.Errno) {
+	return (&snapshotHandle{n.content()}).read(dest, off)
+}
+
+var _ = (fs.NodeWriter)((*knobNode)(nil))
+
+func (n *knobNode) Write(ctx context.Context, file fs.FileHandle, data []byte, off int64) (uint32, syscall.Errno) {
+	on, err := strconv.ParseBool(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0, syscall.EINVAL
+	}
+	var v int32
+	if on {
+		v = 1
+	}
+	atomic.StoreInt32(n.value, v)
+	return uint32(len(data)), 0
+}
+
+// policyNode holds the clone policy, which can be replaced while the
+// file system is mounted by writing a new policy to its file.
+type policyNode struct {
+	fs.Inode
+
+	policy *policy.Policy
+	logger *logging.Logger
+}
+
+var _ = (fs.NodeGetattrer)((*policyNode)(nil))
+
+func (n *policyNode) Getattr(ctx context.Context, file fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	out.Mode = fuse.S_IFREG | 0644
+	out.Size = uint64(len(n.policy.String()))
+	t := time.Unix(1, 0)
+	out.SetTimes(nil, &t, nil)
+	return 0
+}
+
+var _ = (fs.NodeSetattrer)((*policyNode)(nil))
+
+func (n *policyNode) Setattr(ctx context.Context, file fs.FileHandle, in *fuse.SetAttrIn, out *fuse.AttrOut) syscall.Errno {
+	if 0 != in.Valid&(fuse.FATTR_MODE|
+		fuse.FATTR_UID|
+		fuse.FATTR_GID) {
+		return syscall.ENOTSUP
+	}
+	return n.Getattr(ctx, file, out)
+}
+
+var _ = (fs.NodeOpener)((*policyNode)(nil))
+
+func (n *policyNode) Open(ctx context.Context, flags uint32) (fs.FileHandle, uint32, syscall.Errno) {
+	return nil, fuse.FOPEN_DIRECT_IO, 0
+}
+
+var _ = (fs.NodeReader)((*policyNode)(nil))
+
+func (n *policyNode) Read(ctx context.Context, file fs.FileHandle, dest []byte, off int64) (fuse.ReadResult, syscall.Errno) {
+	return (&snapshotHandle{[]byte(n.policy.String())}).read(dest, off)
+}
+
+var _ = (fs.NodeWriter)((*policyNode)(nil))
+
+// Write replaces the policy. The new policy must be written in a
+// single write at offset 0; policies are small enough for that.
+func (n *policyNode) Write(ctx context.Context, file fs.FileHandle, data []byte, off int64) (uint32, syscall.Errno) {
+	if off != 0 {
+		return 0, syscall.EINVAL
+	}
+	if err := n.policy.Update(data); err != nil {
+		n.logger.Warningf("clone_policy: %v", err)
+		return 0, syscall.EINVAL
+	}
+	return uint32(len(data)), 0
+}
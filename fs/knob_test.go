@@ -0,0 +1,158 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fs
+
+import (
+	"context"
+	"sync/atomic"
+	"syscall"
+	"testing"
+
+	"github.com/google/slothfs/logging"
+	"github.com/google/slothfs/policy"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+)
+
+func TestKnobNode(t *testing.T) {
+	r := &gitilesRoot{trace: &accessLog{}}
+	n := &knobNode{value: &r.tracing}
+	ctx := context.Background()
+
+	read := func() string {
+		dest := make([]byte, 10)
+		res, errno := n.Read(ctx, nil, dest, 0)
+		if errno != 0 {
+			t.Fatalf("Read: %v", errno)
+		}
+		data, _ := res.Bytes(dest)
+		return string(data)
+	}
+
+	if got := read(); got != "0\n" {
+		t.Errorf("got %q, want 0", got)
+	}
+	if _, errno := n.Write(ctx, nil, []byte("1\n"), 0); errno != 0 {
+		t.Fatalf("Write: %v", errno)
+	}
+	if got := read(); got != "1\n" {
+		t.Errorf("got %q, want 1", got)
+	}
+	if r.tracing != 1 {
+		t.Errorf("tracing not enabled")
+	}
+
+	if _, errno := n.Write(ctx, nil, []byte("maybe"), 0); errno != syscall.EINVAL {
+		t.Errorf("Write(maybe): got %v, want EINVAL", errno)
+	}
+	if _, errno := n.Write(ctx, nil, []byte("false"), 0); errno != 0 {
+		t.Fatalf("Write: %v", errno)
+	}
+	if r.tracing != 0 {
+		t.Errorf("tracing not disabled")
+	}
+}
+
+func TestPolicyNode(t *testing.T) {
+	p, err := policy.Parse([]byte("deny file **\n"))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	r := &gitilesRoot{
+		name:   "platform/build",
+		shaMap: map[plumbing.Hash]string{},
+		opts: GitilesRevisionOptions{
+			GitilesOptions: GitilesOptions{
+				CloneURL: "https://example.com/platform/build",
+				Policy:   p,
+			},
+		},
+		logger:  logging.Default().Sub("fs"),
+		cloning: 1,
+	}
+	id := plumbing.NewHash("0123456789012345678901234567890123456789")
+	r.shaMap[id] = "core/main.mk"
+	file := &gitilesNode{root: r, id: id}
+	file.addLink(r, "core/main.mk", true)
+	if _, clone := file.source(); clone {
+		t.Errorf("policy did not override clone option")
+	}
+
+	n := &policyNode{policy: p, logger: r.logger}
+	ctx := context.Background()
+	if _, errno := n.Write(ctx, nil, []byte("allow file *.mk\n"), 0); errno != 0 {
+		t.Fatalf("Write: %v", errno)
+	}
+	if _, clone := file.source(); !clone {
+		t.Errorf("new policy not applied")
+	}
+
+	if _, errno := n.Write(ctx, nil, []byte("allow nothing\n"), 0); errno != syscall.EINVAL {
+		t.Errorf("Write(invalid): got %v, want EINVAL", errno)
+	}
+	dest := make([]byte, 100)
+	res, errno := n.Read(ctx, nil, dest, 0)
+	if errno != 0 {
+		t.Fatalf("Read: %v", errno)
+	}
+	if data, _ := res.Bytes(dest); string(data) != "allow file *.mk\n" {
+		t.Errorf("got %q after invalid write", data)
+	}
+}
+
+func TestSourceSharedNode(t *testing.T) {
+	a := &gitilesRoot{cloning: 1}
+	b := &gitilesRoot{cloning: 1}
+	n := &gitilesNode{root: a}
+	n.addLink(a, "prebuilts/lib.so", false)
+	n.addLink(b, "lib/lib.so", true)
+	if r, clone := n.source(); r != b || !clone {
+		t.Errorf("got %p, %v, want the cloning root %p", r, clone, b)
+	}
+
+	atomic.StoreInt32(&b.cloning, 0)
+	if r, clone := n.source(); r != a || clone {
+		t.Errorf("got %p, %v with cloning disabled, want the creating root %p", r, clone, a)
+	}
+}
+
+func TestPolicyMatchesLinkPath(t *testing.T) {
+	p, err := policy.Parse([]byte("allow file src/**\ndeny file **\n"))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	r := &gitilesRoot{
+		name:   "platform/build",
+		shaMap: map[plumbing.Hash]string{},
+		opts: GitilesRevisionOptions{
+			GitilesOptions: GitilesOptions{
+				CloneURL: "https://example.com/platform/build",
+				Policy:   p,
+			},
+		},
+		cloning: 1,
+	}
+	id := plumbing.NewHash("0123456789012345678901234567890123456789")
+	n := &gitilesNode{root: r, id: id}
+
+	// The blob is recorded at the last path added, but the rule
+	// must see the path of each link.
+	for _, path := range []string{"src/main.c", "copy/main.c"} {
+		r.shaMap[id] = path
+		n.addLink(r, path, false)
+	}
+	if _, clone := n.source(); !clone {
+		t.Errorf("allow rule for src/main.c not applied")
+	}
+}
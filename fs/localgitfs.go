@@ -0,0 +1,54 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fs
+
+import (
+	"fmt"
+
+	"github.com/google/slothfs/cache"
+	"github.com/hanwen/go-fuse/fs"
+	git "gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+)
+
+// NewLocalGitRoot returns a file system serving the tree of the given
+// revision from the git repository in dir. Blobs are read from the
+// repository and stored in the cache, so this works fully offline.
+// The clone options are ignored, as the repository is already local.
+func NewLocalGitRoot(c *cache.Cache, dir, revision string, options GitilesOptions) (fs.InodeEmbedder, error) {
+	repo, err := git.PlainOpen(dir)
+	if err != nil {
+		return nil, fmt.Errorf("NewLocalGitRoot(%s): %v", dir, err)
+	}
+
+	id, err := repo.ResolveRevision(plumbing.Revision(revision))
+	if err != nil {
+		return nil, fmt.Errorf("NewLocalGitRoot(%s): ResolveRevision(%s): %v", dir, revision, err)
+	}
+
+	tree, err := cache.GetTree(repo, id)
+	if err != nil {
+		return nil, fmt.Errorf("NewLocalGitRoot(%s): GetTree(%s): %v", dir, id, err)
+	}
+
+	options.CloneURL = ""
+	r := NewGitilesRoot(c, tree, nil, GitilesRevisionOptions{
+		Revision:       id.String(),
+		GitilesOptions: options,
+	})
+	r.lazyRepo = cache.NewLocalLazyRepo(repo)
+	r.name = dir
+	return r, nil
+}
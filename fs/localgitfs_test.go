@@ -0,0 +1,70 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fs
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLocalGitRoot(t *testing.T) {
+	fix, err := newTestFixture()
+	if err != nil {
+		t.Fatal("newTestFixture", err)
+	}
+	defer fix.cleanup()
+
+	repoDir := filepath.Join(fix.dir, "repo")
+	cmd := exec.Command("/bin/sh", "-c",
+		strings.Join([]string{
+			"git init -q " + repoDir,
+			"cd " + repoDir,
+			"mkdir dir",
+			"echo hello > dir/file",
+			"ln -s dir/file link",
+			"git add dir/file link",
+			"git -c user.name=test -c user.email=test@example.com commit -q -m msg"}, " && "))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("create repo: %v, out: %s", err, string(out))
+	}
+
+	root, err := NewLocalGitRoot(fix.cache, repoDir, "HEAD", GitilesOptions{})
+	if err != nil {
+		t.Fatalf("NewLocalGitRoot: %v", err)
+	}
+	if err := fix.mount(root); err != nil {
+		t.Fatal("mount", err)
+	}
+
+	if content, err := ioutil.ReadFile(filepath.Join(fix.mntDir, "dir/file")); err != nil {
+		t.Errorf("ReadFile: %v", err)
+	} else if string(content) != "hello\n" {
+		t.Errorf("got %q, want %q", content, "hello\n")
+	}
+	if target, err := os.Readlink(filepath.Join(fix.mntDir, "link")); err != nil || target != "dir/file" {
+		t.Errorf("Readlink: got %q, %v, want %q", target, err, "dir/file")
+	}
+	if _, err := ioutil.ReadFile(filepath.Join(fix.mntDir, ".slothfs/stats.json")); err != nil {
+		t.Errorf("ReadFile(.slothfs/stats.json): %v", err)
+	}
+
+	if _, err := NewLocalGitRoot(fix.cache, repoDir, "no-such-branch", GitilesOptions{}); err == nil {
+		t.Errorf("NewLocalGitRoot for a missing revision succeeded")
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fs
+
+import (
+	"fmt"
+	"path"
+	"sort"
+
+	"github.com/google/slothfs/gitiles"
+	"github.com/google/slothfs/glob"
+)
+
+// BlobInfo describes a file in a workspace.
+type BlobInfo struct {
+	// Path relative to the workspace root.
+	Path string
+	ID   string
+
+	// Size in bytes, or -1 if the tree did not record it.
+	Size int64
+}
+
+// MatchBlobs returns the files in trees, keyed by project path, whose
+// workspace path matches the glob pattern, eg. "**/*.java". Sizes are
+// taken from the tree metadata, so nothing is downloaded. Symlinks
+// and submodules are skipped. The result is sorted by path.
+func MatchBlobs(trees map[string]*gitiles.Tree, pattern string) ([]BlobInfo, error) {
+	pat, err := glob.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("MatchBlobs: %v", err)
+	}
+
+	var result []BlobInfo
+	for dir, tree := range trees {
+		for _, e := range tree.Entries {
+			if e.Type != "blob" || e.Target != nil {
+				continue
+			}
+			p := path.Join(dir, e.Name)
+			if !pat.Match(p) {
+				continue
+			}
+			info := BlobInfo{Path: p, ID: e.ID, Size: -1}
+			if e.Size != nil {
+				info.Size = int64(*e.Size)
+			}
+			result = append(result, info)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Path < result[j].Path })
+	return result, nil
+}
+
+// DistinctBlobs returns the number of distinct blobs in blobs, and
+// their total size. Since the cache stores each blob once, this is
+// what a workspace takes on disk once fully read. Blobs of unknown
+// size count as zero bytes.
+func DistinctBlobs(blobs []BlobInfo) (n int, size int64) {
+	seen := map[string]bool{}
+	for _, b := range blobs {
+		if seen[b.ID] {
+			continue
+		}
+		seen[b.ID] = true
+		if b.Size > 0 {
+			size += b.Size
+		}
+	}
+	return len(seen), size
+}
@@ -0,0 +1,80 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fs
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/google/slothfs/gitiles"
+)
+
+func TestMatchBlobs(t *testing.T) {
+	size := func(n int) *int { return &n }
+	target := "Foo.java"
+	trees := map[string]*gitiles.Tree{
+		"frameworks/base": {
+			Entries: []gitiles.TreeEntry{
+				{Type: "blob", ID: "1", Name: "Foo.java", Size: size(10)},
+				{Type: "blob", ID: "2", Name: "core/java/Bar.java", Size: size(20)},
+				{Type: "blob", ID: "3", Name: "core/java/Bar.kt", Size: size(30)},
+				{Type: "blob", ID: "4", Name: "Link.java", Target: &target},
+				{Type: "commit", ID: "5", Name: "sub.java"},
+			},
+		},
+		"build": {
+			Entries: []gitiles.TreeEntry{
+				{Type: "blob", ID: "6", Name: "Main.java"},
+			},
+		},
+	}
+
+	got, err := MatchBlobs(trees, "**/*.java")
+	if err != nil {
+		t.Fatalf("MatchBlobs: %v", err)
+	}
+	want := []BlobInfo{
+		{Path: "build/Main.java", ID: "6", Size: -1},
+		{Path: "frameworks/base/Foo.java", ID: "1", Size: 10},
+		{Path: "frameworks/base/core/java/Bar.java", ID: "2", Size: 20},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	got, err = MatchBlobs(trees, "frameworks/*/Foo.java")
+	if err != nil {
+		t.Fatalf("MatchBlobs: %v", err)
+	}
+	if len(got) != 1 || got[0].ID != "1" {
+		t.Errorf("got %v, want frameworks/base/Foo.java", got)
+	}
+
+	if _, err := MatchBlobs(trees, "[.java"); err == nil {
+		t.Error("MatchBlobs with bad pattern succeeded")
+	}
+}
+
+func TestDistinctBlobs(t *testing.T) {
+	n, size := DistinctBlobs([]BlobInfo{
+		{Path: "a/Foo.java", ID: "1", Size: 10},
+		{Path: "b/Foo.java", ID: "1", Size: 10},
+		{Path: "b/Bar.java", ID: "2", Size: 20},
+		{Path: "b/Baz.java", ID: "3", Size: -1},
+	})
+	if n != 3 || size != 30 {
+		t.Errorf("got %d blobs, %d bytes, want 3, 30", n, size)
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fs
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/google/slothfs/cache"
+	"github.com/google/slothfs/gitiles"
+	"github.com/google/slothfs/logging"
+)
+
+// metric is a metric in the Prometheus text format.
+type metric struct {
+	name  string
+	typ   string
+	help  string
+	value float64
+
+	// For summaries, value is the count and sum the total.
+	sum float64
+}
+
+// metrics returns the current values of all metrics. The service may
+// be nil. Of the FUSE operations, only opens and reads from the start
+// of a file are counted; there are no counters for other operations,
+// nor a gauge of open file handles, as the handles are go-fuse
+// loopback files that do not report their release.
+func metrics(c *cache.Cache, service *gitiles.Service) []metric {
+	cs := c.Stats()
+	ops := GetOpStats()
+	ms := []metric{
+		{name: "slothfs_cache_tree_hits_total", typ: "counter", help: "Tree lookups served from the cache.", value: float64(cs.TreeHits)},
+		{name: "slothfs_cache_tree_misses_total", typ: "counter", help: "Tree lookups not found in the cache.", value: float64(cs.TreeMisses)},
+		{name: "slothfs_cache_blob_hits_total", typ: "counter", help: "Blob lookups served from the cache.", value: float64(cs.BlobHits)},
+		{name: "slothfs_cache_blob_misses_total", typ: "counter", help: "Blob lookups not found in the cache.", value: float64(cs.BlobMisses)},
+		{name: "slothfs_cache_blob_corrupt_total", typ: "counter", help: "Cached blobs that failed verification and were removed.", value: float64(cs.BlobCorrupt)},
+		{name: "slothfs_fs_opens_total", typ: "counter", help: "Files opened.", value: float64(ops.Opens)},
+		{name: "slothfs_fs_reads_total", typ: "counter", help: "Reads from the start of a file.", value: float64(ops.Reads)},
+		{name: "slothfs_fs_blob_fetch_seconds", typ: "summary", help: "Time spent fetching blobs on demand.", value: float64(ops.Fetches), sum: ops.FetchTime.Seconds()},
+		{name: "slothfs_fs_coalesced_fetches_total", typ: "counter", help: "Opens that waited for a fetch of the same blob in progress.", value: float64(ops.CoalescedFetches)},
+		{name: "slothfs_fs_dedup_opens_total", typ: "counter", help: "First opens of a file whose blob was already cached for another file.", value: float64(ops.DedupOpens)},
+		{name: "slothfs_fs_dedup_bytes_total", typ: "counter", help: "Size of the files counted in slothfs_fs_dedup_opens_total.", value: float64(ops.DedupBytes)},
+	}
+	if service != nil {
+		gs := service.Stats()
+		ms = append(ms,
+			metric{name: "slothfs_gitiles_requests_total", typ: "counter", help: "HTTP requests issued to Gitiles.", value: float64(gs.Requests)},
+			metric{name: "slothfs_gitiles_in_flight", typ: "gauge", help: "Gitiles responses that are not closed yet.", value: float64(gs.InFlight)},
+			metric{name: "slothfs_gitiles_blob_fetches_total", typ: "counter", help: "Blobs fetched from Gitiles.", value: float64(gs.BlobFetches)},
+			metric{name: "slothfs_gitiles_downloaded_bytes_total", typ: "counter", help: "Response bytes read from Gitiles.", value: float64(gs.BytesDownloaded)},
+			metric{name: "slothfs_gitiles_not_modified_total", typ: "counter", help: "Conditional requests answered with 304 Not Modified.", value: float64(gs.NotModified)},
+		)
+	}
+	return ms
+}
+
+// NewMetricsHandler returns an HTTP handler that serves the cache,
+// Gitiles and file system counters in the Prometheus text format.
+// The service may be nil, eg. for local git repositories.
+func NewMetricsHandler(c *cache.Cache, service *gitiles.Service) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var buf bytes.Buffer
+		for _, m := range metrics(c, service) {
+			fmt.Fprintf(&buf, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.typ)
+			if m.typ == "summary" {
+				fmt.Fprintf(&buf, "%s_sum %v\n%s_count %v\n", m.name, m.sum, m.name, m.value)
+			} else {
+				fmt.Fprintf(&buf, "%s %v\n", m.name, m.value)
+			}
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		w.Write(buf.Bytes())
+	})
+}
+
+// ServeMetrics serves NewMetricsHandler at /metrics on addr in the
+// background. It listens before returning, so a bad or busy address
+// is reported before the file system is mounted.
+func ServeMetrics(addr string, c *cache.Cache, service *gitiles.Service) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("ServeMetrics(%s): %v", addr, err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", NewMetricsHandler(c, service))
+	go func() {
+		if err := http.Serve(ln, mux); err != nil {
+			logging.Default().Sub("fs").Errorf("ServeMetrics(%s): %v", addr, err)
+		}
+	}()
+	return nil
+}
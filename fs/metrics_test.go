@@ -0,0 +1,102 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fs
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/google/slothfs/gitiles"
+	"github.com/hanwen/go-fuse/fs"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+)
+
+func TestMetricsHandler(t *testing.T) {
+	c, service, cleanup := newTestService(t, http.NotFound)
+	defer cleanup()
+
+	c.Blob.Open(plumbing.NewHash("787d767f94fd634ed29cd69ec9f93bab2b25f5d4"))
+
+	get := func(service *gitiles.Service) string {
+		w := httptest.NewRecorder()
+		NewMetricsHandler(c, service).ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+		return w.Body.String()
+	}
+
+	got := get(service)
+	for _, want := range []string{
+		"# TYPE slothfs_cache_blob_misses_total counter\nslothfs_cache_blob_misses_total 1\n",
+		"# TYPE slothfs_fs_blob_fetch_seconds summary\nslothfs_fs_blob_fetch_seconds_sum ",
+		"slothfs_gitiles_requests_total 0\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("got %q, want it to contain %q", got, want)
+		}
+	}
+
+	if got := get(nil); strings.Contains(got, "slothfs_gitiles_") {
+		t.Errorf("got Gitiles metrics without a service: %q", got)
+	}
+}
+
+func TestDedupOpens(t *testing.T) {
+	c, service, cleanup := newTestService(t, http.NotFound)
+	defer cleanup()
+
+	content := []byte("hello")
+	id := plumbing.ComputeHash(plumbing.BlobObject, content)
+	if err := c.Blob.Write(id, content); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	root := NewGitilesRoot(c, &gitiles.Tree{}, service.NewRepoService("platform/build"), GitilesRevisionOptions{Revision: "master"})
+	n := &gitilesNode{root: root, id: id, size: int64(len(content))}
+	before := GetOpStats()
+	for i := 0; i < 2; i++ {
+		h, _, errno := n.Open(context.Background(), syscall.O_RDONLY)
+		if errno != 0 {
+			t.Fatalf("Open: %v", errno)
+		}
+		if r, ok := h.(fs.FileReleaser); ok {
+			r.Release(context.Background())
+		}
+	}
+	after := GetOpStats()
+	if got := after.DedupOpens - before.DedupOpens; got != 1 {
+		t.Errorf("got %d dedup opens, want 1", got)
+	}
+	if got := after.DedupBytes - before.DedupBytes; got != int64(len(content)) {
+		t.Errorf("got %d dedup bytes, want %d", got, len(content))
+	}
+}
+
+func TestServeMetricsBusyAddress(t *testing.T) {
+	c, _, cleanup := newTestService(t, http.NotFound)
+	defer cleanup()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer ln.Close()
+	if err := ServeMetrics(ln.Addr().String(), c, nil); err == nil {
+		t.Errorf("ServeMetrics on a busy address succeeded")
+	}
+}
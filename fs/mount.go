@@ -0,0 +1,67 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fs
+
+import (
+	"flag"
+	"time"
+
+	"github.com/hanwen/go-fuse/fs"
+)
+
+// KernelCacheOptions sets how long the kernel may cache name lookups,
+// file attributes and lookups of names that do not exist. Caching
+// lets the kernel answer READDIRPLUS and repeated stat calls, eg.
+// from ls -lR or from builds probing for missing headers, without
+// asking the file system.
+type KernelCacheOptions struct {
+	EntryTimeout    time.Duration
+	AttrTimeout     time.Duration
+	NegativeTimeout time.Duration
+}
+
+// DefaultKernelCacheOptions caches everything for an hour, as the
+// content never changes.
+var DefaultKernelCacheOptions = KernelCacheOptions{
+	EntryTimeout:    time.Hour,
+	AttrTimeout:     time.Hour,
+	NegativeTimeout: time.Hour,
+}
+
+var defaultKernelCacheOptions KernelCacheOptions
+
+// DefineKernelCacheFlags sets up command line flags for the kernel cache
+// timeouts, and returns the options struct in which the values are
+// put.
+func DefineKernelCacheFlags() *KernelCacheOptions {
+	flag.DurationVar(&defaultKernelCacheOptions.EntryTimeout, "entry_timeout", DefaultKernelCacheOptions.EntryTimeout, "Set how long the kernel caches name lookups.")
+	flag.DurationVar(&defaultKernelCacheOptions.AttrTimeout, "attr_timeout", DefaultKernelCacheOptions.AttrTimeout, "Set how long the kernel caches file attributes.")
+	flag.DurationVar(&defaultKernelCacheOptions.NegativeTimeout, "negative_timeout", DefaultKernelCacheOptions.NegativeTimeout, "Set how long the kernel caches lookups of nonexistent names.")
+	return &defaultKernelCacheOptions
+}
+
+// MountOptions returns the options for mounting a slothfs file
+// system with the given kernel cache timeouts. Where the platform
+// supports it, name is shown as the volume name.
+func MountOptions(name string, debug bool, kernelCache KernelCacheOptions) *fs.Options {
+	opts := &fs.Options{
+		EntryTimeout:    &kernelCache.EntryTimeout,
+		NegativeTimeout: &kernelCache.NegativeTimeout,
+		AttrTimeout:     &kernelCache.AttrTimeout,
+	}
+	opts.Debug = debug
+	opts.Options = append(opts.Options, platformMountOptions(name)...)
+	return opts
+}
@@ -0,0 +1,40 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fs
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+// platformMountOptions returns the osxfuse options for a read-only
+// tree: Finder should not write ._ AppleDouble files or com.apple
+// extended attributes, which would only fail.
+func platformMountOptions(name string) []string {
+	return []string{
+		"volname=" + name,
+		"noappledouble",
+		"noapplexattr",
+	}
+}
+
+// lazyUnmount unmounts dir even if files in it are still open.
+func lazyUnmount(dir string) error {
+	out, err := exec.Command("umount", "-f", dir).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("umount: %v: %s", err, out)
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !darwin
+
+package fs
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+// platformMountOptions returns extra mount options for the platform.
+func platformMountOptions(name string) []string {
+	return nil
+}
+
+// lazyUnmount detaches dir right away, and finishes unmounting it once
+// files in it are no longer in use.
+func lazyUnmount(dir string) error {
+	out, err := exec.Command("fusermount", "-u", "-z", dir).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("fusermount: %v: %s", err, out)
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fs
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMountOptions(t *testing.T) {
+	opts := MountOptions("build", true, KernelCacheOptions{
+		EntryTimeout:    time.Hour,
+		AttrTimeout:     time.Minute,
+		NegativeTimeout: time.Second,
+	})
+	if *opts.EntryTimeout != time.Hour || *opts.AttrTimeout != time.Minute || *opts.NegativeTimeout != time.Second || !opts.Debug {
+		t.Errorf("got %+v", opts)
+	}
+	got := strings.Join(opts.Options, ",")
+	if runtime.GOOS == "darwin" {
+		if want := "volname=build,noappledouble,noapplexattr"; got != want {
+			t.Errorf("got options %q, want %q", got, want)
+		}
+	} else if got != "" {
+		t.Errorf("got options %q, want none", got)
+	}
+}
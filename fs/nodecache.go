@@ -22,7 +22,7 @@ import (
 
 type nodeCacheKey struct {
 	ID   plumbing.Hash
-	xbit bool
+	mode uint32
 }
 
 // The nodeCache keeps a map of ID to FS node. It is safe for
@@ -33,6 +33,12 @@ type nodeCacheKey struct {
 // used in multiple checkouts. Second, moving data from the FUSE
 // process into the kernel is relatively expensive. Thus, we can
 // amortize the cost of the read over multiple checkouts.
+//
+// Nodes are keyed by blob ID and git mode, so executables and
+// symlinks never share a node with a regular file that happens to
+// have the same content. A single nodeCache may be shared between
+// several gitilesRoots; the node keeps pointing to the root that
+// created it, which is used for fetching the content.
 type nodeCache struct {
 	mu      sync.RWMutex
 	nodeMap map[nodeCacheKey]*gitilesNode
@@ -44,17 +50,24 @@ func newNodeCache() *nodeCache {
 	}
 }
 
-func (c *nodeCache) get(id *plumbing.Hash, xbit bool) *gitilesNode {
+func (c *nodeCache) get(id *plumbing.Hash, mode uint32) *gitilesNode {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
-	return c.nodeMap[nodeCacheKey{*id, xbit}]
+	return c.nodeMap[nodeCacheKey{*id, mode}]
 }
 
-func (c *nodeCache) add(n *gitilesNode) {
-	xbit := n.mode&0111 != 0
+// add inserts n, unless there already is a node for the same blob and
+// mode, which can happen if two roots race to create it. It returns
+// the node that is in the cache.
+func (c *nodeCache) add(n *gitilesNode) *gitilesNode {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	c.nodeMap[nodeCacheKey{n.id, xbit}] = n
+	key := nodeCacheKey{n.id, n.mode}
+	if old, ok := c.nodeMap[key]; ok {
+		return old
+	}
+	c.nodeMap[key] = n
+	return n
 }
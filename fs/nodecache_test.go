@@ -0,0 +1,66 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fs
+
+import (
+	"sync"
+	"testing"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+)
+
+// TestNodeCacheConcurrent exercises the nodeCache from many
+// goroutines; run it with -race.
+func TestNodeCacheConcurrent(t *testing.T) {
+	c := newNodeCache()
+	const ids = 50
+
+	var wg sync.WaitGroup
+	winners := make([][]*gitilesNode, 8)
+	for g := range winners {
+		winners[g] = make([]*gitilesNode, ids)
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < ids; i++ {
+				id := plumbing.Hash{byte(i)}
+				n := c.get(&id, 0100644)
+				if n == nil {
+					n = c.add(&gitilesNode{id: id, mode: 0100644})
+				}
+				winners[g][i] = n
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	for i := 0; i < ids; i++ {
+		id := plumbing.Hash{byte(i)}
+		want := c.get(&id, 0100644)
+		if want == nil {
+			t.Fatalf("node %d missing", i)
+		}
+		for g := range winners {
+			if winners[g][i] != want {
+				t.Errorf("goroutine %d got a different node for %d", g, i)
+			}
+		}
+	}
+
+	id := plumbing.Hash{1}
+	if c.get(&id, 0100755) != nil {
+		t.Errorf("node shared across modes")
+	}
+}
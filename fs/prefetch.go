@@ -0,0 +1,138 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fs
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/google/slothfs/cache"
+	"github.com/google/slothfs/gitiles"
+	"github.com/google/slothfs/manifest"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+)
+
+// prefetchParallelism bounds the number of concurrent blob fetches
+// in Prefetch.
+const prefetchParallelism = 8
+
+// prefetchJob is a blob to fetch for Prefetch.
+type prefetchJob struct {
+	service *gitiles.RepoService
+	rev     string
+	file    string
+	id      plumbing.Hash
+}
+
+// Prefetch fetches the blobs for the given workspace paths into the
+// cache, eg. the files read by a previous build, so a cold mount does
+// not fetch them one at a time. Paths that are not files of the
+// manifest and blobs that are already cached are skipped. The
+// services are selected as in FetchTrees. It returns the number of
+// blobs fetched; all blobs that could not be fetched are reported in
+// a single error.
+func Prefetch(c *cache.Cache, service *gitiles.Service, remotes map[string]*gitiles.Service, mf *manifest.Manifest, paths []string) (int, error) {
+	trees, err := FetchTrees(c, service, remotes, mf)
+	if err != nil {
+		return 0, err
+	}
+	projects := map[string]*manifest.Project{}
+	for i, p := range mf.Project {
+		projects[p.GetPath()] = &mf.Project[i]
+	}
+
+	// entries indexes the blobs of each tree by name on first use.
+	entries := map[string]map[string]*gitiles.TreeEntry{}
+	var jobs []prefetchJob
+	seen := map[plumbing.Hash]bool{}
+	for _, fn := range paths {
+		fn = strings.Trim(path.Clean("/"+fn), "/")
+		var p *manifest.Project
+		dir := fn
+		for p == nil && dir != "." && dir != "" {
+			dir = path.Dir(dir)
+			p = projects[dir]
+		}
+		if p == nil {
+			continue
+		}
+
+		byName, ok := entries[dir]
+		if !ok {
+			byName = map[string]*gitiles.TreeEntry{}
+			tree := trees[dir]
+			for i, e := range tree.Entries {
+				if e.Type == "blob" {
+					byName[e.Name] = &tree.Entries[i]
+				}
+			}
+			entries[dir] = byName
+		}
+
+		e := byName[strings.TrimPrefix(fn, dir+"/")]
+		if e == nil {
+			continue
+		}
+		id := plumbing.NewHash(e.ID)
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		if f, ok := c.Blob.Open(id); ok {
+			f.Close()
+			continue
+		}
+		jobs = append(jobs, prefetchJob{
+			service: projectService(mf, p, service, remotes).NewRepoService(p.Name),
+			rev:     mf.ProjectRevision(p),
+			file:    e.Name,
+			id:      id,
+		})
+	}
+
+	var mu sync.Mutex
+	var msgs []string
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, prefetchParallelism)
+	for _, j := range jobs {
+		j := j
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			content, err := j.service.GetBlob(j.rev, j.file)
+			if err == nil {
+				err = c.Blob.Write(j.id, content)
+			}
+			if err != nil {
+				mu.Lock()
+				msgs = append(msgs, fmt.Sprintf("project %s: %s: %v", j.service.Name, j.file, err))
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(msgs) > 0 {
+		sort.Strings(msgs)
+		return len(jobs) - len(msgs), fmt.Errorf("%d blobs could not be fetched:\n%s", len(msgs), strings.Join(msgs, "\n"))
+	}
+	return len(jobs), nil
+}
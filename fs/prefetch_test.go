@@ -0,0 +1,93 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fs
+
+import (
+	"encoding/base64"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/google/slothfs/manifest"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+)
+
+func TestPrefetch(t *testing.T) {
+	const rev = "ce34badf691d36e8048b63f89d1a86ee5fa4325c"
+	blobs := map[string]string{
+		"core/root.mk": "root",
+		"core/main.mk": "main",
+		"README":       "readme",
+	}
+	var mu sync.Mutex
+	fetched := map[string]int{}
+
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/platform/build/+/" + rev + "/":
+			w.Write([]byte(`)]}'
+{"id": "58d9fdae2c26d82e04f3fcafc4358b99109f0e70", "entries": [`))
+			sep := ""
+			for name, content := range blobs {
+				id := plumbing.ComputeHash(plumbing.BlobObject, []byte(content))
+				w.Write([]byte(sep + `{"mode": 33188, "type": "blob", "id": "` + id.String() + `", "name": "` + name + `"}`))
+				sep = ","
+			}
+			w.Write([]byte(`]}`))
+			return
+		}
+		for name, content := range blobs {
+			if r.URL.Path == "/platform/build/+show/"+rev+"/"+name {
+				mu.Lock()
+				fetched[name]++
+				mu.Unlock()
+				w.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+				w.Write([]byte(base64.StdEncoding.EncodeToString([]byte(content))))
+				return
+			}
+		}
+		http.NotFound(w, r)
+	}
+	c, service, cleanup := newTestService(t, handler)
+	defer cleanup()
+	buildPath := "build"
+	mf := &manifest.Manifest{
+		Project: []manifest.Project{{Name: "platform/build", Path: &buildPath, Revision: rev}},
+	}
+
+	paths := []string{"build/core/root.mk", "/build/core/main.mk", "build/core/root.mk", "build/missing", "art/README", "Makefile"}
+	n, err := Prefetch(c, service, nil, mf, paths)
+	if err != nil {
+		t.Fatalf("Prefetch: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("got %d blobs fetched, want 2", n)
+	}
+	if fetched["core/root.mk"] != 1 || fetched["core/main.mk"] != 1 || fetched["README"] != 0 {
+		t.Errorf("got fetches %v, want one each of core/root.mk and core/main.mk", fetched)
+	}
+	for _, name := range []string{"core/root.mk", "core/main.mk"} {
+		id := plumbing.ComputeHash(plumbing.BlobObject, []byte(blobs[name]))
+		if f, ok := c.Blob.Open(id); !ok {
+			t.Errorf("blob for %s not cached", name)
+		} else {
+			f.Close()
+		}
+	}
+
+	if n, err := Prefetch(c, service, nil, mf, paths); err != nil || n != 0 {
+		t.Errorf("second Prefetch: got %d, %v, want 0 blobs", n, err)
+	}
+}
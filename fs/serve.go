@@ -0,0 +1,153 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fs
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"syscall"
+
+	"github.com/google/slothfs/logging"
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+// ServeOptions configures how a FUSE daemon runs, so init systems can
+// manage it.
+type ServeOptions struct {
+	// If set, Daemonize runs the process in the background.
+	Daemon bool
+
+	// If set, the daemon's output goes to this file rather than
+	// being discarded.
+	LogFile string
+
+	// If set, Serve writes the process ID to this file, and removes
+	// it on exit.
+	PIDFile string
+}
+
+var defaultServeOptions ServeOptions
+
+// DefineServeFlags sets up command line flags for running a daemon,
+// and returns the options struct in which the values are put.
+func DefineServeFlags() *ServeOptions {
+	flag.BoolVar(&defaultServeOptions.Daemon, "daemon", false, "Run in the background once the file system is mounted.")
+	flag.StringVar(&defaultServeOptions.LogFile, "daemon_log", "", "Append the output of -daemon to this file. By default, it is discarded.")
+	flag.StringVar(&defaultServeOptions.PIDFile, "pid_file", "", "Write the process ID to this file while serving.")
+	return &defaultServeOptions
+}
+
+// daemonEnv is set in the environment of the background process.
+const daemonEnv = "SLOTHFS_DAEMON"
+
+// readyFD is the file descriptor on which the background process
+// reports that the file system is mounted.
+const readyFD = 3
+
+// Daemonize starts a copy of the process in a new session if
+// opts.Daemon is set, and exits once the copy is serving the mount, or
+// with the copy's exit status if it fails before that. In the copy,
+// and if opts.Daemon is unset, it returns nil right away. It should be
+// called right after parsing flags.
+func Daemonize(opts ServeOptions) error {
+	if !opts.Daemon || os.Getenv(daemonEnv) != "" {
+		return nil
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Env = append(os.Environ(), daemonEnv+"=1")
+	cmd.ExtraFiles = []*os.File{w}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if opts.LogFile != "" {
+		f, err := os.OpenFile(opts.LogFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		cmd.Stdout = f
+		cmd.Stderr = f
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("Daemonize: %v", err)
+	}
+	w.Close()
+
+	// The copy writes a byte once mounted. If it exits before that,
+	// we read EOF instead.
+	if n, _ := r.Read(make([]byte, 1)); n == 1 {
+		os.Exit(0)
+	}
+	err = cmd.Wait()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+			os.Exit(status.ExitStatus())
+		}
+	}
+	return fmt.Errorf("Daemonize: %s exited before mounting: %v", exe, err)
+}
+
+// Serve serves the file system mounted on dir until it is unmounted.
+// On SIGTERM or SIGINT, it unmounts dir, lazily if files are still
+// open. Before returning, it flushes file system buffers, so blobs
+// just written to the cache reach the disk, and removes the pid file.
+func Serve(server *fuse.Server, dir string, opts ServeOptions) error {
+	logger := logging.Default().Sub("fs")
+	if opts.PIDFile != "" {
+		if err := ioutil.WriteFile(opts.PIDFile, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644); err != nil {
+			return err
+		}
+		defer os.Remove(opts.PIDFile)
+	}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGTERM, syscall.SIGINT)
+	defer signal.Stop(sigs)
+	go func() {
+		for sig := range sigs {
+			logger.Infof("got %v, unmounting %s", sig, dir)
+			if err := server.Unmount(); err != nil {
+				logger.Warningf("Unmount(%s): %v; unmounting lazily", dir, err)
+				if err := lazyUnmount(dir); err != nil {
+					logger.Errorf("lazyUnmount(%s): %v", dir, err)
+				}
+			}
+		}
+	}()
+
+	if os.Getenv(daemonEnv) != "" {
+		// Tell Daemonize that we are mounted.
+		ready := os.NewFile(readyFD, "ready")
+		ready.Write([]byte{1})
+		ready.Close()
+	}
+
+	server.Serve()
+	syscall.Sync()
+	return nil
+}
@@ -0,0 +1,148 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fs
+
+import (
+	"context"
+	"encoding/json"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/google/slothfs/cache"
+	"github.com/google/slothfs/gitiles"
+	"github.com/google/slothfs/logging"
+	"github.com/hanwen/go-fuse/fs"
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+// Stats is the content of .slothfs/stats.json.
+type Stats struct {
+	Cache   cache.Stats
+	Gitiles gitiles.Stats
+	Ops     OpStats
+}
+
+// OpStats holds counters for file system operations of all roots in
+// the process.
+type OpStats struct {
+	// Opens and Reads count open calls and reads from the start
+	// of a file.
+	Opens int64
+	Reads int64
+
+	// Fetches is the number of blobs fetched because they were not
+	// in the cache, and FetchTime is the total time spent on them.
+	Fetches   int64
+	FetchTime time.Duration
+
+	// CoalescedFetches counts opens that waited for a fetch of the
+	// same blob that was already in progress, instead of fetching.
+	CoalescedFetches int64
+
+	// DedupOpens counts first opens of a file whose blob was already
+	// cached for another file with the same content, eg. in another
+	// workspace or revision, and DedupBytes their total size.
+	DedupOpens int64
+	DedupBytes int64
+}
+
+// opStats holds the process-wide OpStats.
+var opStats OpStats
+
+// GetOpStats returns a snapshot of the operation counters. It is safe
+// for concurrent use.
+func GetOpStats() OpStats {
+	return OpStats{
+		Opens:     atomic.LoadInt64(&opStats.Opens),
+		Reads:     atomic.LoadInt64(&opStats.Reads),
+		Fetches:   atomic.LoadInt64(&opStats.Fetches),
+		FetchTime: time.Duration(atomic.LoadInt64((*int64)(&opStats.FetchTime))),
+
+		CoalescedFetches: atomic.LoadInt64(&opStats.CoalescedFetches),
+		DedupOpens:       atomic.LoadInt64(&opStats.DedupOpens),
+		DedupBytes:       atomic.LoadInt64(&opStats.DedupBytes),
+	}
+}
+
+// statsNode serves Stats as JSON. The content is generated on each
+// open, so it is served with direct I/O.
+type statsNode struct {
+	fs.Inode
+
+	cache *cache.Cache
+
+	// service is nil for a local repository.
+	service *gitiles.RepoService
+	logger  *logging.Logger
+}
+
+// snapshotHandle holds the content generated for an open file.
+type snapshotHandle struct {
+	data []byte
+}
+
+// read serves a read from the snapshot.
+func (h *snapshotHandle) read(dest []byte, off int64) (fuse.ReadResult, syscall.Errno) {
+	if off >= int64(len(h.data)) {
+		return fuse.ReadResultData(nil), 0
+	}
+	end := off + int64(len(dest))
+	if end > int64(len(h.data)) {
+		end = int64(len(h.data))
+	}
+	return fuse.ReadResultData(h.data[off:end]), 0
+}
+
+var _ = (fs.NodeGetattrer)((*statsNode)(nil))
+
+func (n *statsNode) Getattr(ctx context.Context, file fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	out.Mode = fuse.S_IFREG | 0444
+	if h, ok := file.(*snapshotHandle); ok {
+		out.Size = uint64(len(h.data))
+	}
+	t := time.Unix(1, 0)
+	out.SetTimes(nil, &t, nil)
+	return 0
+}
+
+var _ = (fs.NodeOpener)((*statsNode)(nil))
+
+func (n *statsNode) Open(ctx context.Context, flags uint32) (fs.FileHandle, uint32, syscall.Errno) {
+	if flags&syscall.O_ACCMODE != syscall.O_RDONLY {
+		return nil, 0, syscall.EPERM
+	}
+
+	stats := Stats{Cache: n.cache.Stats(), Ops: GetOpStats()}
+	if n.service != nil {
+		stats.Gitiles = n.service.Stats()
+	}
+	data, err := json.MarshalIndent(stats, "", " ")
+	if err != nil {
+		n.logger.Errorf("json.Marshal: %v", err)
+		return nil, 0, syscall.EIO
+	}
+	return &snapshotHandle{data}, fuse.FOPEN_DIRECT_IO, 0
+}
+
+var _ = (fs.NodeReader)((*statsNode)(nil))
+
+func (n *statsNode) Read(ctx context.Context, file fs.FileHandle, dest []byte, off int64) (fuse.ReadResult, syscall.Errno) {
+	h, ok := file.(*snapshotHandle)
+	if !ok {
+		return nil, syscall.EBADF
+	}
+	return h.read(dest, off)
+}
@@ -0,0 +1,196 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fs
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/google/slothfs/gitiles"
+	"github.com/hanwen/go-fuse/fs"
+)
+
+// Submodule describes an entry of a .gitmodules file.
+type Submodule struct {
+	Name string
+	Path string
+	URL  string
+}
+
+// ParseSubmodules parses the content of a .gitmodules file. Entries
+// without a path or URL are skipped.
+func ParseSubmodules(content []byte) ([]Submodule, error) {
+	var result []Submodule
+	var cur *Submodule
+	flush := func() {
+		if cur != nil && cur.Path != "" && cur.URL != "" {
+			result = append(result, *cur)
+		}
+		cur = nil
+	}
+
+	sc := bufio.NewScanner(bytes.NewReader(content))
+	for lineno := 1; sc.Scan(); lineno++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if line[0] == '[' {
+			flush()
+			section := strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(line, "["), "]"))
+			if !strings.HasPrefix(section, "submodule ") {
+				continue
+			}
+			name, err := strconv.Unquote(strings.TrimSpace(strings.TrimPrefix(section, "submodule ")))
+			if err != nil {
+				return nil, fmt.Errorf("ParseSubmodules: line %d: bad section %q", lineno, line)
+			}
+			cur = &Submodule{Name: name}
+			continue
+		}
+		if cur == nil {
+			continue
+		}
+
+		eq := strings.Index(line, "=")
+		if eq < 0 {
+			return nil, fmt.Errorf("ParseSubmodules: line %d: missing '=' in %q", lineno, line)
+		}
+		key := strings.ToLower(strings.TrimSpace(line[:eq]))
+		val := strings.TrimSpace(line[eq+1:])
+		if uq, err := strconv.Unquote(val); err == nil {
+			val = uq
+		}
+		switch key {
+		case "path":
+			cur.Path = strings.Trim(path.Clean(val), "/")
+		case "url":
+			cur.URL = val
+		}
+	}
+	flush()
+	return result, sc.Err()
+}
+
+// submoduleRepo returns the name of the repository a submodule URL
+// points to on the Gitiles server at addr. Relative URLs are relative
+// to the repository parent.
+func submoduleRepo(addr *url.URL, parent, subURL string) (string, error) {
+	if strings.HasPrefix(subURL, "./") || strings.HasPrefix(subURL, "../") {
+		return path.Join(parent, subURL), nil
+	}
+
+	u, err := url.Parse(subURL)
+	if err != nil {
+		return "", err
+	}
+	if u.Host != addr.Host {
+		return "", fmt.Errorf("URL %s is not on %s", subURL, addr.Host)
+	}
+	name := strings.TrimPrefix(u.Path, strings.TrimSuffix(addr.Path, "/"))
+	return strings.TrimSuffix(strings.Trim(name, "/"), ".git"), nil
+}
+
+// mountSubmodules adds the given submodule entries. Submodules listed
+// in .gitmodules that are on the same Gitiles server are mounted as
+// nested roots; the others are left as empty directories, as git
+// does for submodules that are not checked out.
+func (r *gitilesRoot) mountSubmodules(ctx context.Context, entries []gitiles.TreeEntry, gitmodules *gitiles.TreeEntry) {
+	urls := map[string]string{}
+	if gitmodules != nil && r.service != nil {
+		if subs, err := r.readSubmodules(ctx, gitmodules); err != nil {
+			r.logger.Warningf("%s: %v", r.name, err)
+		} else {
+			for _, s := range subs {
+				urls[s.Path] = s.URL
+			}
+		}
+	}
+
+	for _, e := range entries {
+		subURL, ok := urls[e.Name]
+		if !ok {
+			r.pathTo(e.Name)
+			continue
+		}
+		root, err := r.newSubmoduleRoot(subURL, e.ID)
+		if err != nil {
+			r.logger.Warningf("%s: submodule %s: %v", r.name, e.Name, err)
+			r.pathTo(e.Name)
+			continue
+		}
+
+		dir, base := filepath.Split(e.Name)
+		ch := r.NewPersistentInode(ctx, root, fs.StableAttr{Mode: syscall.S_IFDIR})
+		r.pathTo(dir).AddChild(base, ch, true)
+	}
+}
+
+// readSubmodules reads and parses the .gitmodules file.
+func (r *gitilesRoot) readSubmodules(ctx context.Context, e *gitiles.TreeEntry) ([]Submodule, error) {
+	id, err := parseID(e.ID)
+	if err != nil {
+		return nil, err
+	}
+	r.shaMap[*id] = e.Name
+	f, _, err := r.openFile(ctx, *id, false)
+	if err != nil {
+		return nil, fmt.Errorf("open(%s): %v", e.Name, err)
+	}
+	defer f.Close()
+
+	content, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	return ParseSubmodules(content)
+}
+
+// newSubmoduleRoot returns a root for the submodule at the given
+// URL and commit.
+func (r *gitilesRoot) newSubmoduleRoot(subURL, commit string) (*gitilesRoot, error) {
+	service := r.service.Service()
+	addr, err := url.Parse(service.Addr())
+	if err != nil {
+		return nil, err
+	}
+	name, err := submoduleRepo(addr, r.service.Name, subURL)
+	if err != nil {
+		return nil, err
+	}
+
+	tree, err := getTree(r.cache, service, name, commit)
+	if err != nil {
+		return nil, fmt.Errorf("GetTree(%s, %s): %v", name, commit, err)
+	}
+
+	opts := r.opts.GitilesOptions
+	opts.CloneURL = ""
+	sub := NewGitilesRoot(r.cache, tree, service.NewRepoService(name), GitilesRevisionOptions{
+		Revision:       commit,
+		GitilesOptions: opts,
+	})
+	sub.nodeCache = r.nodeCache
+	return sub, nil
+}
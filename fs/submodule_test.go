@@ -0,0 +1,99 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fs
+
+import (
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/google/slothfs/gitiles"
+)
+
+func TestParseSubmodules(t *testing.T) {
+	got, err := ParseSubmodules([]byte(`# comment
+[submodule "lib"]
+	path = third_party/lib
+	url = ../lib
+[core]
+	bare = false
+[submodule "docs"]
+	path = "docs/"
+	URL = https://host/docs.git
+[submodule "incomplete"]
+	path = nowhere
+`))
+	if err != nil {
+		t.Fatalf("ParseSubmodules: %v", err)
+	}
+	want := []Submodule{
+		{Name: "lib", Path: "third_party/lib", URL: "../lib"},
+		{Name: "docs", Path: "docs", URL: "https://host/docs.git"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if _, err := ParseSubmodules([]byte("[submodule \"x\"]\n\tpath\n")); err == nil {
+		t.Errorf("ParseSubmodules with malformed line succeeded")
+	}
+}
+
+func TestSubmoduleRepo(t *testing.T) {
+	addr, _ := url.Parse("https://host/gitiles/")
+	for _, tc := range []struct {
+		url, want string
+	}{
+		{"../lib", "platform/lib"},
+		{"./sub", "platform/build/sub"},
+		{"https://host/gitiles/tools/repo.git", "tools/repo"},
+	} {
+		got, err := submoduleRepo(addr, "platform/build", tc.url)
+		if err != nil || got != tc.want {
+			t.Errorf("submoduleRepo(%q): got %q, %v, want %q", tc.url, got, err, tc.want)
+		}
+	}
+	if _, err := submoduleRepo(addr, "platform/build", "https://elsewhere/lib"); err == nil {
+		t.Errorf("submoduleRepo on another host succeeded")
+	}
+}
+
+func TestNewSubmoduleRoot(t *testing.T) {
+	const commit = "ce34badf691d36e8048b63f89d1a86ee5fa4325c"
+	c, service, cleanup := newTestService(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/platform/lib/+/"+commit+"/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`)]}'
+{"id": "58d9fdae2c26d82e04f3fcafc4358b99109f0e70", "entries": []}`))
+	})
+	defer cleanup()
+
+	root := NewGitilesRoot(c, &gitiles.Tree{}, service.NewRepoService("platform/build"), GitilesRevisionOptions{})
+	sub, err := root.newSubmoduleRoot("../lib", commit)
+	if err != nil {
+		t.Fatalf("newSubmoduleRoot: %v", err)
+	}
+	if sub.service.Name != "platform/lib" || sub.opts.Revision != commit || sub.nodeCache != root.nodeCache {
+		t.Errorf("got submodule root for %s at %s", sub.service.Name, sub.opts.Revision)
+	}
+
+	// Trees are cached by commit, so use a different one.
+	if _, err := root.newSubmoduleRoot("../missing", "1111111111111111111111111111111111111111"); err == nil {
+		t.Errorf("newSubmoduleRoot for a missing repository succeeded")
+	}
+}
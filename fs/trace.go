@@ -0,0 +1,112 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/fs"
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+// maxAccessLogSize bounds the memory used for access tracing. Once
+// the log reaches this size, further accesses are not recorded.
+const maxAccessLogSize = 16 << 20
+
+// accessRecord is a line of .slothfs/access.log.
+type accessRecord struct {
+	Time time.Time
+	Op   string
+	Path string
+}
+
+// accessLog records file accesses as JSON lines.
+type accessLog struct {
+	mu   sync.Mutex
+	buf  bytes.Buffer
+	full bool
+}
+
+// add records an access to the given path.
+func (l *accessLog) add(op, path string) {
+	data, err := json.Marshal(accessRecord{
+		Time: time.Now(),
+		Op:   op,
+		Path: path,
+	})
+	if err != nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.full || l.buf.Len()+len(data)+1 > maxAccessLogSize {
+		l.full = true
+		return
+	}
+	l.buf.Write(data)
+	l.buf.WriteByte('\n')
+}
+
+// snapshot returns a copy of the log so far.
+func (l *accessLog) snapshot() []byte {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]byte(nil), l.buf.Bytes()...)
+}
+
+// accessLogNode serves the access log. Like statsNode, its content
+// is generated on each open.
+type accessLogNode struct {
+	fs.Inode
+
+	log *accessLog
+}
+
+var _ = (fs.NodeGetattrer)((*accessLogNode)(nil))
+
+func (n *accessLogNode) Getattr(ctx context.Context, file fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	out.Mode = fuse.S_IFREG | 0444
+	if h, ok := file.(*snapshotHandle); ok {
+		out.Size = uint64(len(h.data))
+	}
+	t := time.Unix(1, 0)
+	out.SetTimes(nil, &t, nil)
+	return 0
+}
+
+var _ = (fs.NodeOpener)((*accessLogNode)(nil))
+
+func (n *accessLogNode) Open(ctx context.Context, flags uint32) (fs.FileHandle, uint32, syscall.Errno) {
+	if flags&syscall.O_ACCMODE != syscall.O_RDONLY {
+		return nil, 0, syscall.EPERM
+	}
+	return &snapshotHandle{n.log.snapshot()}, fuse.FOPEN_DIRECT_IO, 0
+}
+
+var _ = (fs.NodeReader)((*accessLogNode)(nil))
+
+func (n *accessLogNode) Read(ctx context.Context, file fs.FileHandle, dest []byte, off int64) (fuse.ReadResult, syscall.Errno) {
+	h, ok := file.(*snapshotHandle)
+	if !ok {
+		return nil, syscall.EBADF
+	}
+	return h.read(dest, off)
+}
@@ -0,0 +1,82 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fs
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestAccessLog(t *testing.T) {
+	l := &accessLog{}
+	l.add("open", "dir/file")
+	l.add("read", "dir/file")
+
+	var got []string
+	sc := bufio.NewScanner(bytes.NewReader(l.snapshot()))
+	for sc.Scan() {
+		var rec accessRecord
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			t.Fatalf("Unmarshal(%q): %v", sc.Text(), err)
+		}
+		if rec.Time.IsZero() {
+			t.Errorf("record %q has no time", sc.Text())
+		}
+		got = append(got, rec.Op+" "+rec.Path)
+	}
+	if want := "open dir/file,read dir/file"; strings.Join(got, ",") != want {
+		t.Errorf("got %v, want %s", got, want)
+	}
+}
+
+func TestAccessLogBounded(t *testing.T) {
+	l := &accessLog{}
+	long := strings.Repeat("x", 1<<20)
+	for i := 0; i < 20; i++ {
+		l.add("read", long)
+	}
+	size := len(l.snapshot())
+	if size > maxAccessLogSize {
+		t.Errorf("log size %d exceeds %d", size, maxAccessLogSize)
+	}
+
+	l.add("read", "short")
+	if got := len(l.snapshot()); got != size {
+		t.Errorf("log grew from %d to %d after filling up", size, got)
+	}
+}
+
+func TestTraceAccessSharedNode(t *testing.T) {
+	a := &gitilesRoot{trace: &accessLog{}}
+	b := &gitilesRoot{trace: &accessLog{}, tracing: 1}
+	n := &gitilesNode{}
+	n.addLink(a, "core/main.mk", false)
+	n.addLink(b, "build/core/main.mk", false)
+
+	n.traceAccess("open")
+	if got := len(a.trace.snapshot()); got != 0 {
+		t.Errorf("root without tracing got %d bytes of log", got)
+	}
+	var rec accessRecord
+	if err := json.Unmarshal(bytes.TrimSpace(b.trace.snapshot()), &rec); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if rec.Op != "open" || rec.Path != "build/core/main.mk" {
+		t.Errorf("got %s %s, want open build/core/main.mk", rec.Op, rec.Path)
+	}
+}
@@ -21,30 +21,48 @@ package gitiles
 
 import (
 	"bytes"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/base64"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
+	"mime"
 	"net/http"
 	"net/url"
 	"path"
+	"sort"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/google/slothfs/cookie"
+	"github.com/google/slothfs/logging"
 	"golang.org/x/net/context"
 	"golang.org/x/time/rate"
 )
 
 // Service is a client for the Gitiles JSON interface.
 type Service struct {
+	// stats is first, so it is 64-bit aligned for atomic access.
+	stats Stats
+
 	limiter *rate.Limiter
 	addr    url.URL
 	client  http.Client
 	agent   string
 	debug   bool
+	logger  *logging.Logger
+
+	treeTimeout time.Duration
+	blobTimeout time.Duration
+
+	// header holds headers added to every request.
+	header http.Header
+
+	etags *etagCache
 }
 
 // Addr returns the address of the gitiles service.
@@ -69,9 +87,63 @@ type Options struct {
 	// HTTPClient allows callers to present their own http.Client instead of the default.
 	HTTPClient http.Client
 
+	// Paths to a PEM client certificate and key, for servers that
+	// require TLS client authentication.
+	ClientCert string
+	ClientKey  string
+
+	// Path to a PEM bundle of CA certificates to trust instead of
+	// the system roots.
+	CACert string
+
+	// Default timeouts for fetching a tree or a blob, applied when
+	// the caller's context has no deadline. Zero means no timeout.
+	TreeTimeout time.Duration
+	BlobTimeout time.Duration
+
+	// Header holds extra headers to send with every request, eg.
+	// for an authenticating proxy in front of the server.
+	Header http.Header
+
+	// Logger receives log messages. If nil, logging.Default() is
+	// used.
+	Logger *logging.Logger
+
+	// If set, log each fetch at Info rather than Debug level.
 	Debug bool
 }
 
+// headerFlag collects "Name: value" flag values into a header.
+type headerFlag struct {
+	header *http.Header
+}
+
+func (f headerFlag) String() string {
+	if f.header == nil {
+		return ""
+	}
+	var hs []string
+	for k, vs := range *f.header {
+		for _, v := range vs {
+			hs = append(hs, k+": "+v)
+		}
+	}
+	sort.Strings(hs)
+	return strings.Join(hs, ", ")
+}
+
+func (f headerFlag) Set(s string) error {
+	i := strings.Index(s, ":")
+	if i <= 0 {
+		return fmt.Errorf("header %q must have the form NAME: VALUE", s)
+	}
+	if *f.header == nil {
+		*f.header = http.Header{}
+	}
+	f.header.Add(strings.TrimSpace(s[:i]), strings.TrimSpace(s[i+1:]))
+	return nil
+}
+
 var defaultOptions Options
 
 // DefineFlags sets up standard command line flags, and returns the
@@ -82,6 +154,12 @@ func DefineFlags() *Options {
 	flag.StringVar(&defaultOptions.UserAgent, "gitiles_agent", "slothfs", "Set the User-Agent string to report to Gitiles.")
 	flag.Float64Var(&defaultOptions.SustainedQPS, "gitiles_qps", 4, "Set the maximum QPS to send to Gitiles.")
 	flag.BoolVar(&defaultOptions.Debug, "gitiles_debug", false, "Print URLs as they are fetched.")
+	flag.StringVar(&defaultOptions.ClientCert, "gitiles_client_cert", "", "Set path to a PEM client certificate for TLS client authentication.")
+	flag.StringVar(&defaultOptions.ClientKey, "gitiles_client_key", "", "Set path to the PEM key for -gitiles_client_cert.")
+	flag.StringVar(&defaultOptions.CACert, "gitiles_ca_cert", "", "Set path to a PEM bundle of CA certificates to trust.")
+	flag.DurationVar(&defaultOptions.TreeTimeout, "gitiles_tree_timeout", 0, "Set the timeout for fetching a tree. Zero means no timeout.")
+	flag.DurationVar(&defaultOptions.BlobTimeout, "gitiles_blob_timeout", 0, "Set the timeout for fetching a blob. Zero means no timeout.")
+	flag.Var(headerFlag{&defaultOptions.Header}, "gitiles_header", "Add a \"NAME: VALUE\" header to all Gitiles requests. May be repeated.")
 	return &defaultOptions
 }
 
@@ -112,11 +190,23 @@ func NewService(opts Options) (*Service, error) {
 	if err != nil {
 		return nil, err
 	}
+	// Gitiles may be served under a path. Normalize it to a clean
+	// directory, so the project list is fetched from the right
+	// URL, and request paths are joined consistently.
+	url.Path = strings.TrimSuffix(path.Clean("/"+url.Path), "/") + "/"
+	url.RawPath = ""
 	s := &Service{
 		limiter: rate.NewLimiter(rate.Limit(opts.SustainedQPS), opts.BurstQPS),
 		addr:    *url,
 		agent:   opts.UserAgent,
 		client:  opts.HTTPClient,
+		etags:   newETagCache(etagCacheSize),
+	}
+
+	if opts.ClientCert != "" || opts.ClientKey != "" || opts.CACert != "" {
+		if err := setTLSConfig(&s.client, opts); err != nil {
+			return nil, err
+		}
 	}
 
 	s.client.Jar = jar
@@ -125,12 +215,98 @@ func NewService(opts Options) (*Service, error) {
 		return nil
 	}
 	s.debug = opts.Debug
+	s.logger = opts.Logger.Sub("gitiles")
+	s.treeTimeout = opts.TreeTimeout
+	s.blobTimeout = opts.BlobTimeout
+	s.header = http.Header{}
+	for k, v := range opts.Header {
+		s.header[http.CanonicalHeaderKey(k)] = append([]string(nil), v...)
+	}
 	return s, nil
 }
 
+// debugf logs details of fetches, at Info level if the Debug option
+// is set.
+func (s *Service) debugf(format string, args ...interface{}) {
+	if s.debug {
+		s.logger.Infof(format, args...)
+	} else {
+		s.logger.Debugf(format, args...)
+	}
+}
+
+// setTLSConfig configures client certificates and CAs from opts
+// into the transport of client.
+func setTLSConfig(client *http.Client, opts Options) error {
+	var transport *http.Transport
+	switch t := client.Transport.(type) {
+	case nil:
+		transport = http.DefaultTransport.(*http.Transport).Clone()
+	case *http.Transport:
+		transport = t.Clone()
+	default:
+		return fmt.Errorf("gitiles: cannot set TLS options on transport %T", t)
+	}
+
+	config := &tls.Config{}
+	if transport.TLSClientConfig != nil {
+		config = transport.TLSClientConfig.Clone()
+	}
+	if opts.ClientCert != "" || opts.ClientKey != "" {
+		if opts.ClientCert == "" || opts.ClientKey == "" {
+			return fmt.Errorf("gitiles: client certificate and key must be given together")
+		}
+		cert, err := tls.LoadX509KeyPair(opts.ClientCert, opts.ClientKey)
+		if err != nil {
+			return fmt.Errorf("LoadX509KeyPair(%s, %s): %v", opts.ClientCert, opts.ClientKey, err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	if opts.CACert != "" {
+		pem, err := ioutil.ReadFile(opts.CACert)
+		if err != nil {
+			return err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("gitiles: no certificates found in %s", opts.CACert)
+		}
+	}
+
+	transport.TLSClientConfig = config
+	client.Transport = transport
+	return nil
+}
+
+// withDefaultTimeout applies timeout to ctx, unless ctx already has a
+// deadline or timeout is zero.
+func withDefaultTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok || timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
 func (s *Service) stream(u *url.URL) (*http.Response, error) {
-	ctx := context.Background()
+	return s.streamFrom(context.Background(), u, 0)
+}
 
+// streamFrom issues a GET request for u. If offset is nonzero, it
+// asks the server to start at the given byte offset. The server may
+// ignore this, which the caller can detect by the response not having
+// status 206.
+func (s *Service) streamFrom(ctx context.Context, u *url.URL, offset int64) (*http.Response, error) {
+	header := http.Header{}
+	if offset > 0 {
+		header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	return s.do(ctx, u, header)
+}
+
+// do issues a GET request for u with the given extra headers. Besides
+// 200, it accepts 206 for requests with a Range header, and 304 for
+// requests with an If-None-Match header.
+func (s *Service) do(ctx context.Context, u *url.URL, header http.Header) (*http.Response, error) {
 	if err := s.limiter.Wait(ctx); err != nil {
 		return nil, err
 	}
@@ -138,21 +314,33 @@ func (s *Service) stream(u *url.URL) (*http.Response, error) {
 	if err != nil {
 		return nil, err
 	}
+	req = req.WithContext(ctx)
+	// Copy the values, so adding to the request's header does not
+	// write into slices shared with other requests.
+	for k, v := range s.header {
+		req.Header[k] = append([]string(nil), v...)
+	}
+	for k, v := range header {
+		req.Header[k] = append([]string(nil), v...)
+	}
 	req.Header.Add("User-Agent", s.agent)
+	atomic.AddInt64(&s.stats.Requests, 1)
 	resp, err := s.client.Do(req)
 
 	if err != nil {
 		return nil, err
 	}
+	resp.Body = newCountingBody(resp.Body, &s.stats)
 
-	if resp.StatusCode != 200 {
+	ok := resp.StatusCode == http.StatusOK ||
+		(resp.StatusCode == http.StatusPartialContent && header.Get("Range") != "") ||
+		(resp.StatusCode == http.StatusNotModified && header.Get("If-None-Match") != "")
+	if !ok {
 		resp.Body.Close()
 		return nil, fmt.Errorf("%s: %s", u.String(), resp.Status)
 	}
 
-	if s.debug {
-		log.Printf("%s %s: %d", req.Method, req.URL, resp.StatusCode)
-	}
+	s.debugf("%s %s: %d", req.Method, req.URL, resp.StatusCode)
 	if got := resp.Request.URL.String(); got != u.String() {
 		resp.Body.Close()
 		// We accept redirects, but only for authentication.
@@ -164,14 +352,83 @@ func (s *Service) stream(u *url.URL) (*http.Response, error) {
 	return resp, nil
 }
 
-func (s *Service) get(u *url.URL) ([]byte, error) {
-	resp, err := s.stream(u)
+// blobRetries is the number of times we try to resume a blob
+// download after the connection broke.
+const blobRetries = 3
+
+// resumingReader reads a HTTP response body. If the connection
+// breaks, it reissues the request starting at the first byte that
+// was not read yet.
+type resumingReader struct {
+	ctx     context.Context
+	service *Service
+	url     *url.URL
+	body    io.ReadCloser
+
+	offset  int64
+	retries int
+}
+
+func (r *resumingReader) Read(dest []byte) (int, error) {
+	for {
+		n, err := r.body.Read(dest)
+		r.offset += int64(n)
+		if err == nil || err == io.EOF {
+			return n, err
+		}
+		if r.retries >= blobRetries {
+			return n, err
+		}
+		r.retries++
+
+		r.service.logger.Warningf("resuming %s at byte %d (attempt %d): %v", r.url, r.offset, r.retries, err)
+		r.body.Close()
+		resp, err := r.service.streamFrom(r.ctx, r.url, r.offset)
+		if err != nil {
+			r.body = ioutil.NopCloser(&bytes.Buffer{})
+			return n, err
+		}
+		r.body = resp.Body
+		if resp.StatusCode != http.StatusPartialContent {
+			// The server ignored our Range header, so skip
+			// the data we already have.
+			if _, err := io.CopyN(ioutil.Discard, r.body, r.offset); err != nil {
+				return n, err
+			}
+		}
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+func (r *resumingReader) Close() error {
+	return r.body.Close()
+}
+
+// get fetches u. If an earlier response for u carried an ETag, the
+// request is conditional, and a 304 response yields the earlier
+// content.
+func (s *Service) get(ctx context.Context, u *url.URL) ([]byte, error) {
+	key := u.String()
+	header := http.Header{}
+	cached, haveCached := s.etags.get(key)
+	if haveCached {
+		header.Set("If-None-Match", cached.etag)
+	}
+
+	resp, err := s.do(ctx, u, header)
 	if err != nil {
 		return nil, err
 	}
 
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusNotModified {
+		atomic.AddInt64(&s.stats.NotModified, 1)
+		return cached.body, nil
+	}
+
 	c, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		return nil, err
@@ -180,23 +437,30 @@ func (s *Service) get(u *url.URL) ([]byte, error) {
 	if resp.Header.Get("Content-Type") == "text/plain; charset=UTF-8" {
 		out := make([]byte, base64.StdEncoding.DecodedLen(len(c)))
 		n, err := base64.StdEncoding.Decode(out, c)
-		return out[:n], err
+		if err != nil {
+			return nil, fmt.Errorf("%s: response is not valid base64: %v", u, err)
+		}
+		c = out[:n]
+	}
+
+	if etag := resp.Header.Get("ETag"); etag != "" {
+		s.etags.add(key, etag, c)
 	}
 	return c, nil
 }
 
+// xssTag is the line Gitiles puts before JSON responses to prevent
+// cross-site script inclusion.
 var xssTag = []byte(")]}'\n")
 
-func (s *Service) getJSON(u *url.URL, dest interface{}) error {
-	c, err := s.get(u)
+func (s *Service) getJSON(ctx context.Context, u *url.URL, dest interface{}) error {
+	c, err := s.get(ctx, u)
 	if err != nil {
 		return err
 	}
 
-	if !bytes.HasPrefix(c, xssTag) {
-		return fmt.Errorf("Gitiles JSON %s missing XSS tag: %q", u, c)
-	}
-	c = c[len(xssTag):]
+	// Some servers omit the tag; parse those responses as is.
+	c = bytes.TrimPrefix(c, xssTag)
 
 	err = json.Unmarshal(c, dest)
 	if err != nil {
@@ -205,6 +469,25 @@ func (s *Service) getJSON(u *url.URL, dest interface{}) error {
 	return err
 }
 
+// pingTimeout bounds how long Ping waits for the server.
+const pingTimeout = 10 * time.Second
+
+// Ping checks that the server is reachable, and that it serves
+// Gitiles JSON. The project list is parsed like any other JSON
+// response, so servers that omit the XSS tag are accepted.
+func (s *Service) Ping() error {
+	ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
+	defer cancel()
+
+	pingURL := s.addr
+	pingURL.RawQuery = "format=JSON"
+	var projects map[string]json.RawMessage
+	if err := s.getJSON(ctx, &pingURL, &projects); err != nil {
+		return fmt.Errorf("gitiles: ping %s: %v; is this a Gitiles server?", s.addr.String(), err)
+	}
+	return nil
+}
+
 // List retrieves the list of projects.
 func (s *Service) List(branches []string) (map[string]*Project, error) {
 	listURL := s.addr
@@ -214,7 +497,7 @@ func (s *Service) List(branches []string) (map[string]*Project, error) {
 	}
 
 	projects := map[string]*Project{}
-	err := s.getJSON(&listURL, &projects)
+	err := s.getJSON(context.Background(), &listURL, &projects)
 	for k, v := range projects {
 		if k != v.Name {
 			return nil, fmt.Errorf("gitiles: key %q had project name %q", k, v.Name)
@@ -239,6 +522,11 @@ type RepoService struct {
 	service *Service
 }
 
+// Service returns the service hosting the repository.
+func (s *RepoService) Service() *Service {
+	return s.service
+}
+
 // Get retrieves a single project.
 func (s *RepoService) Get() (*Project, error) {
 	jsonURL := s.service.addr
@@ -246,20 +534,138 @@ func (s *RepoService) Get() (*Project, error) {
 	jsonURL.RawQuery = "format=JSON"
 
 	var p Project
-	err := s.service.getJSON(&jsonURL, &p)
+	err := s.service.getJSON(context.Background(), &jsonURL, &p)
 	return &p, err
 }
 
-// GetBlob fetches a blob.
+// GetBlob fetches a blob. The content is decoded while it is being
+// downloaded, and the download is resumed if the connection breaks.
 func (s *RepoService) GetBlob(branch, filename string) ([]byte, error) {
+	return s.GetBlobContext(context.Background(), branch, filename)
+}
+
+// GetBlobContext is like GetBlob, but stops when ctx is done. If ctx
+// has no deadline, the service's BlobTimeout applies.
+func (s *RepoService) GetBlobContext(ctx context.Context, branch, filename string) ([]byte, error) {
+	body, err := s.openBlob(ctx, branch, filename)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	c, err := ioutil.ReadAll(body)
+	if _, ok := err.(base64.CorruptInputError); ok {
+		return nil, fmt.Errorf("GetBlob(%s): response is not valid base64: %v", body.url, err)
+	} else if err != nil {
+		return nil, fmt.Errorf("GetBlob(%s): %v", body.url, err)
+	}
+	s.service.debugf("GetBlob(%s): %d bytes, %d resumes", body.url, len(c), body.body.retries)
+	return c, nil
+}
+
+// GetBlobReader is like GetBlobContext, but returns the content as a
+// stream that is decoded as it is read, so large blobs need not be
+// held in memory. The caller must close it.
+func (s *RepoService) GetBlobReader(ctx context.Context, branch, filename string) (io.ReadCloser, error) {
+	return s.openBlob(ctx, branch, filename)
+}
+
+// blobReader decodes a blob while it is downloaded.
+type blobReader struct {
+	io.Reader
+	url    *url.URL
+	body   *resumingReader
+	cancel context.CancelFunc
+}
+
+func (r *blobReader) Close() error {
+	err := r.body.Close()
+	r.cancel()
+	return err
+}
+
+func (s *RepoService) openBlob(ctx context.Context, branch, filename string) (*blobReader, error) {
+	ctx, cancel := withDefaultTimeout(ctx, s.service.blobTimeout)
+
 	blobURL := s.service.addr
 
 	blobURL.Path = path.Join(blobURL.Path, s.Name, "+show", branch, filename)
 	blobURL.RawQuery = "format=TEXT"
 
-	// TODO(hanwen): invent a more structured mechanism for logging.
-	log.Println(blobURL.String())
-	return s.service.get(&blobURL)
+	s.service.debugf("GetBlob(%s)", &blobURL)
+
+	atomic.AddInt64(&s.service.stats.BlobFetches, 1)
+	resp, err := s.service.streamFrom(ctx, &blobURL, 0)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	// Gitiles serves blobs base64 encoded as text/plain. Anything
+	// else is probably an error or login page.
+	contentType := resp.Header.Get("Content-Type")
+	if mt, _, err := mime.ParseMediaType(contentType); err != nil || mt != "text/plain" {
+		resp.Body.Close()
+		cancel()
+		return nil, fmt.Errorf("GetBlob(%s): got Content-Type %q, want text/plain", &blobURL, contentType)
+	}
+
+	body := &resumingReader{
+		ctx:     ctx,
+		service: s.service,
+		url:     &blobURL,
+		body:    resp.Body,
+	}
+	return &blobReader{
+		Reader: base64.NewDecoder(base64.StdEncoding, body),
+		url:    &blobURL,
+		body:   body,
+		cancel: cancel,
+	}, nil
+}
+
+// getBlobsParallelism bounds the number of concurrent fetches in
+// GetBlobs. The rate limiter still applies on top of this.
+const getBlobsParallelism = 8
+
+// GetBlobs fetches filename from repo at each of the given refs,
+// eg. a manifest across a set of branches. The result is keyed by
+// ref. If any fetch fails, an error is returned.
+func (s *Service) GetBlobs(repo string, refs []string, filename string) (map[string][]byte, error) {
+	rs := s.NewRepoService(repo)
+
+	type result struct {
+		ref     string
+		content []byte
+		err     error
+	}
+	results := make(chan result, len(refs))
+	sem := make(chan struct{}, getBlobsParallelism)
+	for _, ref := range refs {
+		go func(ref string) {
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			c, err := rs.GetBlob(ref, filename)
+			results <- result{ref, c, err}
+		}(ref)
+	}
+
+	blobs := make(map[string][]byte, len(refs))
+	var firstErr error
+	for range refs {
+		r := <-results
+		if r.err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("GetBlobs(%s, %s): %v", repo, r.ref, r.err)
+			}
+			continue
+		}
+		blobs[r.ref] = r.content
+	}
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return blobs, nil
 }
 
 // Archive formats for +archive. JGit also supports some shorthands.
@@ -294,10 +700,35 @@ func (s *RepoService) GetArchive(revision, dirPrefix, format string) (io.ReadClo
 	return resp.Body, err
 }
 
+// WriteArchive streams a gzipped tarball of the project at revision
+// into w. If dirPrefix is given, only that subdirectory is included,
+// with the prefix stripped from the file names.
+func (s *RepoService) WriteArchive(revision, dirPrefix string, w io.Writer) error {
+	r, err := s.GetArchive(revision, dirPrefix, ArchiveTgz)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	if _, err := io.Copy(w, r); err != nil {
+		return fmt.Errorf("WriteArchive(%s, %s): %v", s.Name, revision, err)
+	}
+	return nil
+}
+
 // GetTree fetches a tree. The dir argument may not point to a
 // blob. If recursive is given, the server recursively expands the
 // tree.
 func (s *RepoService) GetTree(branch, dir string, recursive bool) (*Tree, error) {
+	return s.GetTreeContext(context.Background(), branch, dir, recursive)
+}
+
+// GetTreeContext is like GetTree, but stops when ctx is done. If ctx
+// has no deadline, the service's TreeTimeout applies.
+func (s *RepoService) GetTreeContext(ctx context.Context, branch, dir string, recursive bool) (*Tree, error) {
+	ctx, cancel := withDefaultTimeout(ctx, s.service.treeTimeout)
+	defer cancel()
+
 	jsonURL := s.service.addr
 	jsonURL.Path = path.Join(jsonURL.Path, s.Name, "+", branch, dir)
 	if !strings.HasSuffix(jsonURL.Path, "/") {
@@ -310,18 +741,23 @@ func (s *RepoService) GetTree(branch, dir string, recursive bool) (*Tree, error)
 	}
 
 	var tree Tree
-	err := s.service.getJSON(&jsonURL, &tree)
+	err := s.service.getJSON(ctx, &jsonURL, &tree)
 	return &tree, err
 }
 
 // GetCommit gets the data of a commit in a branch.
 func (s *RepoService) GetCommit(branch string) (*Commit, error) {
+	return s.GetCommitContext(context.Background(), branch)
+}
+
+// GetCommitContext is like GetCommit, but stops when ctx is done.
+func (s *RepoService) GetCommitContext(ctx context.Context, branch string) (*Commit, error) {
 	jsonURL := s.service.addr
 	jsonURL.Path = path.Join(jsonURL.Path, s.Name, "+", branch)
 	jsonURL.RawQuery = "format=JSON"
 
 	var c Commit
-	err := s.service.getJSON(&jsonURL, &c)
+	err := s.service.getJSON(ctx, &jsonURL, &c)
 	return &c, err
 }
 
@@ -349,7 +785,7 @@ func (s *RepoService) Describe(revision string, options ...string) (string, erro
 	jsonURL.RawQuery = "format=JSON&" + strings.Join(options, "&")
 
 	result := map[string]string{}
-	err := s.service.getJSON(&jsonURL, &result)
+	err := s.service.getJSON(context.Background(), &jsonURL, &result)
 	if err != nil {
 		return "", err
 	}
@@ -376,7 +812,7 @@ func (s *RepoService) Refs(prefix string) (map[string]*RefData, error) {
 	jsonURL.RawQuery = "format=JSON"
 
 	result := map[string]*RefData{}
-	err := s.service.getJSON(&jsonURL, &result)
+	err := s.service.getJSON(context.Background(), &jsonURL, &result)
 	if err != nil {
 		return nil, err
 	}
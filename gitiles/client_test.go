@@ -0,0 +1,584 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitiles
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestGetBlobResume(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789abcdef"), 4096)
+	encoded := []byte(base64.StdEncoding.EncodeToString(content))
+
+	var ranges []string
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		rng := r.Header.Get("Range")
+		ranges = append(ranges, rng)
+		if rng == "" {
+			// Send half the data, and then break the connection.
+			w.Header().Set("Content-Length", strconv.Itoa(len(encoded)))
+			w.WriteHeader(http.StatusOK)
+			w.Write(encoded[:len(encoded)/2+1])
+			w.(http.Flusher).Flush()
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				t.Errorf("Hijack: %v", err)
+				return
+			}
+			conn.Close()
+			return
+		}
+
+		start, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(rng, "bytes="), "-"))
+		if err != nil {
+			t.Errorf("bad range %q", rng)
+			return
+		}
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, len(encoded)-1, len(encoded)))
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write(encoded[start:])
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(handler))
+	defer ts.Close()
+
+	service, err := NewService(Options{Address: ts.URL})
+	if err != nil {
+		t.Fatalf("NewService: %v", err)
+	}
+
+	got, err := service.NewRepoService("repo").GetBlob("master", "file")
+	if err != nil {
+		t.Fatalf("GetBlob: %v", err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Errorf("got %d bytes, want %d bytes", len(got), len(content))
+	}
+	if len(ranges) != 2 || ranges[1] == "" {
+		t.Errorf("got Range headers %q, want a resumed request", ranges)
+	}
+
+	stats := service.Stats()
+	if stats.Requests != 2 || stats.BlobFetches != 1 || stats.InFlight != 0 {
+		t.Errorf("got stats %+v, want 2 requests, 1 blob fetch, 0 in flight", stats)
+	}
+	if stats.BytesDownloaded < int64(len(encoded)) {
+		t.Errorf("got %d bytes downloaded, want at least %d", stats.BytesDownloaded, len(encoded))
+	}
+}
+
+func TestGetBlobReader(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789abcdef"), 4096)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		w.Write([]byte(base64.StdEncoding.EncodeToString(content)))
+	}))
+	defer ts.Close()
+
+	service, err := NewService(Options{Address: ts.URL})
+	if err != nil {
+		t.Fatalf("NewService: %v", err)
+	}
+
+	r, err := service.NewRepoService("repo").GetBlobReader(context.Background(), "master", "file")
+	if err != nil {
+		t.Fatalf("GetBlobReader: %v", err)
+	}
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if err := r.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Errorf("got %d bytes, want %d bytes", len(got), len(content))
+	}
+	if stats := service.Stats(); stats.BlobFetches != 1 || stats.InFlight != 0 {
+		t.Errorf("got stats %+v, want 1 blob fetch, 0 in flight", stats)
+	}
+}
+
+// writeClientCert writes a self-signed client certificate and its key
+// as PEM files into dir.
+func writeClientCert(dir string) (cert *x509.Certificate, certFile, keyFile string, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, "", "", err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "slothfs test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, "", "", err
+	}
+	cert, err = x509.ParseCertificate(der)
+	if err != nil {
+		return nil, "", "", err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+	if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		return nil, "", "", err
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return nil, "", "", err
+	}
+	return cert, certFile, keyFile, nil
+}
+
+func TestClientCertificate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cert, certFile, keyFile, err := writeClientCert(dir)
+	if err != nil {
+		t.Fatalf("writeClientCert: %v", err)
+	}
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(")]}'\n{}"))
+	}))
+	ts.TLS = &tls.Config{
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  x509.NewCertPool(),
+	}
+	ts.TLS.ClientCAs.AddCert(cert)
+	ts.StartTLS()
+	defer ts.Close()
+
+	caFile := filepath.Join(dir, "ca.pem")
+	if err := ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw}), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	service, err := NewService(Options{
+		Address:    ts.URL,
+		ClientCert: certFile,
+		ClientKey:  keyFile,
+		CACert:     caFile,
+	})
+	if err != nil {
+		t.Fatalf("NewService: %v", err)
+	}
+	if _, err := service.List(nil); err != nil {
+		t.Errorf("List with client certificate: %v", err)
+	}
+
+	service, err = NewService(Options{
+		Address: ts.URL,
+		CACert:  caFile,
+	})
+	if err != nil {
+		t.Fatalf("NewService: %v", err)
+	}
+	if _, err := service.List(nil); err == nil {
+		t.Errorf("List without client certificate succeeded")
+	}
+
+	if _, err := NewService(Options{
+		Address:    ts.URL,
+		ClientCert: certFile,
+		ClientKey:  filepath.Join(dir, "nonexistent.pem"),
+	}); err == nil {
+		t.Errorf("NewService with missing key succeeded")
+	}
+}
+
+func TestPing(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/gitiles/":
+			w.Write([]byte(")]}'\n{}"))
+		case "/notag/":
+			w.Write([]byte("{}"))
+		case "/html/":
+			w.Write([]byte("<html>login</html>"))
+		default:
+			http.NotFound(w, r)
+		}
+	}
+	ts := httptest.NewServer(http.HandlerFunc(handler))
+	defer ts.Close()
+
+	for path, ok := range map[string]bool{
+		"/gitiles/": true,
+		"/notag/":   true,
+		"/html/":    false,
+		"/missing/": false,
+	} {
+		service, err := NewService(Options{Address: ts.URL + path})
+		if err != nil {
+			t.Fatalf("NewService: %v", err)
+		}
+		if err := service.Ping(); (err == nil) != ok {
+			t.Errorf("Ping(%s): got %v, want success %v", path, err, ok)
+		}
+	}
+
+	ts.Close()
+	service, err := NewService(Options{Address: ts.URL + "/gitiles/"})
+	if err != nil {
+		t.Fatalf("NewService: %v", err)
+	}
+	if err := service.Ping(); err == nil {
+		t.Errorf("Ping on closed server succeeded")
+	}
+}
+
+func TestGetBlobCorrupt(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/repo/+show/master/html":
+			w.Header().Set("Content-Type", "text/html; charset=UTF-8")
+			w.Write([]byte("<html>error</html>"))
+		case "/repo/+show/master/corrupt":
+			w.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+			w.Write([]byte(base64.StdEncoding.EncodeToString([]byte("hello world"))[:8] + "!!!!"))
+		default:
+			http.NotFound(w, r)
+		}
+	}
+	ts := httptest.NewServer(http.HandlerFunc(handler))
+	defer ts.Close()
+
+	service, err := NewService(Options{Address: ts.URL})
+	if err != nil {
+		t.Fatalf("NewService: %v", err)
+	}
+	repo := service.NewRepoService("repo")
+	for file, want := range map[string]string{
+		"html":    "Content-Type",
+		"corrupt": "not valid base64",
+	} {
+		c, err := repo.GetBlob("master", file)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("GetBlob(%s): got error %v, want %q", file, err, want)
+		}
+		if c != nil {
+			t.Errorf("GetBlob(%s): got content %q with error", file, c)
+		}
+	}
+}
+
+func TestGetTreeETag(t *testing.T) {
+	var conditional int
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			conditional++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte(")]}'\n{\"id\": \"abc\"}"))
+	}
+	ts := httptest.NewServer(http.HandlerFunc(handler))
+	defer ts.Close()
+
+	service, err := NewService(Options{Address: ts.URL})
+	if err != nil {
+		t.Fatalf("NewService: %v", err)
+	}
+	repo := service.NewRepoService("platform/build")
+	for i := 0; i < 2; i++ {
+		tree, err := repo.GetTree("master", "/", true)
+		if err != nil {
+			t.Fatalf("GetTree %d: %v", i, err)
+		}
+		if tree.ID != "abc" {
+			t.Errorf("GetTree %d: got ID %q, want abc", i, tree.ID)
+		}
+	}
+	if conditional != 1 {
+		t.Errorf("got %d conditional requests, want 1", conditional)
+	}
+	if got := service.Stats().NotModified; got != 1 {
+		t.Errorf("got NotModified %d, want 1", got)
+	}
+}
+
+func TestGetBlobs(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		const prefix = "/platform/manifest/+show/"
+		const suffix = "/default.xml"
+		if !strings.HasPrefix(r.URL.Path, prefix) || !strings.HasSuffix(r.URL.Path, suffix) {
+			http.NotFound(w, r)
+			return
+		}
+		branch := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, prefix), suffix)
+		if branch == "missing" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		w.Write([]byte(base64.StdEncoding.EncodeToString([]byte("manifest " + branch))))
+	}
+	ts := httptest.NewServer(http.HandlerFunc(handler))
+	defer ts.Close()
+
+	service, err := NewService(Options{Address: ts.URL})
+	if err != nil {
+		t.Fatalf("NewService: %v", err)
+	}
+
+	var refs []string
+	for i := 0; i < 20; i++ {
+		refs = append(refs, fmt.Sprintf("branch%d", i))
+	}
+	blobs, err := service.GetBlobs("platform/manifest", refs, "default.xml")
+	if err != nil {
+		t.Fatalf("GetBlobs: %v", err)
+	}
+	if len(blobs) != len(refs) {
+		t.Errorf("got %d blobs, want %d", len(blobs), len(refs))
+	}
+	for _, ref := range refs {
+		if got, want := string(blobs[ref]), "manifest "+ref; got != want {
+			t.Errorf("%s: got %q, want %q", ref, got, want)
+		}
+	}
+
+	if _, err := service.GetBlobs("platform/manifest", []string{"branch1", "missing"}, "default.xml"); err == nil || !strings.Contains(err.Error(), "missing") {
+		t.Errorf("GetBlobs with missing ref: got %v, want error naming the ref", err)
+	}
+}
+
+func TestWriteArchive(t *testing.T) {
+	content := []byte("tarball contents")
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/platform/build/+archive/master/core.tar.gz" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-gzip")
+		w.Write(content)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(handler))
+	defer ts.Close()
+
+	service, err := NewService(Options{Address: ts.URL})
+	if err != nil {
+		t.Fatalf("NewService: %v", err)
+	}
+	repo := service.NewRepoService("platform/build")
+
+	var buf bytes.Buffer
+	if err := repo.WriteArchive("master", "core", &buf); err != nil {
+		t.Fatalf("WriteArchive: %v", err)
+	}
+	if !bytes.Equal(buf.Bytes(), content) {
+		t.Errorf("got %q, want %q", buf.Bytes(), content)
+	}
+
+	if err := repo.WriteArchive("master", "missing", &buf); err == nil {
+		t.Errorf("WriteArchive(missing) succeeded")
+	}
+}
+
+func TestSubpath(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/a/gitiles/", func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/a/gitiles/":
+			w.Write([]byte(")]}'\n{\"platform/build\": {\"name\": \"platform/build\"}}"))
+		case "/a/gitiles/platform/build":
+			w.Write([]byte(")]}'\n{\"name\": \"platform/build\"}"))
+		case "/a/gitiles/platform/build/+/master/":
+			w.Write([]byte(")]}'\n{\"id\": \"abc\"}"))
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	for _, addr := range []string{
+		ts.URL + "/a/gitiles",
+		ts.URL + "/a/gitiles/",
+		ts.URL + "/a//gitiles/./",
+	} {
+		service, err := NewService(Options{Address: addr})
+		if err != nil {
+			t.Fatalf("NewService(%s): %v", addr, err)
+		}
+		if got, want := service.Addr(), ts.URL+"/a/gitiles/"; got != want {
+			t.Errorf("%s: got Addr %q, want %q", addr, got, want)
+		}
+		if projects, err := service.List(nil); err != nil || projects["platform/build"] == nil {
+			t.Errorf("%s: List: got %v, %v", addr, projects, err)
+		}
+		repo := service.NewRepoService("platform/build")
+		if p, err := repo.Get(); err != nil || p.Name != "platform/build" {
+			t.Errorf("%s: Get: got %v, %v", addr, p, err)
+		}
+		if tree, err := repo.GetTree("master", "", false); err != nil || tree.ID != "abc" {
+			t.Errorf("%s: GetTree: got %v, %v", addr, tree, err)
+		}
+	}
+}
+
+func TestJSONWithoutXSSTag(t *testing.T) {
+	for _, tag := range []string{")]}'\n", ""} {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/":
+				w.Write([]byte(tag + "{\"platform/build\": {\"name\": \"platform/build\"}}"))
+			case "/platform/build":
+				w.Write([]byte(tag + "{\"name\": \"platform/build\"}"))
+			case "/platform/build/+/master/":
+				w.Write([]byte(tag + "{\"id\": \"abc\"}"))
+			default:
+				http.NotFound(w, r)
+			}
+		})
+		ts := httptest.NewServer(mux)
+
+		service, err := NewService(Options{Address: ts.URL})
+		if err != nil {
+			t.Fatalf("NewService: %v", err)
+		}
+		if projects, err := service.List(nil); err != nil || projects["platform/build"] == nil {
+			t.Errorf("tag %q: List: got %v, %v", tag, projects, err)
+		}
+		repo := service.NewRepoService("platform/build")
+		if p, err := repo.Get(); err != nil || p.Name != "platform/build" {
+			t.Errorf("tag %q: Get: got %v, %v", tag, p, err)
+		}
+		if tree, err := repo.GetTree("master", "", false); err != nil || tree.ID != "abc" {
+			t.Errorf("tag %q: GetTree: got %v, %v", tag, tree, err)
+		}
+		ts.Close()
+	}
+}
+
+func TestHeader(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("X-Proxy-Route"); got != "gitiles" {
+			http.Error(w, "missing header", http.StatusForbidden)
+			return
+		}
+		if strings.Contains(r.URL.Path, "/+show/") {
+			w.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+			w.Write([]byte(base64.StdEncoding.EncodeToString([]byte("hello"))))
+		} else {
+			w.Write([]byte(")]}'\n{\"id\": \"abc\"}"))
+		}
+	}))
+	defer ts.Close()
+
+	var header http.Header
+	f := headerFlag{&header}
+	if err := f.Set("x-proxy-route: gitiles"); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if err := f.Set("no colon"); err == nil {
+		t.Errorf("Set(no colon) succeeded")
+	}
+	if got, want := f.String(), "X-Proxy-Route: gitiles"; got != want {
+		t.Errorf("got flag %q, want %q", got, want)
+	}
+
+	service, err := NewService(Options{Address: ts.URL, Header: header})
+	if err != nil {
+		t.Fatalf("NewService: %v", err)
+	}
+	header.Set("X-Proxy-Route", "changed")
+
+	repo := service.NewRepoService("platform/build")
+	if tree, err := repo.GetTree("master", "", false); err != nil || tree.ID != "abc" {
+		t.Errorf("GetTree: got %v, %v", tree, err)
+	}
+	if c, err := repo.GetBlob("master", "README"); err != nil || string(c) != "hello" {
+		t.Errorf("GetBlob: got %q, %v", c, err)
+	}
+}
+
+func TestTimeouts(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(200 * time.Millisecond):
+		case <-r.Context().Done():
+			return
+		}
+		if strings.Contains(r.URL.Path, "/+show/") {
+			w.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+			w.Write([]byte(base64.StdEncoding.EncodeToString([]byte("hello"))))
+		} else {
+			w.Write([]byte(")]}'\n{\"id\": \"abc\"}"))
+		}
+	}
+	ts := httptest.NewServer(http.HandlerFunc(handler))
+	defer ts.Close()
+
+	service, err := NewService(Options{
+		Address:     ts.URL,
+		TreeTimeout: 10 * time.Second,
+		BlobTimeout: 20 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("NewService: %v", err)
+	}
+	repo := service.NewRepoService("platform/build")
+
+	if _, err := repo.GetBlob("master", "README"); err == nil {
+		t.Errorf("GetBlob succeeded despite BlobTimeout")
+	}
+	if tree, err := repo.GetTree("master", "/", false); err != nil || tree.ID != "abc" {
+		t.Errorf("GetTree: got %v, %v", tree, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if c, err := repo.GetBlobContext(ctx, "master", "README"); err != nil || string(c) != "hello" {
+		t.Errorf("GetBlobContext with deadline: got %q, %v", c, err)
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitiles
+
+import "sync"
+
+// etagCacheSize bounds the number of response bytes kept for
+// conditional requests.
+const etagCacheSize = 64 << 20
+
+// etagEntry is a response body along with its ETag.
+type etagEntry struct {
+	etag string
+	body []byte
+}
+
+// etagCache holds JSON responses keyed by URL, so they can be
+// revalidated with If-None-Match rather than downloaded again.
+type etagCache struct {
+	mu      sync.Mutex
+	entries map[string]etagEntry
+	size    int
+	maxSize int
+}
+
+func newETagCache(maxSize int) *etagCache {
+	return &etagCache{
+		entries: map[string]etagEntry{},
+		maxSize: maxSize,
+	}
+}
+
+func (c *etagCache) get(key string) (etagEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	return e, ok
+}
+
+// add stores a response. If the cache is full, arbitrary entries are
+// evicted.
+func (c *etagCache) add(key, etag string, body []byte) {
+	if len(body) > c.maxSize {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if old, ok := c.entries[key]; ok {
+		c.size -= len(old.body)
+		delete(c.entries, key)
+	}
+	for k, e := range c.entries {
+		if c.size+len(body) <= c.maxSize {
+			break
+		}
+		c.size -= len(e.body)
+		delete(c.entries, k)
+	}
+	c.entries[key] = etagEntry{etag, body}
+	c.size += len(body)
+}
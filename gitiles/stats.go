@@ -0,0 +1,82 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitiles
+
+import (
+	"io"
+	"sync/atomic"
+)
+
+// Stats holds counters for the traffic generated by a Service.
+type Stats struct {
+	// Requests is the number of HTTP requests issued.
+	Requests int64
+
+	// InFlight is the number of responses whose body has not been
+	// closed yet.
+	InFlight int64
+
+	// BlobFetches is the number of GetBlob calls.
+	BlobFetches int64
+
+	// BytesDownloaded is the number of response body bytes read.
+	BytesDownloaded int64
+
+	// NotModified is the number of conditional requests answered
+	// with 304 Not Modified.
+	NotModified int64
+}
+
+// Stats returns a snapshot of the counters. It is safe for
+// concurrent use.
+func (s *Service) Stats() Stats {
+	return Stats{
+		Requests:        atomic.LoadInt64(&s.stats.Requests),
+		InFlight:        atomic.LoadInt64(&s.stats.InFlight),
+		BlobFetches:     atomic.LoadInt64(&s.stats.BlobFetches),
+		BytesDownloaded: atomic.LoadInt64(&s.stats.BytesDownloaded),
+		NotModified:     atomic.LoadInt64(&s.stats.NotModified),
+	}
+}
+
+// Stats returns the counters of the underlying Service.
+func (s *RepoService) Stats() Stats {
+	return s.service.Stats()
+}
+
+// countingBody wraps a response body to update Stats.
+type countingBody struct {
+	io.ReadCloser
+	stats  *Stats
+	closed int32
+}
+
+func newCountingBody(body io.ReadCloser, stats *Stats) *countingBody {
+	atomic.AddInt64(&stats.InFlight, 1)
+	return &countingBody{ReadCloser: body, stats: stats}
+}
+
+func (b *countingBody) Read(dest []byte) (int, error) {
+	n, err := b.ReadCloser.Read(dest)
+	atomic.AddInt64(&b.stats.BytesDownloaded, int64(n))
+	return n, err
+}
+
+func (b *countingBody) Close() error {
+	if atomic.CompareAndSwapInt32(&b.closed, 0, 1) {
+		atomic.AddInt64(&b.stats.InFlight, -1)
+	}
+	return b.ReadCloser.Close()
+}
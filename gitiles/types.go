@@ -17,6 +17,9 @@ package gitiles
 import (
 	"bytes"
 	"fmt"
+	"path"
+	"strings"
+	"time"
 )
 
 // Project describes a repository
@@ -32,10 +35,33 @@ type Person struct {
 	Name  string
 	Email string
 
-	// TODO(hanwen): time.Time.
+	// Time is the timestamp as formatted by Gitiles. Use GetTime
+	// to parse it.
 	Time string
 }
 
+// CommitTimeFormat is the layout of Person.Time in commits.
+const CommitTimeFormat = "Mon Jan 02 15:04:05 2006 -0700"
+
+// timeFormats are the layouts of Person.Time: commits use the first,
+// blame regions the second.
+var timeFormats = []string{
+	CommitTimeFormat,
+	"2006-01-02 15:04:05 -0700",
+}
+
+// GetTime returns the parsed Time field.
+func (p *Person) GetTime() (time.Time, error) {
+	var err error
+	for _, f := range timeFormats {
+		var t time.Time
+		if t, err = time.Parse(f, p.Time); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, err
+}
+
 // DiffEntry describes a file difference.
 type DiffEntry struct {
 	Type    string
@@ -118,6 +144,36 @@ func (t *Tree) String() string {
 
 }
 
+// Merge adds the entries of other to t, with their names prefixed by
+// prefix and a slash. An empty prefix merges at the root. Entries
+// that are already present with the same content are skipped; an
+// entry with the same name but different content is an error.
+func (t *Tree) Merge(prefix string, other *Tree) error {
+	prefix = strings.Trim(path.Clean("/"+prefix), "/")
+
+	existing := make(map[string]TreeEntry, len(t.Entries))
+	for _, e := range t.Entries {
+		existing[e.Name] = e
+	}
+
+	var added []TreeEntry
+	for _, e := range other.Entries {
+		if prefix != "" {
+			e.Name = prefix + "/" + e.Name
+		}
+		if old, ok := existing[e.Name]; ok {
+			if old.ID != e.ID || old.Mode != e.Mode || old.Type != e.Type {
+				return fmt.Errorf("Merge(%q): conflicting entries for %s: %s and %s", prefix, e.Name, old.ID, e.ID)
+			}
+			continue
+		}
+		added = append(added, e)
+		existing[e.Name] = e
+	}
+	t.Entries = append(t.Entries, added...)
+	return nil
+}
+
 // A git reference
 type RefData struct {
 	// The value to which a reference points.
@@ -18,6 +18,7 @@ import (
 	"encoding/json"
 	"reflect"
 	"testing"
+	"time"
 )
 
 func TestTypes(t *testing.T) {
@@ -269,3 +270,57 @@ func TestTypes(t *testing.T) {
 		}
 	}
 }
+
+func TestTreeMerge(t *testing.T) {
+	blob := func(name, id string) TreeEntry {
+		return TreeEntry{Mode: 0100644, Type: "blob", ID: id, Name: name}
+	}
+
+	tree := &Tree{Entries: []TreeEntry{blob("README", "1")}}
+	if err := tree.Merge("", &Tree{Entries: []TreeEntry{blob("README", "1"), blob("Makefile", "2")}}); err != nil {
+		t.Fatalf("Merge(root): %v", err)
+	}
+	if err := tree.Merge("a/b/", &Tree{Entries: []TreeEntry{blob("c", "3"), blob("d/e", "4")}}); err != nil {
+		t.Fatalf("Merge(a/b): %v", err)
+	}
+	if err := tree.Merge("a", &Tree{Entries: []TreeEntry{blob("b/c", "3")}}); err != nil {
+		t.Fatalf("Merge(a): %v", err)
+	}
+
+	want := []TreeEntry{
+		blob("README", "1"),
+		blob("Makefile", "2"),
+		blob("a/b/c", "3"),
+		blob("a/b/d/e", "4"),
+	}
+	if !reflect.DeepEqual(tree.Entries, want) {
+		t.Errorf("got %v, want %v", tree.Entries, want)
+	}
+
+	if err := tree.Merge("a", &Tree{Entries: []TreeEntry{blob("x", "5"), blob("b/c", "6")}}); err == nil {
+		t.Errorf("Merge with conflicting entry succeeded")
+	}
+	if len(tree.Entries) != len(want) {
+		t.Errorf("failed Merge modified the tree: %v", tree.Entries)
+	}
+}
+
+func TestPersonGetTime(t *testing.T) {
+	for in, want := range map[string]time.Time{
+		"Fri Feb 26 14:29:31 2016 +0100": time.Date(2016, 2, 26, 13, 29, 31, 0, time.UTC),
+		"2013-07-29 14:05:26 -0700":      time.Date(2013, 7, 29, 21, 5, 26, 0, time.UTC),
+	} {
+		p := Person{Time: in}
+		got, err := p.GetTime()
+		if err != nil {
+			t.Errorf("GetTime(%q): %v", in, err)
+		} else if !got.Equal(want) {
+			t.Errorf("GetTime(%q): got %v, want %v", in, got, want)
+		}
+	}
+
+	p := Person{Time: "yesterday"}
+	if got, err := p.GetTime(); err == nil {
+		t.Errorf("GetTime(yesterday): got %v, want error", got)
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package glob matches slash separated paths against glob patterns.
+// Patterns are split on "/"; a "**" component matches zero or more
+// path components, and other components are matched with path.Match,
+// so "*" and "?" never match "/".
+package glob
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Pattern is a compiled glob.
+type Pattern []string
+
+// Compile checks the syntax of a glob, and returns its compiled form.
+func Compile(pattern string) (Pattern, error) {
+	p := Pattern(strings.Split(pattern, "/"))
+	for _, c := range p {
+		if _, err := path.Match(c, ""); err != nil {
+			return nil, fmt.Errorf("Compile(%q): %v", pattern, err)
+		}
+	}
+	return p, nil
+}
+
+// Match reports whether name matches the pattern.
+func (p Pattern) Match(name string) bool {
+	return match(p, strings.Split(name, "/"))
+}
+
+func match(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if match(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		// Compile has checked the syntax, so errors can't happen.
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
@@ -0,0 +1,233 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package logging provides a leveled logger for slothfs. Messages are
+// tagged with the subsystem that logs them, and can be written as
+// text or as JSON lines.
+package logging
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level is the severity of a message.
+type Level int
+
+// The levels, from least to most severe.
+const (
+	Debug Level = iota
+	Info
+	Warning
+	Error
+)
+
+var levelNames = []string{"debug", "info", "warning", "error"}
+
+func (l Level) String() string {
+	if l < Debug || l > Error {
+		return fmt.Sprintf("Level(%d)", int(l))
+	}
+	return levelNames[l]
+}
+
+// ParseLevel parses the name of a level.
+func ParseLevel(s string) (Level, error) {
+	for i, n := range levelNames {
+		if strings.EqualFold(s, n) {
+			return Level(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log level %q", s)
+}
+
+// Options configures a Logger.
+type Options struct {
+	// Level is the minimum level to log, optionally followed by
+	// levels for specific subsystems, eg. "info,gitiles=debug".
+	// If empty, "info" is used.
+	Level string
+
+	// If set, write a JSON object per line.
+	JSON bool
+
+	// Output is where messages are written. If nil, os.Stderr is
+	// used.
+	Output io.Writer
+}
+
+var defaultOptions Options
+
+// DefineFlags sets up standard command line flags, and returns the
+// options struct in which the values are put.
+func DefineFlags() *Options {
+	flag.StringVar(&defaultOptions.Level, "log_level", "info", "Set the minimum level to log: debug, info, warning or error. Append eg. \",gitiles=debug\" to set the level of a subsystem.")
+	flag.BoolVar(&defaultOptions.JSON, "log_json", false, "Log JSON objects instead of text lines.")
+	return &defaultOptions
+}
+
+// output is shared by a Logger and its subsystem loggers.
+type output struct {
+	mu   sync.Mutex
+	w    io.Writer
+	json bool
+
+	level  Level
+	levels map[string]Level
+}
+
+// Logger writes leveled messages. A nil *Logger logs to Default().
+type Logger struct {
+	out       *output
+	subsystem string
+	level     Level
+}
+
+// New returns a Logger configured by opts.
+func New(opts Options) (*Logger, error) {
+	out := &output{
+		w:      opts.Output,
+		json:   opts.JSON,
+		level:  Info,
+		levels: map[string]Level{},
+	}
+	if out.w == nil {
+		out.w = os.Stderr
+	}
+
+	for i, f := range strings.Split(opts.Level, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		sub := ""
+		if eq := strings.Index(f, "="); eq >= 0 {
+			sub, f = f[:eq], f[eq+1:]
+		} else if i > 0 {
+			return nil, fmt.Errorf("logging.New: level %q must be first, or be given as SUBSYSTEM=LEVEL", f)
+		}
+		l, err := ParseLevel(f)
+		if err != nil {
+			return nil, fmt.Errorf("logging.New: %v", err)
+		}
+		if sub == "" {
+			out.level = l
+		} else {
+			out.levels[sub] = l
+		}
+	}
+	return &Logger{out: out, level: out.level}, nil
+}
+
+var (
+	defaultMu     sync.Mutex
+	defaultLogger *Logger
+)
+
+// Default returns the logger set with SetDefault, or one that logs
+// text at Info level to stderr.
+func Default() *Logger {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	if defaultLogger == nil {
+		defaultLogger, _ = New(Options{})
+	}
+	return defaultLogger
+}
+
+// SetDefault sets the logger returned by Default. Commands call this
+// after parsing flags, before setting up services.
+func SetDefault(l *Logger) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultLogger = l
+}
+
+// Sub returns a logger for the given subsystem, using its level if
+// one was configured.
+func (l *Logger) Sub(subsystem string) *Logger {
+	if l == nil {
+		l = Default()
+	}
+	level, ok := l.out.levels[subsystem]
+	if !ok {
+		level = l.out.level
+	}
+	return &Logger{out: l.out, subsystem: subsystem, level: level}
+}
+
+// Enabled returns whether messages of the given level are logged.
+func (l *Logger) Enabled(level Level) bool {
+	if l == nil {
+		l = Default()
+	}
+	return level >= l.level
+}
+
+// entry is the JSON form of a message.
+type entry struct {
+	Time      time.Time `json:"time"`
+	Level     string    `json:"level"`
+	Subsystem string    `json:"subsystem,omitempty"`
+	Msg       string    `json:"msg"`
+}
+
+func (l *Logger) logf(level Level, format string, args ...interface{}) {
+	if l == nil {
+		l = Default()
+	}
+	if level < l.level {
+		return
+	}
+
+	e := entry{
+		Time:      time.Now(),
+		Level:     level.String(),
+		Subsystem: l.subsystem,
+		Msg:       fmt.Sprintf(format, args...),
+	}
+	var line []byte
+	if l.out.json {
+		line, _ = json.Marshal(&e)
+	} else {
+		prefix := ""
+		if e.Subsystem != "" {
+			prefix = e.Subsystem + ": "
+		}
+		line = []byte(fmt.Sprintf("%s %s %s%s", e.Time.Format("2006/01/02 15:04:05"), strings.ToUpper(e.Level), prefix, e.Msg))
+	}
+	line = append(line, '\n')
+
+	l.out.mu.Lock()
+	defer l.out.mu.Unlock()
+	l.out.w.Write(line)
+}
+
+// Debugf logs a message at Debug level.
+func (l *Logger) Debugf(format string, args ...interface{}) { l.logf(Debug, format, args...) }
+
+// Infof logs a message at Info level.
+func (l *Logger) Infof(format string, args ...interface{}) { l.logf(Info, format, args...) }
+
+// Warningf logs a message at Warning level.
+func (l *Logger) Warningf(format string, args ...interface{}) { l.logf(Warning, format, args...) }
+
+// Errorf logs a message at Error level.
+func (l *Logger) Errorf(format string, args ...interface{}) { l.logf(Error, format, args...) }
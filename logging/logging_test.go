@@ -0,0 +1,86 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestLevels(t *testing.T) {
+	var buf bytes.Buffer
+	l, err := New(Options{Level: "warning,gitiles=debug", Output: &buf})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	l.Sub("cache").Infof("dropped")
+	l.Sub("cache").Warningf("kept %d", 1)
+	l.Sub("gitiles").Debugf("kept %d", 2)
+
+	got := buf.String()
+	if strings.Contains(got, "dropped") {
+		t.Errorf("message below level was logged: %q", got)
+	}
+	for _, want := range []string{"WARNING cache: kept 1\n", "DEBUG gitiles: kept 2\n"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("got %q, want it to contain %q", got, want)
+		}
+	}
+}
+
+func TestJSON(t *testing.T) {
+	var buf bytes.Buffer
+	l, err := New(Options{JSON: true, Output: &buf})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	l.Sub("fs").Errorf("failed: %v", "boom")
+
+	var e entry
+	if err := json.Unmarshal(buf.Bytes(), &e); err != nil {
+		t.Fatalf("Unmarshal(%q): %v", buf.String(), err)
+	}
+	if e.Level != "error" || e.Subsystem != "fs" || e.Msg != "failed: boom" || e.Time.IsZero() {
+		t.Errorf("got %+v", e)
+	}
+}
+
+func TestNewBadLevel(t *testing.T) {
+	for _, spec := range []string{"loud", "info,debug", "gitiles=loud"} {
+		if _, err := New(Options{Level: spec}); err == nil {
+			t.Errorf("New(%q) succeeded", spec)
+		}
+	}
+}
+
+func TestNilLogger(t *testing.T) {
+	var buf bytes.Buffer
+	l, err := New(Options{Output: &buf})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	old := Default()
+	SetDefault(l)
+	defer SetDefault(old)
+
+	var nilLogger *Logger
+	nilLogger.Sub("cache").Infof("via default")
+	if !strings.Contains(buf.String(), "cache: via default") {
+		t.Errorf("got %q", buf.String())
+	}
+}
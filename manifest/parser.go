@@ -15,10 +15,20 @@
 package manifest
 
 import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
 	"encoding/xml"
+	"fmt"
+	"io"
 	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
 	"sort"
 	"strings"
+	"unicode"
 )
 
 func (p *Project) parse() {
@@ -47,17 +57,105 @@ func (p *Project) prepare() {
 
 // Parse parses the given XML data.
 func Parse(contents []byte) (*Manifest, error) {
-	var m Manifest
-	if err := xml.Unmarshal(contents, &m); err != nil {
+	return parse(contents, "")
+}
+
+// ParseReader reads and parses XML data from r. Errors mention name
+// and the line and column where decoding stopped.
+func ParseReader(r io.Reader, name string) (*Manifest, error) {
+	contents, err := ioutil.ReadAll(r)
+	if err != nil {
 		return nil, err
 	}
+	return parse(contents, name)
+}
+
+func parse(contents []byte, name string) (*Manifest, error) {
+	var m Manifest
+	dec := xml.NewDecoder(bytes.NewReader(contents))
+	if err := dec.Decode(&m); err != nil {
+		line, col := position(contents, dec.InputOffset())
+		if name == "" {
+			return nil, fmt.Errorf("%d:%d: %v", line, col, err)
+		}
+		return nil, fmt.Errorf("%s:%d:%d: %v", name, line, col, err)
+	}
 
 	for i := range m.Project {
 		m.Project[i].parse()
 	}
+	if len(m.Include) > 0 || len(m.RemoveProject) > 0 {
+		if err := m.recordPositions(contents); err != nil {
+			return nil, err
+		}
+	}
 	return &m, nil
 }
 
+// recordPositions notes where the <include> and <remove-project>
+// elements are relative to the <project> elements, as the decoded
+// slices do not keep the document order.
+func (m *Manifest) recordPositions(contents []byte) error {
+	dec := xml.NewDecoder(bytes.NewReader(contents))
+	var depth, projects, includes, removes int
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			depth++
+			if depth != 2 {
+				continue
+			}
+			switch t.Name.Local {
+			case "project":
+				projects++
+			case "include":
+				if includes < len(m.Include) {
+					m.Include[includes].projectsAfter = -projects
+				}
+				includes++
+			case "remove-project":
+				if removes < len(m.RemoveProject) {
+					m.RemoveProject[removes].projectsAfter = -projects
+					m.RemoveProject[removes].includesAfter = -includes
+				}
+				removes++
+			}
+		case xml.EndElement:
+			depth--
+		}
+	}
+
+	// Turn the counts of preceding elements into counts of
+	// following ones.
+	for i := range m.Include {
+		m.Include[i].projectsAfter += projects
+	}
+	for i := range m.RemoveProject {
+		m.RemoveProject[i].projectsAfter += projects
+		m.RemoveProject[i].includesAfter += includes
+	}
+	return nil
+}
+
+// position returns the 1-based line and column of the byte offset
+// off in contents.
+func position(contents []byte, off int64) (line, col int) {
+	if off > int64(len(contents)) {
+		off = int64(len(contents))
+	}
+	before := contents[:off]
+	line = bytes.Count(before, []byte("\n")) + 1
+	col = len(before) - bytes.LastIndexByte(before, '\n')
+	return line, col
+}
+
 // MarshalXML serializes the receiver to XML.
 func (m *Manifest) MarshalXML() ([]byte, error) {
 	for i := range m.Project {
@@ -73,11 +171,12 @@ func (m *Manifest) MarshalXML() ([]byte, error) {
 
 // ParseFile reads and parses an XML file
 func ParseFile(name string) (*Manifest, error) {
-	content, err := ioutil.ReadFile(name)
+	f, err := os.Open(name)
 	if err != nil {
 		return nil, err
 	}
-	return Parse(content)
+	defer f.Close()
+	return ParseReader(f, name)
 }
 
 func (mf *Manifest) ProjectRevision(p *Project) string {
@@ -90,13 +189,575 @@ func (mf *Manifest) ProjectRevision(p *Project) string {
 
 // Filter removes all notdefault projects from a manifest.
 func (mf *Manifest) Filter() {
-	filtered := *mf
-	filtered.Project = nil
+	mf.FilterGroups("default")
+}
+
+// FilterGroups keeps the projects selected by spec, a comma or space
+// separated list of groups as for "repo init -g". A group prefixed
+// with "-" deselects the projects in it; later groups take
+// precedence. Besides the groups listed in the manifest, each project
+// is in "all", "name:NAME", "path:PATH" and, unless it is in
+// "notdefault", "default". An empty spec selects "default".
+func (mf *Manifest) FilterGroups(spec string) {
+	groups := strings.FieldsFunc(spec, func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	})
+	if len(groups) == 0 {
+		groups = []string{"default"}
+	}
+
+	var filtered []Project
 	for _, p := range mf.Project {
-		if p.Groups["notdefault"] {
+		if p.inGroups(groups) {
+			filtered = append(filtered, p)
+		}
+	}
+	mf.Project = filtered
+}
+
+// inGroup returns whether p is in the group g, including implicit
+// groups.
+func (p *Project) inGroup(g string) bool {
+	switch g {
+	case "all", "name:" + p.Name, "path:" + p.GetPath():
+		return true
+	case "default":
+		return !p.Groups["notdefault"]
+	}
+	return p.Groups[g]
+}
+
+// inGroups returns whether p is selected by the list of groups.
+func (p *Project) inGroups(groups []string) bool {
+	selected := false
+	for _, g := range groups {
+		if strings.HasPrefix(g, "-") {
+			if p.inGroup(g[1:]) {
+				selected = false
+			}
+		} else if p.inGroup(g) {
+			selected = true
+		}
+	}
+	return selected
+}
+
+// knownPlatforms lists the platforms repo has groups for.
+var knownPlatforms = []string{"linux", "darwin", "windows"}
+
+// WithPlatform adds the groups for the given platform to a group spec,
+// as "repo init --platform" does. Platform is "auto" for the current
+// OS, "all", "none", or one of "linux", "darwin" and "windows". If
+// platform is empty or the spec already names a platform group, the
+// spec is returned unchanged. An empty spec stands for "default".
+func WithPlatform(spec, platform string) (string, error) {
+	if platform == "" || platform == "none" || strings.Contains(spec, "platform-") {
+		return spec, nil
+	}
+
+	var platforms []string
+	switch platform {
+	case "auto":
+		platforms = []string{runtime.GOOS}
+	case "all":
+		platforms = knownPlatforms
+	default:
+		for _, p := range knownPlatforms {
+			if p == platform {
+				platforms = []string{p}
+			}
+		}
+		if platforms == nil {
+			return "", fmt.Errorf("WithPlatform: unknown platform %q", platform)
+		}
+	}
+
+	if spec == "" {
+		spec = "default"
+	}
+	for _, p := range platforms {
+		spec += ",platform-" + p
+	}
+	return spec, nil
+}
+
+// underPath returns whether p is dir or below dir. An empty dir
+// contains everything.
+func underPath(p, dir string) bool {
+	return dir == "" || p == dir || strings.HasPrefix(p, dir+"/")
+}
+
+// Subset returns a copy of the manifest holding only the projects at
+// or below the given paths. Copyfile and linkfile entries whose
+// source or destination falls outside the subset are dropped. It is
+// an error if a path selects no project, or if a kept entry takes its
+// source from a dropped project.
+func (mf *Manifest) Subset(paths []string) (*Manifest, error) {
+	var dirs []string
+	for _, p := range paths {
+		p = strings.Trim(path.Clean("/"+p), "/")
+		dirs = append(dirs, p)
+	}
+	inSubset := func(p string) bool {
+		for _, d := range dirs {
+			if underPath(p, d) {
+				return true
+			}
+		}
+		return false
+	}
+
+	used := map[string]bool{}
+	for _, p := range mf.Project {
+		for _, d := range dirs {
+			if underPath(p.GetPath(), d) {
+				used[d] = true
+			}
+		}
+	}
+	for _, d := range dirs {
+		if !used[d] {
+			return nil, fmt.Errorf("Subset: no projects at or below %q", d)
+		}
+	}
+
+	// owner returns the project holding the file p, if any.
+	owner := func(p string) *Project {
+		var best *Project
+		for i := range mf.Project {
+			proj := &mf.Project[i]
+			if underPath(p, proj.GetPath()) && (best == nil || len(proj.GetPath()) > len(best.GetPath())) {
+				best = proj
+			}
+		}
+		return best
+	}
+
+	keep := func(proj *Project, kind, src, dest string) (bool, error) {
+		src = path.Join(proj.GetPath(), src)
+		if !inSubset(path.Clean(dest)) {
+			return false, nil
+		}
+		if !inSubset(src) {
+			if o := owner(src); o != nil {
+				return false, fmt.Errorf("Subset: %s %s of project %s uses project %s, which is not in the subset", kind, dest, proj.Name, o.Name)
+			}
+			return false, nil
+		}
+		return true, nil
+	}
+
+	sub := *mf
+	sub.Remote = append([]Remote(nil), mf.Remote...)
+	sub.Project = nil
+	for _, p := range mf.Project {
+		if !inSubset(p.GetPath()) {
 			continue
 		}
-		filtered.Project = append(filtered.Project, p)
+
+		var copies []Copyfile
+		for _, c := range p.Copyfile {
+			ok, err := keep(&p, "copyfile", c.Src, c.Dest)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				copies = append(copies, c)
+			}
+		}
+		var links []Linkfile
+		for _, l := range p.Linkfile {
+			ok, err := keep(&p, "linkfile", l.Src, l.Dest)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				links = append(links, l)
+			}
+		}
+		p.Copyfile = copies
+		p.Linkfile = links
+		sub.Project = append(sub.Project, p)
+	}
+	return &sub, nil
+}
+
+// mergeDefault sets the fields of d that are set in o. It is an error
+// if a field is set to different values in both.
+func mergeDefault(d *Default, o Default) error {
+	fields := []struct {
+		name string
+		dst  *string
+		src  string
+	}{
+		{"revision", &d.Revision, o.Revision},
+		{"remote", &d.Remote, o.Remote},
+		{"dest-branch", &d.DestBranch, o.DestBranch},
+		{"sync-j", &d.SyncJ, o.SyncJ},
+		{"sync-c", &d.SyncC, o.SyncC},
+		{"sync-s", &d.SyncS, o.SyncS},
+	}
+	for _, f := range fields {
+		if f.src == "" {
+			continue
+		}
+		if *f.dst != "" && *f.dst != f.src {
+			return fmt.Errorf("incompatible default %s: %q and %q", f.name, *f.dst, f.src)
+		}
+		*f.dst = f.src
+	}
+	return nil
+}
+
+// Merge combines manifests into one holding all of their projects.
+// Remotes and default settings are combined; it is an error if two
+// manifests define a remote or a default setting differently, or if
+// two projects have the same path. The arguments must be resolved,
+// since Merge does not evaluate <include>, <remove-project> or
+// <extend-project> elements. The arguments are not modified.
+func Merge(manifests ...*Manifest) (*Manifest, error) {
+	for i, mf := range manifests {
+		if len(mf.Include) > 0 || len(mf.RemoveProject) > 0 || len(mf.ExtendProject) > 0 {
+			return nil, fmt.Errorf("Merge: manifest %d has <include>, <remove-project> or <extend-project> elements; call Resolve first", i)
+		}
+	}
+	result, err := combine(manifests)
+	if err == nil {
+		err = result.checkPaths()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Merge: %v", err)
+	}
+	return result, nil
+}
+
+// combine is Merge without the check for duplicate paths.
+func combine(manifests []*Manifest) (*Manifest, error) {
+	var result Manifest
+	remotes := map[string]Remote{}
+	for _, mf := range manifests {
+		if err := mergeDefault(&result.Default, mf.Default); err != nil {
+			return nil, err
+		}
+		for _, r := range mf.Remote {
+			if old, ok := remotes[r.Name]; ok {
+				if old != r {
+					return nil, fmt.Errorf("remote %q is defined differently", r.Name)
+				}
+				continue
+			}
+			remotes[r.Name] = r
+			result.Remote = append(result.Remote, r)
+		}
+		if mf.Superproject != nil {
+			if result.Superproject != nil && *result.Superproject != *mf.Superproject {
+				return nil, fmt.Errorf("superproject is defined differently")
+			}
+			sp := *mf.Superproject
+			result.Superproject = &sp
+		}
+		for _, p := range mf.Project {
+			p.Copyfile = append([]Copyfile(nil), p.Copyfile...)
+			p.Linkfile = append([]Linkfile(nil), p.Linkfile...)
+			result.Project = append(result.Project, p)
+		}
+	}
+	return &result, nil
+}
+
+// checkPaths returns an error if two projects have the same path.
+func (mf *Manifest) checkPaths() error {
+	byPath := map[string]string{}
+	for _, p := range mf.Project {
+		if other, ok := byPath[p.GetPath()]; ok {
+			return fmt.Errorf("projects %q and %q both have path %q", other, p.Name, p.GetPath())
+		}
+		byPath[p.GetPath()] = p.Name
+	}
+	return nil
+}
+
+// DefaultMaxIncludeDepth is the default limit on nested includes.
+const DefaultMaxIncludeDepth = 10
+
+// ResolveOptions configures Resolve.
+type ResolveOptions struct {
+	// Name identifies the manifest in error messages.
+	Name string
+
+	// Load reads the manifest named in an <include> element.
+	Load func(name string) (*Manifest, error)
+
+	// MaxDepth limits how deeply includes nest. If zero,
+	// DefaultMaxIncludeDepth is used.
+	MaxDepth int
+}
+
+// DirLoader returns a function for ResolveOptions.Load that reads
+// included files from the given directory.
+func DirLoader(dir string) func(name string) (*Manifest, error) {
+	return func(name string) (*Manifest, error) {
+		return ParseFile(filepath.Join(dir, name))
+	}
+}
+
+// Resolve evaluates <include>, <remove-project> and <extend-project>
+// elements. The projects, remotes and defaults of all included files
+// are combined as in Merge. A <remove-project> removes the matching
+// projects defined before it in document order, counting the contents
+// of includes at the place of the <include>, so a project may be
+// removed and added again at the same path. Finally, all
+// <extend-project> elements are applied. It is an error if includes
+// form a cycle, or if a <remove-project> or <extend-project> matches
+// no project.
+func (mf *Manifest) Resolve(opts ResolveOptions) error {
+	maxDepth := opts.MaxDepth
+	if maxDepth == 0 {
+		maxDepth = DefaultMaxIncludeDepth
+	}
+	name := opts.Name
+	if name == "" {
+		name = "<manifest>"
+	}
+
+	// Projects and removes are numbered in document order, so a
+	// remove only affects the projects before it.
+	var parts []*Manifest
+	var partSeqs [][]int
+	var removes []removal
+	var extends []ExtendProject
+	seq := 0
+	var walk func(m *Manifest, chain []string) error
+	walk = func(m *Manifest, chain []string) error {
+		parts = append(parts, m)
+		own := make([]int, len(m.Project))
+		partSeqs = append(partSeqs, own)
+		extends = append(extends, m.ExtendProject...)
+		done := 0
+		projectsUntil := func(n int) {
+			for ; done < n && done < len(own); done++ {
+				own[done] = seq
+				seq++
+			}
+		}
+		nextRemove := 0
+		removesUntil := func(includes int) {
+			for ; nextRemove < len(m.RemoveProject); nextRemove++ {
+				r := m.RemoveProject[nextRemove]
+				if len(m.Include)-r.includesAfter > includes {
+					break
+				}
+				projectsUntil(len(own) - r.projectsAfter)
+				removes = append(removes, removal{r, seq})
+				seq++
+			}
+		}
+
+		for i, inc := range m.Include {
+			removesUntil(i)
+			projectsUntil(len(own) - inc.projectsAfter)
+
+			next := append(append([]string(nil), chain...), inc.Name)
+			for _, c := range chain {
+				if c == inc.Name {
+					return fmt.Errorf("include cycle: %s", strings.Join(next, " -> "))
+				}
+			}
+			if len(next)-1 > maxDepth {
+				return fmt.Errorf("includes nested more than %d deep: %s", maxDepth, strings.Join(next, " -> "))
+			}
+			if opts.Load == nil {
+				return fmt.Errorf("%s: cannot load include %s", name, inc.Name)
+			}
+			sub, err := opts.Load(inc.Name)
+			if err != nil {
+				return fmt.Errorf("include %s: %v", strings.Join(next, " -> "), err)
+			}
+			if err := walk(sub, next); err != nil {
+				return err
+			}
+		}
+		removesUntil(len(m.Include))
+		projectsUntil(len(own))
+		return nil
+	}
+	if err := walk(mf, []string{name}); err != nil {
+		return fmt.Errorf("Resolve: %v", err)
+	}
+
+	result, err := combine(parts)
+	if err != nil {
+		return fmt.Errorf("Resolve: %v", err)
+	}
+	var seqs []int
+	for _, own := range partSeqs {
+		seqs = append(seqs, own...)
+	}
+	if err := result.removeProjects(removes, seqs); err != nil {
+		return fmt.Errorf("Resolve: %v", err)
+	}
+	if err := result.checkPaths(); err != nil {
+		return fmt.Errorf("Resolve: %v", err)
+	}
+	for _, e := range extends {
+		if err := result.extendProject(e); err != nil {
+			return fmt.Errorf("Resolve: %v", err)
+		}
+	}
+
+	*mf = *result
+	return nil
+}
+
+// matches returns whether p has the given name, and if set, path.
+func (p *Project) matches(name, path string) bool {
+	return p.Name == name && (path == "" || p.GetPath() == path)
+}
+
+// removal is a <remove-project> with its position in document order.
+type removal struct {
+	RemoveProject
+	seq int
+}
+
+// removeProjects applies removes to the projects of mf, whose
+// positions in document order are in seqs.
+func (mf *Manifest) removeProjects(removes []removal, seqs []int) error {
+	removed := make([]bool, len(mf.Project))
+	for _, r := range removes {
+		found := false
+		for i, p := range mf.Project {
+			if !removed[i] && seqs[i] < r.seq && p.matches(r.Name, r.Path) {
+				removed[i] = true
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("remove-project: no project %q", r.Name)
+		}
+	}
+
+	var kept []Project
+	for i, p := range mf.Project {
+		if !removed[i] {
+			kept = append(kept, p)
+		}
+	}
+	mf.Project = kept
+	return nil
+}
+
+func (mf *Manifest) extendProject(e ExtendProject) error {
+	found := false
+	for i := range mf.Project {
+		p := &mf.Project[i]
+		if !p.matches(e.Name, e.Path) {
+			continue
+		}
+		found = true
+		if e.Groups != "" {
+			// Groups may be shared with the included manifest.
+			groups := map[string]bool{}
+			for g, v := range p.Groups {
+				groups[g] = v
+			}
+			for _, g := range strings.Split(e.Groups, ",") {
+				if g != "" {
+					groups[g] = true
+				}
+			}
+			p.Groups = groups
+		}
+		if e.Revision != "" {
+			p.Revision = e.Revision
+		}
+		if e.Remote != "" {
+			p.Remote = e.Remote
+		}
+		if e.DestBranch != "" {
+			p.DestBranch = e.DestBranch
+		}
+		if e.Upstream != "" {
+			p.Upstream = e.Upstream
+		}
+	}
+	if !found {
+		return fmt.Errorf("extend-project: no project %q", e.Name)
+	}
+	return nil
+}
+
+// Canonicalize sorts projects by path, copyfile and linkfile entries
+// by destination, and remotes by name, so semantically identical
+// manifests marshal to identical XML.
+func (mf *Manifest) Canonicalize() {
+	sort.SliceStable(mf.Remote, func(i, j int) bool {
+		return mf.Remote[i].Name < mf.Remote[j].Name
+	})
+	sort.SliceStable(mf.Project, func(i, j int) bool {
+		pi, pj := mf.Project[i].GetPath(), mf.Project[j].GetPath()
+		if pi != pj {
+			return pi < pj
+		}
+		return mf.Project[i].Name < mf.Project[j].Name
+	})
+	for i := range mf.Project {
+		p := &mf.Project[i]
+		sort.SliceStable(p.Copyfile, func(i, j int) bool {
+			return p.Copyfile[i].Dest < p.Copyfile[j].Dest
+		})
+		sort.SliceStable(p.Linkfile, func(i, j int) bool {
+			return p.Linkfile[i].Dest < p.Linkfile[j].Dest
+		})
+	}
+}
+
+// canonicalCopy returns a canonicalized copy of the manifest. The
+// receiver is not modified.
+func (mf *Manifest) canonicalCopy() *Manifest {
+	c := *mf
+	c.Remote = append([]Remote(nil), mf.Remote...)
+	c.Project = append([]Project(nil), mf.Project...)
+	for i := range c.Project {
+		p := &c.Project[i]
+		p.Copyfile = append([]Copyfile(nil), p.Copyfile...)
+		p.Linkfile = append([]Linkfile(nil), p.Linkfile...)
+	}
+	c.Canonicalize()
+	return &c
+}
+
+// Fingerprint returns the hex SHA1 of the canonicalized XML of the
+// manifest. The receiver is not modified.
+func (mf *Manifest) Fingerprint() (string, error) {
+	content, err := mf.canonicalCopy().MarshalXML()
+	if err != nil {
+		return "", err
+	}
+	h := sha1.Sum(content)
+	return hex.EncodeToString(h[:]), nil
+}
+
+// MarshalXMLIndent serializes a canonicalized copy of the receiver
+// for human consumption: it starts with an XML header, uses a
+// <manifest> root element and two-space indentation, and emits
+// attributes in a fixed order.
+func (mf *Manifest) MarshalXMLIndent() ([]byte, error) {
+	c := mf.canonicalCopy()
+	for i := range c.Project {
+		c.Project[i].prepare()
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	enc := xml.NewEncoder(&buf)
+	enc.Indent("", "  ")
+	if err := enc.EncodeElement(c, xml.StartElement{Name: xml.Name{Local: "manifest"}}); err != nil {
+		return nil, err
+	}
+	if err := enc.Flush(); err != nil {
+		return nil, err
 	}
-	*mf = filtered
+	buf.WriteString("\n")
+	return buf.Bytes(), nil
 }
@@ -15,10 +15,18 @@
 package manifest
 
 import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 )
 
+var update = flag.Bool("update", false, "update golden files")
+
 func newString(s string) *string {
 	return &s
 }
@@ -32,8 +40,10 @@ var aospManifest = `<?xml version="1.0" encoding="UTF-8"?>
            remote="aosp"
            sync-j="4" />
 
-  <project path="build" name="platform/build" groups="pdk,tradefed" >
+  <project path="build" name="platform/build" groups="pdk,tradefed"
+           upstream="refs/heads/master" dest-branch="master" >
     <copyfile src="core/root.mk" dest="Makefile" />
+    <annotation name="ci" value="presubmit" keep="false" />
   </project>
   <project path="build/soong" name="platform/build/soong" groups="pdk,tradefed" >
     <linkfile src="root.bp" dest="Android.bp" />
@@ -72,6 +82,15 @@ func TestBasic(t *testing.T) {
 						Dest: "Makefile",
 					},
 				},
+				Annotation: []Annotation{
+					{
+						Name:  "ci",
+						Value: "presubmit",
+						Keep:  "false",
+					},
+				},
+				Upstream:   "refs/heads/master",
+				DestBranch: "master",
 			},
 			{
 				Path:         newString("build/soong"),
@@ -116,3 +135,385 @@ func TestRoundtrip(t *testing.T) {
 		t.Errorf("got roundtrip %#v, want %#v", roundtrip, manifest)
 	}
 }
+
+func TestParseReaderError(t *testing.T) {
+	in := `<manifest>
+ <remote name="aosp" fetch=".."/>
+ <project path="build" name="platform/build">
+</manifest>`
+	_, err := ParseReader(strings.NewReader(in), "bad.xml")
+	if err == nil {
+		t.Fatal("ParseReader succeeded")
+	}
+	if want := "bad.xml:4:"; !strings.HasPrefix(err.Error(), want) {
+		t.Errorf("got error %q, want prefix %q", err, want)
+	}
+}
+
+func TestSubset(t *testing.T) {
+	mf, err := Parse([]byte(`<manifest>
+ <remote name="aosp" fetch=".."/>
+ <default revision="master" remote="aosp"/>
+ <project path="build" name="platform/build">
+  <copyfile src="core/root.mk" dest="Makefile"/>
+  <linkfile src="tools" dest="build/tools2"/>
+ </project>
+ <project path="build/kati" name="platform/build/kati">
+  <linkfile src="../core/kati.mk" dest="build/kati/kati.mk"/>
+ </project>
+ <project path="art" name="platform/art"/>
+</manifest>`))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	sub, err := mf.Subset([]string{"build/"})
+	if err != nil {
+		t.Fatalf("Subset: %v", err)
+	}
+	var got []string
+	for _, p := range sub.Project {
+		got = append(got, p.GetPath())
+	}
+	if want := []string{"build", "build/kati"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got projects %v, want %v", got, want)
+	}
+	if len(sub.Project[0].Copyfile) != 0 {
+		t.Errorf("copyfile to workspace root was kept: %v", sub.Project[0].Copyfile)
+	}
+	if len(sub.Project[0].Linkfile) != 1 || len(sub.Project[1].Linkfile) != 1 {
+		t.Errorf("linkfiles within the subset were dropped: %v", sub.Project)
+	}
+	if !reflect.DeepEqual(sub.Default, mf.Default) || !reflect.DeepEqual(sub.Remote, mf.Remote) {
+		t.Errorf("remotes and defaults not copied: %v", sub)
+	}
+	if len(mf.Project) != 3 || len(mf.Project[0].Copyfile) != 1 {
+		t.Errorf("Subset modified the receiver: %v", mf.Project)
+	}
+
+	if _, err := mf.Subset([]string{"build/kati"}); err == nil || !strings.Contains(err.Error(), "uses project platform/build,") {
+		t.Errorf("Subset(build/kati): got %v, want error about platform/build", err)
+	}
+	if _, err := mf.Subset([]string{"nonexistent"}); err == nil {
+		t.Errorf("Subset(nonexistent) succeeded")
+	}
+}
+
+func TestMerge(t *testing.T) {
+	a, err := Parse([]byte(`<manifest>
+ <remote name="aosp" fetch=".."/>
+ <default revision="master" remote="aosp"/>
+ <project path="build" name="platform/build">
+  <copyfile src="core/root.mk" dest="Makefile"/>
+ </project>
+</manifest>`))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	b, err := Parse([]byte(`<manifest>
+ <remote name="aosp" fetch=".."/>
+ <remote name="other" fetch="https://other"/>
+ <default remote="aosp" sync-j="4"/>
+ <project path="art" name="platform/art">
+  <linkfile src="tools" dest="art-tools"/>
+ </project>
+</manifest>`))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	got, err := Merge(a, b)
+	if err != nil {
+		t.Fatalf("Merge: %v", err)
+	}
+	if want := (Default{Revision: "master", Remote: "aosp", SyncJ: "4"}); got.Default != want {
+		t.Errorf("got default %v, want %v", got.Default, want)
+	}
+	if len(got.Remote) != 2 || got.Remote[1].Name != "other" {
+		t.Errorf("got remotes %v, want aosp and other", got.Remote)
+	}
+	var paths []string
+	for _, p := range got.Project {
+		paths = append(paths, p.GetPath())
+	}
+	if want := []string{"build", "art"}; !reflect.DeepEqual(paths, want) {
+		t.Errorf("got projects %v, want %v", paths, want)
+	}
+	if len(got.Project[0].Copyfile) != 1 || len(got.Project[1].Linkfile) != 1 {
+		t.Errorf("copyfile and linkfile entries were dropped: %v", got.Project)
+	}
+
+	conflict := *b
+	conflict.Project = []Project{{Name: "platform/build2", Path: a.Project[0].Path}}
+	if _, err := Merge(a, &conflict); err == nil || !strings.Contains(err.Error(), `"platform/build" and "platform/build2"`) {
+		t.Errorf("Merge with colliding paths: got %v, want error naming both projects", err)
+	}
+
+	conflict = *b
+	conflict.Default.Revision = "stable"
+	if _, err := Merge(a, &conflict); err == nil || !strings.Contains(err.Error(), "default revision") {
+		t.Errorf("Merge with different default revisions: got %v, want error", err)
+	}
+
+	conflict = *b
+	conflict.Remote = []Remote{{Name: "aosp", Fetch: "https://elsewhere"}}
+	if _, err := Merge(a, &conflict); err == nil || !strings.Contains(err.Error(), `remote "aosp"`) {
+		t.Errorf("Merge with different remotes: got %v, want error", err)
+	}
+
+	unresolved := *b
+	unresolved.Include = []Include{{Name: "extra.xml"}}
+	if _, err := Merge(a, &unresolved); err == nil || !strings.Contains(err.Error(), "Resolve") {
+		t.Errorf("Merge with an unresolved include: got %v, want error", err)
+	}
+}
+
+func TestResolve(t *testing.T) {
+	files := map[string]string{
+		"default.xml": `<manifest>
+ <remote name="aosp" fetch=".."/>
+ <default revision="master" remote="aosp"/>
+ <project path="build" name="platform/build"/>
+ <project path="art" name="platform/art" groups="pdk"/>
+ <include name="vendor.xml"/>
+</manifest>`,
+		"vendor.xml": `<manifest>
+ <project path="vendor" name="vendor/blob"/>
+ <include name="local.xml"/>
+</manifest>`,
+		"local.xml": `<manifest>
+ <remove-project name="platform/build"/>
+ <project path="build" name="local/build"/>
+ <extend-project name="platform/art" groups="tools" revision="stable"/>
+</manifest>`,
+	}
+	load := func(name string) (*Manifest, error) {
+		content, ok := files[name]
+		if !ok {
+			return nil, fmt.Errorf("no file %s", name)
+		}
+		return Parse([]byte(content))
+	}
+
+	mf, err := load("default.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mf.Resolve(ResolveOptions{Name: "default.xml", Load: load}); err != nil {
+		t.Fatalf("Resolve: %v", err)
+	}
+	var got []string
+	for _, p := range mf.Project {
+		got = append(got, p.GetPath()+"="+p.Name)
+	}
+	if want := []string{"art=platform/art", "vendor=vendor/blob", "build=local/build"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got projects %v, want %v", got, want)
+	}
+	if art := mf.Project[0]; art.Revision != "stable" || !art.Groups["pdk"] || !art.Groups["tools"] {
+		t.Errorf("extend-project not applied: %+v", art)
+	}
+	if mf.Default.Remote != "aosp" || len(mf.Include) != 0 || len(mf.RemoveProject) != 0 || len(mf.ExtendProject) != 0 {
+		t.Errorf("got unresolved manifest %+v", mf)
+	}
+
+	files["local.xml"] = `<manifest><include name="vendor.xml"/></manifest>`
+	mf, _ = load("default.xml")
+	err = mf.Resolve(ResolveOptions{Name: "default.xml", Load: load})
+	if want := "include cycle: default.xml -> vendor.xml -> local.xml -> vendor.xml"; err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("Resolve with cycle: got %v, want %q", err, want)
+	}
+
+	files["local.xml"] = `<manifest/>`
+	mf, _ = load("default.xml")
+	if err := mf.Resolve(ResolveOptions{Load: load, MaxDepth: 1}); err == nil || !strings.Contains(err.Error(), "more than 1 deep") {
+		t.Errorf("Resolve with MaxDepth: got %v", err)
+	}
+
+	files["local.xml"] = `<manifest><remove-project name="platform/missing"/></manifest>`
+	mf, _ = load("default.xml")
+	if err := mf.Resolve(ResolveOptions{Load: load}); err == nil || !strings.Contains(err.Error(), "platform/missing") {
+		t.Errorf("Resolve with unknown remove-project: got %v", err)
+	}
+}
+
+func TestResolveDocumentOrder(t *testing.T) {
+	files := map[string]string{
+		"default.xml": `<manifest>
+ <project path="build" name="platform/build"/>
+ <include name="local.xml"/>
+ <project path="art" name="platform/art"/>
+</manifest>`,
+		"local.xml": `<manifest>
+ <remove-project name="platform/build"/>
+ <project path="build" name="platform/build" revision="stable"/>
+</manifest>`,
+	}
+	load := func(name string) (*Manifest, error) {
+		return Parse([]byte(files[name]))
+	}
+
+	// The project is re-added after it is removed.
+	mf, _ := load("default.xml")
+	if err := mf.Resolve(ResolveOptions{Load: load}); err != nil {
+		t.Fatalf("Resolve: %v", err)
+	}
+	var got []string
+	for _, p := range mf.Project {
+		got = append(got, p.GetPath()+"@"+p.Revision)
+	}
+	if want := []string{"art@", "build@stable"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got projects %v, want %v", got, want)
+	}
+
+	// A remove does not affect projects defined after it.
+	files["local.xml"] = `<manifest>
+ <remove-project name="platform/art"/>
+</manifest>`
+	mf, _ = load("default.xml")
+	if err := mf.Resolve(ResolveOptions{Load: load}); err == nil || !strings.Contains(err.Error(), "platform/art") {
+		t.Errorf("Resolve with remove before project: got %v, want error", err)
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	manifest, err := Parse([]byte(aospManifest))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	swapped, err := Parse([]byte(aospManifest))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	swapped.Project[0], swapped.Project[1] = swapped.Project[1], swapped.Project[0]
+
+	want, err := manifest.Fingerprint()
+	if err != nil {
+		t.Fatalf("Fingerprint: %v", err)
+	}
+	got, err := swapped.Fingerprint()
+	if err != nil {
+		t.Fatalf("Fingerprint: %v", err)
+	}
+	if got != want {
+		t.Errorf("got fingerprint %s for reordered manifest, want %s", got, want)
+	}
+	if swapped.Project[0].Name != "platform/build/soong" {
+		t.Errorf("Fingerprint modified the manifest")
+	}
+
+	swapped.Project[0].Revision = "other"
+	if got, err := swapped.Fingerprint(); err != nil {
+		t.Fatalf("Fingerprint: %v", err)
+	} else if got == want {
+		t.Errorf("fingerprint did not change after changing a revision")
+	}
+}
+
+func TestMarshalXMLIndent(t *testing.T) {
+	manifest, err := Parse([]byte(aospManifest))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	manifest.Project[0], manifest.Project[1] = manifest.Project[1], manifest.Project[0]
+
+	got, err := manifest.MarshalXMLIndent()
+	if err != nil {
+		t.Fatalf("MarshalXMLIndent: %v", err)
+	}
+
+	golden := filepath.Join("testdata", "indent.xml")
+	if *update {
+		if err := ioutil.WriteFile(golden, got, 0644); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+	}
+	want, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+
+	roundtrip, err := Parse(got)
+	if err != nil {
+		t.Fatalf("Parse(roundtrip): %v", err)
+	}
+	if len(roundtrip.Project) != 2 || roundtrip.Project[0].Name != "platform/build" {
+		t.Errorf("got projects %v, want platform/build first", roundtrip.Project)
+	}
+}
+
+func TestFilterGroups(t *testing.T) {
+	const content = `<manifest>
+  <project name="build" groups="pdk,tradefed" />
+  <project name="art" groups="pdk" />
+  <project name="darwin" path="prebuilts/darwin" groups="notdefault,platform-darwin" />
+  <project name="bionic" />
+</manifest>`
+	for _, tc := range []struct {
+		spec string
+		want []string
+	}{
+		{"", []string{"build", "art", "bionic"}},
+		{"tradefed,platform-darwin", []string{"build", "darwin"}},
+		{"all,-pdk", []string{"darwin", "bionic"}},
+		{"pdk -name:art", []string{"build"}},
+		{"-pdk,pdk", []string{"build", "art"}},
+		{"path:prebuilts/darwin", []string{"darwin"}},
+	} {
+		mf, err := Parse([]byte(content))
+		if err != nil {
+			t.Fatalf("Parse: %v", err)
+		}
+		mf.FilterGroups(tc.spec)
+		var got []string
+		for _, p := range mf.Project {
+			got = append(got, p.Name)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("FilterGroups(%q): got %v, want %v", tc.spec, got, tc.want)
+		}
+	}
+}
+
+func TestWithPlatform(t *testing.T) {
+	for _, tc := range []struct {
+		spec, platform, want string
+	}{
+		{"", "", ""},
+		{"pdk", "none", "pdk"},
+		{"", "darwin", "default,platform-darwin"},
+		{"pdk", "all", "pdk,platform-linux,platform-darwin,platform-windows"},
+		{"platform-linux", "darwin", "platform-linux"},
+	} {
+		got, err := WithPlatform(tc.spec, tc.platform)
+		if err != nil || got != tc.want {
+			t.Errorf("WithPlatform(%q, %q): got %q, %v, want %q", tc.spec, tc.platform, got, err, tc.want)
+		}
+	}
+	if _, err := WithPlatform("", "plan9"); err == nil {
+		t.Errorf("WithPlatform with unknown platform succeeded")
+	}
+}
+
+func TestSuperproject(t *testing.T) {
+	mf, err := Parse([]byte(`<manifest>
+ <superproject name="platform/superproject" remote="aosp"/>
+ <project name="platform/build"/>
+</manifest>`))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if mf.Superproject == nil || mf.Superproject.Name != "platform/superproject" {
+		t.Fatalf("got superproject %v", mf.Superproject)
+	}
+	content, err := mf.MarshalXML()
+	if err != nil {
+		t.Fatalf("MarshalXML: %v", err)
+	}
+	if !strings.Contains(string(content), `<superproject name="platform/superproject" remote="aosp">`) {
+		t.Errorf("superproject not serialized: %s", content)
+	}
+}
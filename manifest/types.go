@@ -28,6 +28,14 @@ type Linkfile struct {
 	Dest string `xml:"dest,attr"`
 }
 
+// Annotation is a name/value pair attached to a project. It is
+// not interpreted by repo itself.
+type Annotation struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+	Keep  string `xml:"keep,attr,omitempty"`
+}
+
 // Project represents a single git repository that should be stitched
 // into the checkout.
 type Project struct {
@@ -36,6 +44,7 @@ type Project struct {
 	Remote       string          `xml:"remote,attr,omitempty"`
 	Copyfile     []Copyfile      `xml:"copyfile,omitempty"`
 	Linkfile     []Linkfile      `xml:"linkfile,omitempty"`
+	Annotation   []Annotation    `xml:"annotation,omitempty"`
 	GroupsString string          `xml:"groups,attr,omitempty"`
 	Groups       map[string]bool `xml:"-"`
 
@@ -63,21 +72,62 @@ func (p *Project) GetPath() string {
 
 // Remote describes a host where a set of projects is hosted.
 type Remote struct {
-	Alias    string `xml:"alias,attr"`
+	Alias    string `xml:"alias,attr,omitempty"`
 	Name     string `xml:"name,attr"`
 	Fetch    string `xml:"fetch,attr"`
-	Review   string `xml:"review,attr"`
-	Revision string `xml:"revision,attr"`
+	Review   string `xml:"review,attr,omitempty"`
+	Revision string `xml:"revision,attr,omitempty"`
 }
 
 // Default holds default Project settings.
 type Default struct {
-	Revision   string `xml:"revision,attr"`
-	Remote     string `xml:"remote,attr"`
-	DestBranch string `xml:"dest-branch,attr"`
-	SyncJ      string `xml:"sync-j,attr"`
-	SyncC      string `xml:"sync-c,attr"`
-	SyncS      string `xml:"sync-s,attr"`
+	Revision   string `xml:"revision,attr,omitempty"`
+	Remote     string `xml:"remote,attr,omitempty"`
+	DestBranch string `xml:"dest-branch,attr,omitempty"`
+	SyncJ      string `xml:"sync-j,attr,omitempty"`
+	SyncC      string `xml:"sync-c,attr,omitempty"`
+	SyncS      string `xml:"sync-s,attr,omitempty"`
+}
+
+// Include names another manifest file, relative to the root of the
+// manifest repository, whose contents are added to the manifest.
+type Include struct {
+	Name string `xml:"name,attr"`
+
+	// projectsAfter is the number of <project> elements
+	// following this one in its file.
+	projectsAfter int
+}
+
+// RemoveProject removes the projects with the given name, and if
+// set, path, that are defined before it.
+type RemoveProject struct {
+	Name string `xml:"name,attr"`
+	Path string `xml:"path,attr,omitempty"`
+
+	// projectsAfter and includesAfter are the number of
+	// <project> and <include> elements following this one in its
+	// file. Zero places it at the end, as in written manifests.
+	projectsAfter, includesAfter int
+}
+
+// ExtendProject changes the attributes of the projects with the given
+// name, and if set, path. Groups are added to the existing ones.
+type ExtendProject struct {
+	Name       string `xml:"name,attr"`
+	Path       string `xml:"path,attr,omitempty"`
+	Groups     string `xml:"groups,attr,omitempty"`
+	Revision   string `xml:"revision,attr,omitempty"`
+	Remote     string `xml:"remote,attr,omitempty"`
+	DestBranch string `xml:"dest-branch,attr,omitempty"`
+	Upstream   string `xml:"upstream,attr,omitempty"`
+}
+
+// Superproject names a repository that tracks the commits of all
+// projects as submodules. It is preserved, but not used.
+type Superproject struct {
+	Name   string `xml:"name,attr"`
+	Remote string `xml:"remote,attr,omitempty"`
 }
 
 // Manifest holds the entire manifest, describing a set of git
@@ -86,4 +136,11 @@ type Manifest struct {
 	Default Default   `xml:"default"`
 	Remote  []Remote  `xml:"remote"`
 	Project []Project `xml:"project"`
+
+	Superproject *Superproject `xml:"superproject,omitempty"`
+
+	// These are evaluated by Resolve.
+	Include       []Include       `xml:"include,omitempty"`
+	RemoveProject []RemoveProject `xml:"remove-project,omitempty"`
+	ExtendProject []ExtendProject `xml:"extend-project,omitempty"`
 }
@@ -0,0 +1,254 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package policy implements clone policies, which decide whether
+// reading a file should clone its repository, or fetch the file by
+// itself.
+//
+// A policy is a list of rules, one per line. The first rule that
+// matches a file applies. A rule has the form
+//
+//	allow|deny repo|file GLOB [size>N] [size<N]
+//
+// "allow" clones the repository on reads, and "deny" fetches files
+// lazily. "repo" rules match the repository name and "file" rules
+// the path of the file within the repository. Globs use the syntax
+// of package glob, so "*" and "?" do not match "/", while a "**"
+// component matches any number of directories. A glob without "/"
+// matches the base name. Sizes may have a k, M or G suffix, and
+// only apply to file rules. Lines starting with # are comments.
+package policy
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/google/slothfs/glob"
+)
+
+// Rule is a single line of a policy.
+type Rule struct {
+	// Allow is set if matching files should trigger a clone.
+	Allow bool
+
+	// Repo is set if Glob matches the repository name rather than
+	// the file path.
+	Repo bool
+	Glob string
+
+	// If positive, the rule only matches files larger or smaller
+	// than these sizes.
+	Larger  int64
+	Smaller int64
+
+	pattern glob.Pattern
+}
+
+// Policy is a list of rules. It is safe for concurrent use, and can
+// be replaced with Update while in use.
+type Policy struct {
+	mu    sync.RWMutex
+	rules []Rule
+}
+
+// Parse parses the text of a policy.
+func Parse(data []byte) (*Policy, error) {
+	rules, err := parseRules(data)
+	if err != nil {
+		return nil, err
+	}
+	return &Policy{rules: rules}, nil
+}
+
+// ReadFile reads a policy from a file.
+func ReadFile(name string) (*Policy, error) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	p, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("ReadFile(%s): %v", name, err)
+	}
+	return p, nil
+}
+
+// Update replaces the rules of p with the ones in data. If data does
+// not parse, p is left unchanged.
+func (p *Policy) Update(data []byte) error {
+	rules, err := parseRules(data)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.rules = rules
+	return nil
+}
+
+// Match returns whether reading the file at filePath in the given
+// repository should trigger a clone. If no rule matches, ok is false.
+func (p *Policy) Match(repo, filePath string, size int64) (clone, ok bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	for _, r := range p.rules {
+		if r.matches(repo, filePath, size) {
+			return r.Allow, true
+		}
+	}
+	return false, false
+}
+
+// String returns the rules of p in the policy file format.
+func (p *Policy) String() string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	var buf bytes.Buffer
+	for _, r := range p.rules {
+		buf.WriteString(r.String())
+		buf.WriteByte('\n')
+	}
+	return buf.String()
+}
+
+func (r *Rule) String() string {
+	fields := []string{"deny", "file", r.Glob}
+	if r.Allow {
+		fields[0] = "allow"
+	}
+	if r.Repo {
+		fields[1] = "repo"
+	}
+	if r.Larger > 0 {
+		fields = append(fields, fmt.Sprintf("size>%d", r.Larger))
+	}
+	if r.Smaller > 0 {
+		fields = append(fields, fmt.Sprintf("size<%d", r.Smaller))
+	}
+	return strings.Join(fields, " ")
+}
+
+func (r *Rule) matches(repo, filePath string, size int64) bool {
+	name := filePath
+	if r.Repo {
+		name = repo
+	}
+	if !strings.Contains(r.Glob, "/") {
+		name = path.Base(name)
+	}
+	if !r.pattern.Match(name) {
+		return false
+	}
+	if r.Larger > 0 && size <= r.Larger {
+		return false
+	}
+	if r.Smaller > 0 && size >= r.Smaller {
+		return false
+	}
+	return true
+}
+
+func parseRules(data []byte) ([]Rule, error) {
+	var rules []Rule
+	for i, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		r, err := parseRule(fields)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", i+1, err)
+		}
+		rules = append(rules, *r)
+	}
+	return rules, nil
+}
+
+func parseRule(fields []string) (*Rule, error) {
+	if len(fields) < 3 {
+		return nil, fmt.Errorf("want ACTION KIND GLOB, got %q", strings.Join(fields, " "))
+	}
+
+	var r Rule
+	switch fields[0] {
+	case "allow":
+		r.Allow = true
+	case "deny":
+	default:
+		return nil, fmt.Errorf("unknown action %q", fields[0])
+	}
+	switch fields[1] {
+	case "repo":
+		r.Repo = true
+	case "file":
+	default:
+		return nil, fmt.Errorf("unknown kind %q", fields[1])
+	}
+
+	r.Glob = fields[2]
+	pattern, err := glob.Compile(r.Glob)
+	if err != nil {
+		return nil, err
+	}
+	r.pattern = pattern
+
+	for _, cond := range fields[3:] {
+		if r.Repo {
+			return nil, fmt.Errorf("size condition %q in repo rule", cond)
+		}
+		var bound *int64
+		switch {
+		case strings.HasPrefix(cond, "size>"):
+			bound = &r.Larger
+		case strings.HasPrefix(cond, "size<"):
+			bound = &r.Smaller
+		default:
+			return nil, fmt.Errorf("unknown condition %q", cond)
+		}
+		n, err := parseSize(cond[len("size>"):])
+		if err != nil {
+			return nil, err
+		}
+		*bound = n
+	}
+	return &r, nil
+}
+
+// parseSize parses a byte count with an optional k, M or G suffix.
+func parseSize(s string) (int64, error) {
+	mult := int64(1)
+	if s != "" {
+		switch s[len(s)-1] {
+		case 'k':
+			mult = 1 << 10
+		case 'M':
+			mult = 1 << 20
+		case 'G':
+			mult = 1 << 30
+		}
+		if mult > 1 {
+			s = s[:len(s)-1]
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * mult, nil
+}
@@ -0,0 +1,88 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package policy
+
+import "testing"
+
+const testPolicy = `
+# Prebuilts are too large to clone.
+deny repo platform/prebuilts/**
+allow file *.apk size>1M
+deny file *.apk
+allow file docs/**/*.md size<10k
+`
+
+func TestMatch(t *testing.T) {
+	p, err := Parse([]byte(testPolicy))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	for _, tc := range []struct {
+		repo, path string
+		size       int64
+		clone, ok  bool
+	}{
+		{"platform/prebuilts/sdk", "app/a.apk", 2 << 20, false, true},
+		{"platform/build", "app/a.apk", 2 << 20, true, true},
+		{"platform/build", "a.apk", 1 << 20, false, true},
+		{"platform/build", "docs/x/y.md", 100, true, true},
+		{"platform/build", "docs/y.md", 100, true, true},
+		{"platform/build", "docs/x/y.md", 20 << 10, false, false},
+		{"platform/build", "sub/docs/x/y.md", 100, false, false},
+	} {
+		clone, ok := p.Match(tc.repo, tc.path, tc.size)
+		if clone != tc.clone || ok != tc.ok {
+			t.Errorf("Match(%q, %q, %d) = %v, %v, want %v, %v", tc.repo, tc.path, tc.size, clone, ok, tc.clone, tc.ok)
+		}
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	p, err := Parse([]byte("allow file **\n"))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if err := p.Update([]byte("allow file ** size>1x\n")); err == nil {
+		t.Errorf("Update succeeded for invalid size")
+	}
+	if clone, _ := p.Match("r", "a/b", 1); !clone {
+		t.Errorf("failed Update changed the policy")
+	}
+
+	if err := p.Update([]byte("deny file **\n")); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if clone, ok := p.Match("r", "a/b", 1); clone || !ok {
+		t.Errorf("got %v, %v after Update, want false, true", clone, ok)
+	}
+	if got, want := p.String(), "deny file **\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, in := range []string{
+		"allow file",
+		"maybe file *",
+		"allow dir *",
+		"deny repo * size>1",
+		"deny file * mtime>1",
+	} {
+		if _, err := Parse([]byte(in)); err == nil {
+			t.Errorf("Parse(%q) succeeded", in)
+		}
+	}
+}
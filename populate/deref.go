@@ -15,9 +15,11 @@
 package populate
 
 import (
+	"bytes"
 	"encoding/hex"
 	"fmt"
 	"log"
+	"strings"
 
 	"gopkg.in/src-d/go-git.v4/plumbing"
 
@@ -49,18 +51,31 @@ func gitID(s string) *plumbing.Hash {
 
 // FetchManifest gets the default manifest file from a Gitiles server.
 func FetchManifest(service *gitiles.Service, repo, branch string) (*manifest.Manifest, error) {
+	// When checking this out, it's called "manifest.xml". Go figure.
+	return FetchManifestAt(service, repo, branch, "default.xml")
+}
+
+// FetchManifestAt gets a manifest file from a Gitiles server at the
+// given revision, which may be a branch or a commit SHA1. Pinning a
+// commit makes the result reproducible. Included files are fetched
+// from the same revision, and the manifest is resolved.
+func FetchManifestAt(service *gitiles.Service, repo, revision, filename string) (*manifest.Manifest, error) {
 	project := service.NewRepoService(repo)
+	load := func(name string) (*manifest.Manifest, error) {
+		c, err := project.GetBlob(revision, name)
+		if err != nil {
+			return nil, err
+		}
+		return manifest.ParseReader(bytes.NewReader(c), fmt.Sprintf("%s/%s@%s", repo, name, revision))
+	}
 
-	// When checking this out, it's called "manifest.xml". Go figure.
-	c, err := project.GetBlob(branch, "default.xml")
+	mf, err := load(filename)
 	if err != nil {
 		return nil, err
 	}
-	mf, err := manifest.Parse(c)
-	if err != nil {
+	if err := mf.Resolve(manifest.ResolveOptions{Name: filename, Load: load}); err != nil {
 		return nil, err
 	}
-
 	return mf, nil
 }
 
@@ -111,10 +126,75 @@ func DerefManifest(service *gitiles.Service, mf *manifest.Manifest) error {
 		branch := mf.ProjectRevision(p)
 		commit, ok := proj.Branches[branch]
 		if !ok {
-			return fmt.Errorf("branch %q for repo %s not returned", branch, p.Name)
+			// The listing only has branches; look up
+			// tags and other refs in the repository.
+			commit, err = resolveRef(service.NewRepoService(p.Name), branch)
+			if err != nil {
+				return fmt.Errorf("branch %q for repo %s not returned: %v", branch, p.Name, err)
+			}
 		}
 
 		p.Revision = commit
 	}
 	return nil
 }
+
+// resolveRef returns the commit a revision points to. The revision may
+// be a full ref name, such as refs/tags/android-7.0.0_r1 or
+// refs/changes/..., or a short tag or branch name. Gitiles resolves
+// it like git rev-parse, and peels annotated tags.
+func resolveRef(repo *gitiles.RepoService, rev string) (string, error) {
+	c, err := repo.GetCommit(rev)
+	if err != nil {
+		return "", err
+	}
+	if _, err := parseID(c.Commit); err != nil {
+		return "", fmt.Errorf("commit for %q: %v", rev, err)
+	}
+	return c.Commit, nil
+}
+
+// ExpandOptions configures ExpandManifest.
+type ExpandOptions struct {
+	// Repo and Branch locate the manifest on the Gitiles server.
+	// Branch may also be a commit SHA1.
+	Repo   string
+	Branch string
+
+	// Manifest, if set, is expanded instead of fetching one from
+	// Repo and Branch.
+	Manifest *manifest.Manifest
+
+	// Groups, if set, selects projects as for "repo init -g"; see
+	// manifest.FilterGroups. By default, projects in the
+	// "notdefault" group are dropped.
+	Groups []string
+
+	// Platform, if set, also selects the projects for a platform;
+	// see manifest.WithPlatform.
+	Platform string
+}
+
+// ExpandManifest fetches a manifest, filters it by groups and fills
+// in revisions and clone URLs, so the result is fully pinned.
+func ExpandManifest(service *gitiles.Service, opts ExpandOptions) (*manifest.Manifest, error) {
+	spec, err := manifest.WithPlatform(strings.Join(opts.Groups, ","), opts.Platform)
+	if err != nil {
+		return nil, err
+	}
+
+	mf := opts.Manifest
+	if mf == nil {
+		mf, err = FetchManifest(service, opts.Repo, opts.Branch)
+		if err != nil {
+			return nil, fmt.Errorf("FetchManifest(%s, %s): %v", opts.Repo, opts.Branch, err)
+		}
+	}
+
+	mf.FilterGroups(spec)
+
+	if err := DerefManifest(service, mf); err != nil {
+		return nil, fmt.Errorf("DerefManifest: %v", err)
+	}
+	return mf, nil
+}
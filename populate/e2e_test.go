@@ -15,6 +15,7 @@
 package populate
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -188,7 +189,7 @@ func TestCopyEntries(t *testing.T) {
 
 	ws := filepath.Join(fixture.dir, "ws")
 	roRoot := filepath.Join(fixture.dir, "mnt", "m")
-	if _, _, err := Checkout(roRoot, ws); err != nil {
+	if _, err := Checkout(context.Background(), roRoot, ws, CheckoutOptions{}); err != nil {
 		t.Fatalf("Checkout: %v", err)
 	}
 
@@ -237,7 +238,7 @@ func TestBrokenWorkspaceLink(t *testing.T) {
 
 	ws := filepath.Join(fixture.dir, "ws")
 	m0 := filepath.Join(fixture.dir, "mnt", "m0")
-	if _, _, err := Checkout(m0, ws); err != nil {
+	if _, err := Checkout(context.Background(), m0, ws, CheckoutOptions{}); err != nil {
 		t.Fatalf("Checkout(m0): %v", err)
 	}
 
@@ -246,10 +247,10 @@ func TestBrokenWorkspaceLink(t *testing.T) {
 	}
 
 	m1 := filepath.Join(fixture.dir, "mnt", "m1")
-	if _, changed, err := Checkout(m1, ws); err != nil {
+	if res, err := Checkout(context.Background(), m1, ws, CheckoutOptions{}); err != nil {
 		t.Fatalf("Checkout(m1): %v", err)
-	} else if len(changed) > 0 {
-		t.Errorf("Got changed files %v relative to broken link", changed)
+	} else if len(res.Changed) > 0 {
+		t.Errorf("Got changed files %v relative to broken link", res.Changed)
 	}
 }
 
@@ -323,24 +324,30 @@ func TestFUSESymlink(t *testing.T) {
 
 	ws := filepath.Join(fixture.dir, "ws")
 	m0 := filepath.Join(fixture.dir, "mnt", "m0")
-	added, changed, err := Checkout(m0, ws)
+	res, err := Checkout(context.Background(), m0, ws, CheckoutOptions{})
 	if err != nil {
 		t.Fatalf("Checkout m0: %v", err)
 	}
-	if len(changed) > 0 {
-		t.Errorf("got changed files %v on fresh checkout", changed)
+	if len(res.Changed) > 0 {
+		t.Errorf("got changed files %v on fresh checkout", res.Changed)
 	}
-	if want := []string{filepath.Join(m0, "p/a"), filepath.Join(m0, "p/link")}; !reflect.DeepEqual(added, want) {
-		t.Errorf("got added %v want %v on fresh checkout", added, want)
+	if want := []string{filepath.Join(m0, "p/a"), filepath.Join(m0, "p/link")}; !reflect.DeepEqual(res.Added, want) {
+		t.Errorf("got added %v want %v on fresh checkout", res.Added, want)
 	}
 
 	m1 := filepath.Join(fixture.dir, "mnt", "m1")
-	added, changed, err = Checkout(m1, ws)
-	if len(added) > 0 {
-		t.Errorf("got added files %v on sync", added)
+	res, err = Checkout(context.Background(), m1, ws, CheckoutOptions{})
+	if err != nil {
+		t.Fatalf("Checkout m1: %v", err)
+	}
+	if len(res.Added) > 0 {
+		t.Errorf("got added files %v on sync", res.Added)
+	}
+	if want := []string{filepath.Join(m1, "p/link")}; !reflect.DeepEqual(res.Changed, want) {
+		t.Errorf("got changed files %v, want %v", res.Changed, want)
 	}
-	if want := []string{filepath.Join(m1, "p/link")}; !reflect.DeepEqual(changed, want) {
-		t.Errorf("got changed files %v, want %v", changed, want)
+	if res.PreviousWorkspace != m0 {
+		t.Errorf("got previous workspace %q, want %q", res.PreviousWorkspace, m0)
 	}
 }
 
@@ -452,7 +459,7 @@ func TestBasic(t *testing.T) {
 
 	ws := filepath.Join(dir, "ws")
 
-	if _, _, err := Checkout(filepath.Join(dir, "mnt", "m1"), ws); err != nil {
+	if _, err := Checkout(context.Background(), filepath.Join(dir, "mnt", "m1"), ws, CheckoutOptions{}); err != nil {
 		t.Fatal("Checkout m1:", err)
 	}
 
@@ -466,10 +473,11 @@ func TestBasic(t *testing.T) {
 	// the test setup that no blobs are shared with newly
 	// appearing files, or they'll be touched for being new files.
 
-	added, changed, err := Checkout(filepath.Join(dir, "mnt", "m2"), ws)
+	res, err := Checkout(context.Background(), filepath.Join(dir, "mnt", "m2"), ws, CheckoutOptions{})
 	if err != nil {
 		t.Fatal(err)
 	}
+	added, changed := res.Added, res.Changed
 
 	if want := []string{filepath.Join(dir, "mnt", "m2", "project/a")}; !reflect.DeepEqual(changed, want) {
 		t.Errorf("got changed %v, want %v", changed, want)
@@ -0,0 +1,187 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package populate
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/slothfs/cache"
+)
+
+// LinkMode selects how Checkout makes the files of the RO tree
+// available in the RW tree.
+type LinkMode string
+
+const (
+	// LinkSymlink creates symlinks into the RO tree.
+	LinkSymlink LinkMode = "symlink"
+
+	// LinkCopy copies files from the RO tree, for tools that do
+	// not accept symlinks pointing outside of the checkout.
+	LinkCopy LinkMode = "copy"
+
+	// LinkHardlink hardlinks files from the slothfs cache in
+	// CheckoutOptions.CacheDir. This takes no extra space, but
+	// the files are read-only. Files that cannot be hardlinked,
+	// eg. because the RW tree is on another file system, are
+	// copied.
+	LinkHardlink LinkMode = "hardlink"
+)
+
+// placedFile is the file in the root of the RW tree listing the paths
+// that Checkout copied or hardlinked, with their source in the RO
+// tree. Unlike symlinks, these cannot be recognized on disk.
+const placedFile = ".slothfs-placed.json"
+
+// readPlaced returns the paths listed in placedFile, keyed by their
+// path in rw.
+func readPlaced(rw string) (map[string]string, error) {
+	placed := map[string]string{}
+	c, err := ioutil.ReadFile(filepath.Join(rw, placedFile))
+	if os.IsNotExist(err) {
+		return placed, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var rel map[string]string
+	if err := json.Unmarshal(c, &rel); err != nil {
+		return nil, fmt.Errorf("%s: %v", placedFile, err)
+	}
+	for dest, target := range rel {
+		placed[filepath.Join(rw, dest)] = target
+	}
+	return placed, nil
+}
+
+// writePlaced records the given paths in placedFile. If there are
+// none, placedFile is removed.
+func writePlaced(rw string, placed map[string]string) error {
+	fn := filepath.Join(rw, placedFile)
+	if len(placed) == 0 {
+		if err := os.Remove(fn); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	rel := map[string]string{}
+	for dest, target := range placed {
+		r, err := filepath.Rel(rw, dest)
+		if err != nil {
+			return err
+		}
+		rel[r] = target
+	}
+	c, err := json.MarshalIndent(rel, "", " ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(fn, c, 0644)
+}
+
+// placer copies or hardlinks files from the RO tree.
+type placer struct {
+	mode     LinkMode
+	cacheDir string
+	metaDir  string
+}
+
+// place makes target, a file or directory in the RO tree, available
+// at dest. Directories are copied recursively, except for slothfs
+// metadata, and symlinks are recreated.
+func (p *placer) place(target, dest string) error {
+	return filepath.Walk(target, func(src string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		out := dest + strings.TrimPrefix(src, target)
+		switch {
+		case fi.IsDir():
+			if src != target && fi.Name() == p.metaDir {
+				return filepath.SkipDir
+			}
+			return os.MkdirAll(out, 0755)
+		case fi.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(src)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, out)
+		default:
+			return p.placeFile(src, out, fi.Mode())
+		}
+	})
+}
+
+// placeFile hardlinks or copies the file src to dest. Cached blobs
+// are read-only and not executable, so executables are always
+// copied.
+func (p *placer) placeFile(src, dest string, mode os.FileMode) error {
+	if p.mode == LinkHardlink && mode.Perm()&0111 == 0 {
+		if err := p.linkBlob(src, dest); err == nil {
+			return nil
+		}
+	}
+	return copyFile(src, dest, mode)
+}
+
+// linkBlob hardlinks the cached blob for src to dest, after checking
+// that its content is intact, as the checkout shares it from then on.
+func (p *placer) linkBlob(src, dest string) error {
+	id, err := getSHA1(src)
+	if err != nil {
+		return err
+	}
+
+	// Opening the file makes slothfs fetch the blob into the
+	// cache.
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	f.Close()
+
+	if err := cache.VerifyBlob(p.cacheDir, *id); err != nil {
+		return err
+	}
+	return os.Link(cache.BlobPath(p.cacheDir, *id), dest)
+}
+
+// copyFile copies src to dest, which gets the given mode.
+func copyFile(src, dest string, mode os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode.Perm()|0200)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -17,37 +17,91 @@
 package populate
 
 import (
-	"bytes"
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
+	"runtime"
 	"sort"
 	"strings"
+	"time"
+
+	"github.com/google/slothfs/gitiles"
+	"github.com/google/slothfs/manifest"
+	"gopkg.in/src-d/go-git.v4/plumbing"
 )
 
-// symlinkRepo creates symlinks for all the files in `child`.
-func symlinkRepo(name string, child *repoTree, roRoot, rwRoot string) error {
-	fi, err := os.Stat(filepath.Join(rwRoot, name))
-	if err == nil && fi.IsDir() {
-		return nil
-	}
+// linkPlan computes the symlinks that complete a RW tree.
+type linkPlan struct {
+	// links maps symlink paths in the RW tree to their targets in
+	// the RO tree.
+	links map[string]string
 
-	for e := range child.entries {
-		dest := filepath.Join(rwRoot, name, e)
+	// stale holds symlinks into the RO tree that are still on
+	// disk, but should be treated as absent while planning.
+	stale map[string]string
+}
 
-		if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
-			return err
+func newLinkPlan(stale map[string]string) *linkPlan {
+	return &linkPlan{
+		links: map[string]string{},
+		stale: stale,
+	}
+}
+
+// resolve returns the path that p will point to once the plan is
+// executed, or false if p will not exist.
+func (l *linkPlan) resolve(p string) (string, bool) {
+	for q := p; q != "/" && q != "."; q = filepath.Dir(q) {
+		if target, ok := l.links[q]; ok {
+			return target + p[len(q):], true
 		}
-		if err := os.Symlink(filepath.Join(roRoot, name, e), dest); err != nil {
-			return err
+		if _, ok := l.stale[q]; ok {
+			return "", false
 		}
 	}
-	return nil
+	return p, true
+}
+
+// isDir returns whether p will be a directory once the plan is
+// executed.
+func (l *linkPlan) isDir(p string) bool {
+	r, ok := l.resolve(p)
+	if !ok {
+		return false
+	}
+	fi, err := os.Stat(r)
+	return err == nil && fi.IsDir()
+}
+
+// exists returns whether anything will be at p once the plan is
+// executed.
+func (l *linkPlan) exists(p string) bool {
+	if _, ok := l.links[p]; ok {
+		return true
+	}
+	r, ok := l.resolve(p)
+	if !ok {
+		return false
+	}
+	_, err := os.Lstat(r)
+	return err == nil
+}
+
+// symlinkRepo plans symlinks for all the files in `child`.
+func (l *linkPlan) symlinkRepo(name string, child *repoTree, roRoot, rwRoot string) {
+	if l.isDir(filepath.Join(rwRoot, name)) {
+		return
+	}
+
+	for e := range child.entries {
+		l.links[filepath.Join(rwRoot, name, e)] = filepath.Join(roRoot, name, e)
+	}
 }
 
 // createTreeLinks tries to short-cut symlinks for whole trees by
 // symlinking to the root of a repository in the RO tree.
-func createTreeLinks(ro, rw *repoTree, roRoot, rwRoot string) error {
+func (l *linkPlan) createTreeLinks(ro, rw *repoTree, roRoot, rwRoot string) error {
 	allRW := rw.allChildren()
 
 outer:
@@ -78,69 +132,144 @@ outer:
 
 		switch {
 		case foundRecurse:
-			if err := createTreeLinks(ch, rw.children[nm], filepath.Join(roRoot, nm), filepath.Join(rwRoot, nm)); err != nil {
+			if err := l.createTreeLinks(ch, rw.children[nm], filepath.Join(roRoot, nm), filepath.Join(rwRoot, nm)); err != nil {
 				return err
 			}
 			continue outer
 		case !foundCheckout:
-			dest := filepath.Join(rwRoot, nm)
-			if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
-				return err
-			}
-			if err := os.Symlink(filepath.Join(roRoot, nm), dest); err != nil {
-				return err
-			}
+			l.links[filepath.Join(rwRoot, nm)] = filepath.Join(roRoot, nm)
 		}
 	}
 	return nil
 }
 
-// createLinks will populate a RW tree with symlinks to the RO tree.
-func createLinks(ro, rw *repoTree, roRoot, rwRoot string) error {
-	if err := createTreeLinks(ro, rw, roRoot, rwRoot); err != nil {
+// createLinks plans populating a RW tree with symlinks to the RO tree.
+func (l *linkPlan) createLinks(ro, rw *repoTree, roRoot, rwRoot string) error {
+	if err := l.createTreeLinks(ro, rw, roRoot, rwRoot); err != nil {
 		return err
 	}
 
 	rwc := rw.allChildren()
 	for nm, ch := range ro.allChildren() {
 		if _, ok := rwc[nm]; !ok {
-			if err := symlinkRepo(nm, ch, roRoot, rwRoot); err != nil {
-				return err
-			}
+			l.symlinkRepo(nm, ch, roRoot, rwRoot)
 		}
 	}
 
 	for _, c := range ro.copied {
-		if err := os.Symlink(filepath.Join(roRoot, c), filepath.Join(rwRoot, c)); err != nil && !os.IsExist(err) {
+		dest := filepath.Join(rwRoot, c)
+		if !l.exists(dest) {
+			l.links[dest] = filepath.Join(roRoot, c)
+		}
+	}
+
+	return nil
+}
+
+// execute creates the planned symlinks below rwRoot, or if p is
+// non-nil, copies or hardlinks the targets. Stale symlinks that are
+// not part of the plan are removed, and those that already have the
+// planned target are left alone.
+func (l *linkPlan) execute(rwRoot string, p *placer) error {
+	var removed []string
+	for dest, target := range l.stale {
+		if l.links[dest] == target {
+			delete(l.links, dest)
+			continue
+		}
+		if err := os.RemoveAll(dest); err != nil {
 			return err
 		}
+		removed = append(removed, dest)
 	}
+	removeEmptyParents(rwRoot, removed)
 
+	var dests []string
+	for dest := range l.links {
+		dests = append(dests, dest)
+	}
+	sort.Strings(dests)
+	for _, dest := range dests {
+		if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			return err
+		}
+		if p != nil {
+			if err := p.place(l.links[dest], dest); err != nil {
+				return err
+			}
+		} else if err := os.Symlink(l.links[dest], dest); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-// clearLinks removes all symlinks to the RO tree. It returns the workspace names that were linked before.
-func clearLinks(mount, dir string) (map[string]struct{}, error) {
+// removeEmptyParents removes the directories below root holding the
+// given paths, if they are empty.
+func removeEmptyParents(root string, paths []string) {
+	root = filepath.Clean(root)
+	dirs := map[string]struct{}{}
+	for _, p := range paths {
+		for d := filepath.Dir(p); d != root && d != "/" && d != "."; d = filepath.Dir(d) {
+			dirs[d] = struct{}{}
+		}
+	}
+
+	var sorted []string
+	for d := range dirs {
+		sorted = append(sorted, d)
+	}
+	sort.Strings(sorted)
+	for i := range sorted {
+		// Reverse the ordering, so we get the deepest subdirs first.
+		d := sorted[len(sorted)-1-i]
+		// Ignore error: dir may still contain entries.
+		os.Remove(d)
+	}
+}
+
+// readMountLink returns the target of n if n is a symlink into
+// mount. It never follows the link, so it also works for links into
+// workspaces that were removed from the mount.
+func readMountLink(n, mount string) (string, bool, error) {
+	fi, err := os.Lstat(n)
+	if err != nil {
+		return "", false, err
+	}
+	if fi.Mode()&os.ModeSymlink == 0 {
+		return "", false, nil
+	}
+	target, err := os.Readlink(n)
+	if err != nil {
+		return "", false, err
+	}
+	if !strings.HasPrefix(target, mount+"/") {
+		return "", false, nil
+	}
+	return target, true, nil
+}
+
+// clearLinks removes all symlinks to the RO tree. It returns the
+// removed symlinks with their targets.
+func clearLinks(mount, dir string) (map[string]string, error) {
 	mount = filepath.Clean(mount)
 
 	var dirs []string
 
-	prevPrefixes := map[string]struct{}{}
+	removed := map[string]string{}
 	if err := filepath.Walk(dir, func(n string, fi os.FileInfo, err error) error {
 		if fi == nil {
-			return fmt.Errorf("Walk %s: nil fileinfo for %s", dir, n)
+			return fmt.Errorf("Walk %s: %s: %v", dir, n, err)
 		}
-		if fi.Mode()&os.ModeSymlink != 0 {
-			target, err := os.Readlink(n)
-			if err != nil {
+		target, ok, err := readMountLink(n, mount)
+		if err != nil {
+			return err
+		}
+		if ok {
+			removed[n] = target
+			if err := os.Remove(n); err != nil {
 				return err
 			}
-			if strings.HasPrefix(target, mount) {
-				prevPrefixes[trimMount(target, mount)] = struct{}{}
-				if err := os.Remove(n); err != nil {
-					return err
-				}
-			}
 		}
 		if fi.IsDir() && n != dir {
 			dirs = append(dirs, n)
@@ -158,7 +287,35 @@ func clearLinks(mount, dir string) (map[string]struct{}, error) {
 		os.Remove(d)
 	}
 
-	return prevPrefixes, nil
+	return removed, nil
+}
+
+// findLinks returns all symlinks to the RO tree, keyed by path. Unlike
+// clearLinks, it does not descend into .git directories.
+func findLinks(mount, dir string) (map[string]string, error) {
+	mount = filepath.Clean(mount)
+
+	links := map[string]string{}
+	if err := filepath.Walk(dir, func(n string, fi os.FileInfo, err error) error {
+		if fi == nil {
+			return fmt.Errorf("Walk %s: %s: %v", dir, n, err)
+		}
+		if fi.IsDir() && fi.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		target, ok, err := readMountLink(n, mount)
+		if err != nil {
+			return err
+		}
+		if ok {
+			links[n] = target
+		}
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("Walk %s: %v", dir, err)
+	}
+
+	return links, nil
 }
 
 func trimMount(dir, mount string) string {
@@ -184,7 +341,7 @@ func changedFiles(oldInfos map[string]*fileInfo, newInfos map[string]*fileInfo)
 			changed = append(changed, path)
 			continue
 		}
-		if bytes.Compare(old.sha1[:], info.sha1[:]) != 0 {
+		if *old.sha1 != *info.sha1 {
 			changed = append(changed, path)
 			continue
 		}
@@ -194,13 +351,250 @@ func changedFiles(oldInfos map[string]*fileInfo, newInfos map[string]*fileInfo)
 	return added, changed, nil
 }
 
+// sameManifest returns whether the slothfs workspaces a and b were
+// configured with manifests that have the same fingerprint.
+func sameManifest(a, b, metaDir string) bool {
+	fingerprint := func(dir string) string {
+		mf, err := manifest.ParseFile(filepath.Join(dir, metaDir, "manifest.xml"))
+		if err != nil {
+			return ""
+		}
+		fp, err := mf.Fingerprint()
+		if err != nil {
+			return ""
+		}
+		return fp
+	}
+	fp := fingerprint(a)
+	return fp != "" && fp == fingerprint(b)
+}
+
+// unchangedProjects returns the paths of the projects that are pinned
+// to the same commit in the manifests of the slothfs workspaces a and
+// b. Their files are the same in both workspaces. It returns nil if
+// either manifest cannot be read.
+func unchangedProjects(a, b, metaDir string) map[string]bool {
+	pinned := func(dir string) map[string]string {
+		mf, err := manifest.ParseFile(filepath.Join(dir, metaDir, "manifest.xml"))
+		if err != nil {
+			return nil
+		}
+		revs := map[string]string{}
+		for i := range mf.Project {
+			p := &mf.Project[i]
+			rev := mf.ProjectRevision(p)
+			if plumbing.NewHash(rev).String() == rev {
+				revs[p.GetPath()] = p.Name + " " + rev
+			}
+		}
+		return revs
+	}
+
+	old := pinned(a)
+	if old == nil {
+		return nil
+	}
+	same := map[string]bool{}
+	for path, rev := range pinned(b) {
+		if old[path] == rev {
+			same[path] = true
+		}
+	}
+	return same
+}
+
+// CheckoutOptions controls how Checkout updates the RW tree.
+type CheckoutOptions struct {
+	// Incremental leaves symlinks that already point to the
+	// right place in the RO tree alone, and only creates, replaces
+	// or removes the ones that differ. Directories are only removed
+	// if a removed symlink leaves them empty. By default, all
+	// symlinks into the RO mount are removed before creating new
+	// ones.
+	Incremental bool
+
+	// IgnoreDirs holds patterns for directories in the RW tree
+	// that are not read, eg. build output. See dirFilter for the
+	// syntax. If nil, DefaultIgnoreDirs is used.
+	IgnoreDirs []string
+
+	// Jobs bounds the number of directories read in parallel. If
+	// zero, GOMAXPROCS is used.
+	Jobs int
+
+	// PlainRO allows RO trees without .slothfs metadata, eg. a
+	// read-only snapshot of a checkout. These are read by scanning
+	// the directory, which is slower, and files without SHA1
+	// extended attributes are always considered changed.
+	PlainRO bool
+
+	// MetaDir is the name of the directory holding slothfs
+	// metadata in the RO tree. If empty, DefaultMetaDir is used.
+	MetaDir string
+
+	// TouchMode selects how the mtimes of added and changed files
+	// are set. If empty, TouchNone is used.
+	TouchMode TouchMode
+
+	// Service is used to look up commit times for
+	// TouchCommitTime.
+	Service *gitiles.Service
+
+	// LinkMode selects how files are made available in the RW
+	// tree. If empty, LinkSymlink is used. With other modes,
+	// Incremental is ignored, and everything placed by an earlier
+	// checkout is removed and placed again.
+	LinkMode LinkMode
+
+	// CacheDir is the cache directory of the slothfs mount, for
+	// LinkHardlink.
+	CacheDir string
+
+	// DryRun computes the CheckoutResult without changing the RW
+	// tree or touching files, so Touched is zero.
+	DryRun bool
+}
+
+// TouchMode selects how Checkout updates the mtimes of added and
+// changed files, to trigger rebuilds. If no files changed relative to
+// the previous workspace, Checkout assumes a fresh checkout and
+// touches nothing.
+type TouchMode string
+
+const (
+	// TouchNone leaves mtimes alone.
+	TouchNone TouchMode = "none"
+
+	// TouchNow sets mtimes to the current time.
+	TouchNow TouchMode = "now"
+
+	// TouchCommitTime sets mtimes to the commit time of the
+	// project revision holding the file. Files outside of
+	// projects, eg. copyfile destinations, get the current time.
+	TouchCommitTime TouchMode = "committime"
+)
+
+// DefaultMetaDir is the default name of the slothfs metadata
+// directory.
+const DefaultMetaDir = ".slothfs"
+
+// CheckoutResult describes what Checkout did.
+type CheckoutResult struct {
+	// Added and Changed hold the files in the RO tree that are new
+	// or different relative to the previous workspace. They
+	// should be touched to trigger rebuilds.
+	Added   []string
+	Changed []string
+
+	// Touched is the number of files whose mtime was set
+	// according to CheckoutOptions.TouchMode.
+	Touched int
+
+	// Created and Removed hold the symlinks in the RW tree that
+	// were created or removed. Symlinks that were recreated with
+	// the same target are not included.
+	Created []string
+	Removed []string
+
+	// PreviousWorkspace is the RO tree that the RW tree linked to
+	// before, or empty if there was none.
+	PreviousWorkspace string
+}
+
+// diffLinks returns the sorted symlinks that are in after but not in
+// before (or have a different target), and those only in before.
+func diffLinks(before, after map[string]string) (created, removed []string) {
+	for dest, target := range after {
+		if before[dest] != target {
+			created = append(created, dest)
+		}
+	}
+	for dest := range before {
+		if _, ok := after[dest]; !ok {
+			removed = append(removed, dest)
+		}
+	}
+	sort.Strings(created)
+	sort.Strings(removed)
+	return created, removed
+}
+
+// traversal is the result of reading one of the trees in Checkout.
+type traversal struct {
+	name string
+	err  error
+}
+
 // Checkout updates a RW dir with new symlinks to the given RO dir.
-// Returns the files that should be touched.
-func Checkout(ro, rw string) (added, changed []string, err error) {
+// If ctx is cancelled while the trees are being read, Checkout
+// returns an error naming the trees that were still pending.
+func Checkout(ctx context.Context, ro, rw string, opts CheckoutOptions) (*CheckoutResult, error) {
 	ro = filepath.Clean(ro)
-	wsNames, err := clearLinks(filepath.Dir(ro), rw)
+	switch opts.TouchMode {
+	case "", TouchNone, TouchNow:
+	case TouchCommitTime:
+		if opts.Service == nil {
+			return nil, fmt.Errorf("Checkout: TouchMode %s needs a Service", opts.TouchMode)
+		}
+	default:
+		return nil, fmt.Errorf("Checkout: unknown TouchMode %q", opts.TouchMode)
+	}
+	switch opts.LinkMode {
+	case "", LinkSymlink, LinkCopy:
+	case LinkHardlink:
+		if opts.CacheDir == "" {
+			return nil, fmt.Errorf("Checkout: LinkMode %s needs a CacheDir", opts.LinkMode)
+		}
+	default:
+		return nil, fmt.Errorf("Checkout: unknown LinkMode %q", opts.LinkMode)
+	}
+	symlinks := opts.LinkMode == "" || opts.LinkMode == LinkSymlink
+
+	// before holds the symlinks into the RO mount before the
+	// checkout. In incremental mode, they are still on disk.
+	var before, links map[string]string
+	var err error
+	if (opts.Incremental && symlinks) || opts.DryRun {
+		links, err = findLinks(filepath.Dir(ro), rw)
+		before = links
+	} else {
+		before, err = clearLinks(filepath.Dir(ro), rw)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Files copied or hardlinked by an earlier checkout are always
+	// replaced.
+	placed, err := readPlaced(rw)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
+	}
+	if opts.DryRun {
+		for dest, target := range placed {
+			links[dest] = target
+		}
+	} else if len(placed) > 0 {
+		// Copy before, as it may be the stale links of the plan.
+		all := map[string]string{}
+		for dest, target := range before {
+			all[dest] = target
+		}
+		var removed []string
+		for dest, target := range placed {
+			if err := os.RemoveAll(dest); err != nil {
+				return nil, err
+			}
+			removed = append(removed, dest)
+			all[dest] = target
+		}
+		removeEmptyParents(rw, removed)
+		before = all
+	}
+
+	wsNames := map[string]struct{}{}
+	for _, target := range before {
+		wsNames[trimMount(target, filepath.Dir(ro))] = struct{}{}
 	}
 
 	oldRoot := ""
@@ -212,59 +606,219 @@ func Checkout(ro, rw string) (added, changed []string, err error) {
 		}
 	}
 
+	jobs := opts.Jobs
+	if jobs <= 0 {
+		jobs = runtime.GOMAXPROCS(0)
+	}
+	ignore := opts.IgnoreDirs
+	if ignore == nil {
+		ignore = DefaultIgnoreDirs
+	}
+	metaDir := opts.MetaDir
+	if metaDir == "" {
+		metaDir = DefaultMetaDir
+	}
+	readRO := func(dir string, skip map[string]bool) (*repoTree, error) {
+		if opts.PlainRO && !isSlothFS(dir, metaDir) {
+			return repoTreeFromPlainDir(ctx, dir, metaDir, ignore, jobs)
+		}
+		return repoTreeFromSlothFS(ctx, dir, metaDir, jobs, skip)
+	}
+
 	// Do the file system traversals in parallel.
-	errs := make(chan error, 3)
+	done := make(chan traversal, 3)
+	pending := map[string]bool{}
 	var rwTree, roTree *repoTree
 	var oldInfos map[string]*fileInfo
 
-	if oldRoot != "" {
+	// A previous workspace with the same manifest holds the same
+	// files, so there is no need to read it.
+	unchanged := oldRoot != "" && sameManifest(oldRoot, ro, metaDir)
+
+	// Likewise, projects pinned to the same commit in both
+	// workspaces hold the same files, so they are not read from
+	// the previous workspace, nor compared.
+	var sameProjects map[string]bool
+	if oldRoot != "" && !unchanged {
+		sameProjects = unchangedProjects(oldRoot, ro, metaDir)
+		name := "old tree " + oldRoot
+		pending[name] = true
 		go func() {
-			t, err := repoTreeFromSlothFS(oldRoot)
+			t, err := readRO(oldRoot, sameProjects)
 			if t != nil {
-				oldInfos = t.allFiles()
+				oldInfos = t.filesExcept(sameProjects)
 			}
-			errs <- err
+			done <- traversal{name, err}
 		}()
 	} else {
 		oldInfos = map[string]*fileInfo{}
-		errs <- nil
 	}
 
+	rwName := "RW tree " + rw
+	pending[rwName] = true
 	go func() {
-		t, err := newRepoTree(rw)
+		t, err := newRepoTree(ctx, rw, metaDir, ignore, jobs)
 		rwTree = t
-		errs <- err
+		done <- traversal{rwName, err}
 	}()
+	roName := "RO tree " + ro
+	pending[roName] = true
 	go func() {
-		t, err := repoTreeFromSlothFS(ro)
+		t, err := readRO(ro, nil)
 		roTree = t
-		errs <- err
+		done <- traversal{roName, err}
 	}()
 
-	for i := 0; i < cap(errs); i++ {
-		err := <-errs
-		if err != nil {
-			return nil, nil, err
+	for len(pending) > 0 {
+		select {
+		case r := <-done:
+			if r.err != nil {
+				return nil, fmt.Errorf("%s: %v", r.name, r.err)
+			}
+			delete(pending, r.name)
+		case <-ctx.Done():
+			var names []string
+			for nm := range pending {
+				names = append(names, nm)
+			}
+			sort.Strings(names)
+			return nil, fmt.Errorf("Checkout: %v; still reading %s", ctx.Err(), strings.Join(names, ", "))
+		}
+	}
+
+	plan := newLinkPlan(links)
+	if err := plan.createLinks(roTree, rwTree, ro, rw); err != nil {
+		return nil, err
+	}
+
+	res := &CheckoutResult{
+		PreviousWorkspace: oldRoot,
+	}
+	after := map[string]string{}
+	for dest, target := range plan.links {
+		after[dest] = target
+	}
+	res.Created, res.Removed = diffLinks(before, after)
+
+	if !opts.DryRun {
+		var p *placer
+		if !symlinks {
+			p = &placer{
+				mode:     opts.LinkMode,
+				cacheDir: opts.CacheDir,
+				metaDir:  metaDir,
+			}
+		}
+		if err := plan.execute(rw, p); err != nil {
+			return nil, err
+		}
+		if p == nil {
+			after = nil
+		}
+		if err := writePlaced(rw, after); err != nil {
+			return nil, err
 		}
 	}
 
-	if err := createLinks(roTree, rwTree, ro, rw); err != nil {
-		return nil, nil, err
+	if unchanged {
+		// The previous workspace holds the same files, but
+		// files without a SHA1 cannot be compared, so they
+		// still count as changed. Comparing the new tree with
+		// itself reports exactly those.
+		oldInfos = roTree.filesExcept(nil)
 	}
 
-	newInfos := roTree.allFiles()
-	added, changed, err = changedFiles(oldInfos, newInfos)
+	newInfos := roTree.filesExcept(sameProjects)
+	res.Added, res.Changed, err = changedFiles(oldInfos, newInfos)
 	if err != nil {
-		return nil, nil, fmt.Errorf("changedFiles: %v", err)
+		return nil, fmt.Errorf("changedFiles: %v", err)
 	}
 
-	for i, p := range changed {
-		changed[i] = filepath.Join(ro, p)
+	for i, p := range res.Changed {
+		res.Changed[i] = filepath.Join(ro, p)
 	}
 
-	for i, p := range added {
-		added[i] = filepath.Join(ro, p)
+	for i, p := range res.Added {
+		res.Added[i] = filepath.Join(ro, p)
 	}
 
-	return added, changed, nil
+	if len(res.Changed) > 0 && opts.TouchMode != "" && opts.TouchMode != TouchNone && !opts.DryRun {
+		res.Touched, err = touchFiles(ro, metaDir, opts, res.Added, res.Changed)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return res, nil
+}
+
+// touchFiles sets the mtimes of the given files in the RO tree ro
+// according to opts.TouchMode, and returns the number of files
+// touched.
+func touchFiles(ro, metaDir string, opts CheckoutOptions, files ...[]string) (int, error) {
+	now := time.Now()
+	mtime := func(string) (time.Time, error) { return now, nil }
+	if opts.TouchMode == TouchCommitTime {
+		mf, err := manifest.ParseFile(filepath.Join(ro, metaDir, "manifest.xml"))
+		if err != nil {
+			return 0, err
+		}
+		byPath := map[string]*manifest.Project{}
+		for i, p := range mf.Project {
+			byPath[p.GetPath()] = &mf.Project[i]
+		}
+
+		times := map[*manifest.Project]time.Time{}
+		mtime = func(fn string) (time.Time, error) {
+			rel, err := filepath.Rel(ro, fn)
+			if err != nil {
+				return now, err
+			}
+			var p *manifest.Project
+			for d := filepath.Dir(rel); p == nil && d != "."; d = filepath.Dir(d) {
+				p = byPath[filepath.ToSlash(d)]
+			}
+			if p == nil {
+				return now, nil
+			}
+			if t, ok := times[p]; ok {
+				return t, nil
+			}
+
+			rev := mf.ProjectRevision(p)
+			c, err := opts.Service.NewRepoService(p.Name).GetCommit(rev)
+			if err != nil {
+				return now, fmt.Errorf("GetCommit(%s, %s): %v", p.Name, rev, err)
+			}
+			t, err := c.Committer.GetTime()
+			if err != nil {
+				return now, fmt.Errorf("commit %s of %s: %v", rev, p.Name, err)
+			}
+			times[p] = t
+			return t, nil
+		}
+	}
+
+	n := 0
+	for _, slice := range files {
+		for _, fn := range slice {
+			t, err := mtime(fn)
+			if err != nil {
+				return n, err
+			}
+			err = os.Chtimes(fn, t, t)
+			if os.IsNotExist(err) {
+				fi, statErr := os.Lstat(fn)
+				if statErr == nil && fi.Mode()&os.ModeSymlink != 0 {
+					// Ignore broken symlinks.
+					continue
+				}
+			}
+			if err != nil {
+				return n, fmt.Errorf("Chtimes(%s): %v", fn, err)
+			}
+			n++
+		}
+	}
+	return n, nil
 }
@@ -15,13 +15,26 @@
 package populate
 
 import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"reflect"
+	"strings"
+	"sync"
 	"syscall"
 	"testing"
+	"time"
+
+	"github.com/google/slothfs/cache"
+	"github.com/google/slothfs/gitiles"
+	"github.com/google/slothfs/manifest"
+	"gopkg.in/src-d/go-git.v4/plumbing"
 )
 
 const attr = "user.gitsha1"
@@ -84,7 +97,7 @@ func TestConstruct(t *testing.T) {
 		},
 	}
 
-	got, err := newRepoTree(dir)
+	got, err := newRepoTree(context.Background(), dir, DefaultMetaDir, DefaultIgnoreDirs, 4)
 	if err != nil {
 		t.Fatalf("newRepoTree: %v", err)
 	}
@@ -104,6 +117,144 @@ func TestConstruct(t *testing.T) {
 	}
 }
 
+func TestConstructIgnoreDirs(t *testing.T) {
+	dir, err := createFSTree([]string{
+		"out/target",
+		"node_modules/a/b.js",
+		"bazel-bin/x",
+		"src/main.go",
+		"src/out/keep",
+		"src/node_modules/c.js",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	got, err := newRepoTree(context.Background(), dir, DefaultMetaDir, []string{"/out", "node_modules", "bazel-*"}, 4)
+	if err != nil {
+		t.Fatalf("newRepoTree: %v", err)
+	}
+
+	want := map[string]*fileInfo{
+		"src/main.go":  &fileInfo{},
+		"src/out/keep": &fileInfo{},
+	}
+	if !reflect.DeepEqual(got.entries, want) {
+		t.Errorf("got %v, want %v", got.entries, want)
+	}
+}
+
+func TestConstructDefaultIgnoreDirs(t *testing.T) {
+	dir, err := createFSTree([]string{
+		"out/target",
+		"sub/.git/HEAD",
+		"sub/out/target",
+		"sub/src/out/keep",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	got, err := newRepoTree(context.Background(), dir, DefaultMetaDir, DefaultIgnoreDirs, 4)
+	if err != nil {
+		t.Fatalf("newRepoTree: %v", err)
+	}
+
+	if len(got.entries) != 0 {
+		t.Errorf("got top level entries %v, want none", got.entries)
+	}
+	sub := got.children["sub"]
+	if sub == nil {
+		t.Fatalf("repository sub not found")
+	}
+	want := map[string]*fileInfo{
+		"src/out/keep": &fileInfo{},
+	}
+	if !reflect.DeepEqual(sub.entries, want) {
+		t.Errorf("got %v, want %v", sub.entries, want)
+	}
+}
+
+func TestConstructJobs(t *testing.T) {
+	var names []string
+	for i := 0; i < 50; i++ {
+		names = append(names,
+			fmt.Sprintf("repo%d/.git/HEAD", i),
+			fmt.Sprintf("repo%d/sub/file", i))
+	}
+	dir, err := createFSTree(names)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var mu sync.Mutex
+	active, maxActive := 0, 0
+	readDir = func(name string) ([]os.FileInfo, error) {
+		mu.Lock()
+		active++
+		if active > maxActive {
+			maxActive = active
+		}
+		mu.Unlock()
+
+		// Make sure calls overlap if they can.
+		time.Sleep(time.Millisecond)
+		defer func() {
+			mu.Lock()
+			active--
+			mu.Unlock()
+		}()
+		return ioutil.ReadDir(name)
+	}
+	defer func() { readDir = ioutil.ReadDir }()
+
+	const jobs = 3
+	tree, err := newRepoTree(context.Background(), dir, DefaultMetaDir, nil, jobs)
+	if err != nil {
+		t.Fatalf("newRepoTree: %v", err)
+	}
+	if len(tree.children) != 50 {
+		t.Errorf("got %d repos, want 50", len(tree.children))
+	}
+	if maxActive > jobs {
+		t.Errorf("got %d concurrent ReadDir calls, want at most %d", maxActive, jobs)
+	}
+}
+
+func TestGetSHA1(t *testing.T) {
+	dir, err := createFSTree([]string{"file"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fn := filepath.Join(dir, "file")
+	if got, err := getSHA1(fn); err != nil {
+		t.Fatalf("getSHA1: %v", err)
+	} else if got.String() != checksum {
+		t.Errorf("got %s, want %s", got, checksum)
+	}
+
+	for _, bad := range []string{checksum[:20], checksum + checksum, "x" + checksum[1:]} {
+		if err := syscall.Setxattr(fn, attr, []byte(bad), 0); err != nil {
+			t.Fatalf("Setxattr: %v", err)
+		}
+		if got, err := getSHA1(fn); err == nil {
+			t.Errorf("getSHA1 for %q: got %s, want error", bad, got)
+		}
+	}
+
+	if err := syscall.Removexattr(fn, attr); err != nil {
+		t.Fatalf("Removexattr: %v", err)
+	}
+	if got, err := getSHA1(fn); err == nil {
+		t.Errorf("getSHA1 without attribute: got %s, want error", got)
+	}
+}
+
 func TestRepoTreeFromManifest(t *testing.T) {
 	f, err := ioutil.TempFile("", "")
 	if err != nil {
@@ -160,3 +311,1042 @@ func TestRepoTreeFromManifest(t *testing.T) {
 		t.Errorf("got %#v, want %#v", got, topT)
 	}
 }
+
+func TestRepoTreeFromManifestCollision(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for i, tc := range []struct {
+		projects string
+		want     string
+	}{
+		{`<project path="build" name="platform/build"/>
+ <project path="build" name="platform/build2"/>`,
+			`projects "platform/build" and "platform/build2" both have path "build"`},
+		{`<project path="build" name="platform/build">
+  <copyfile src="core/root.mk" dest="tools/Makefile"/>
+ </project>
+ <project path="tools/Makefile/sub" name="platform/sub"/>`,
+			`project "platform/sub" at "tools/Makefile/sub" conflicts with file "tools/Makefile" of project "platform/build"`},
+	} {
+		fn := filepath.Join(dir, fmt.Sprintf("%d.xml", i))
+		if err := ioutil.WriteFile(fn, []byte("<manifest>\n "+tc.projects+"\n</manifest>"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		_, err := repoTreeFromManifest(fn)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%d: got error %v, want %q", i, err, tc.want)
+		}
+	}
+}
+
+// createWorkspace populates dir so it looks like a slothfs workspace
+// holding the given trees, keyed by project path.
+func createWorkspace(dir string, trees map[string]*gitiles.Tree) error {
+	mf := &manifest.Manifest{}
+	for p, tree := range trees {
+		path := p
+		mf.Project = append(mf.Project, manifest.Project{
+			Name:     p,
+			Path:     &path,
+			Revision: tree.ID,
+		})
+
+		meta := filepath.Join(dir, p, ".slothfs")
+		if err := os.MkdirAll(meta, 0755); err != nil {
+			return err
+		}
+		content, err := json.Marshal(tree)
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(filepath.Join(meta, "tree.json"), content, 0644); err != nil {
+			return err
+		}
+		for _, e := range tree.Entries {
+			fn := filepath.Join(dir, p, e.Name)
+			if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+				return err
+			}
+			if err := ioutil.WriteFile(fn, []byte(e.ID), 0644); err != nil {
+				return err
+			}
+		}
+	}
+
+	content, err := mf.MarshalXML()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Join(dir, ".slothfs"), 0755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, ".slothfs", "tree.json"), []byte("{}"), 0644); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, ".slothfs", "manifest.xml"), content, 0644)
+}
+
+func testID(i int) string {
+	return fmt.Sprintf("%040x", i)
+}
+
+// readLinks returns the symlinks below dir, keyed by relative path.
+func readLinks(dir string) (map[string]string, error) {
+	links := map[string]string{}
+	err := filepath.Walk(dir, func(n string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			target, err := os.Readlink(n)
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(dir, n)
+			if err != nil {
+				return err
+			}
+			links[rel] = target
+		}
+		return nil
+	})
+	return links, err
+}
+
+func TestClearLinksDangling(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	mount := filepath.Join(dir, "mnt")
+	ws := filepath.Join(dir, "ws")
+	if err := os.MkdirAll(filepath.Join(ws, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(mount, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// The workspace "gone" does not exist in the mount anymore.
+	links := map[string]string{
+		filepath.Join(ws, "build"):    filepath.Join(mount, "gone", "build"),
+		filepath.Join(ws, "sub", "a"): filepath.Join(mount, "gone", "sub", "a"),
+		filepath.Join(ws, "other"):    mount + "2/gone/other",
+	}
+	for n, target := range links {
+		if err := os.Symlink(target, n); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := clearLinks(mount, ws)
+	if err != nil {
+		t.Fatalf("clearLinks: %v", err)
+	}
+	delete(links, filepath.Join(ws, "other"))
+	if !reflect.DeepEqual(got, links) {
+		t.Errorf("got %v, want %v", got, links)
+	}
+	for n := range links {
+		if _, err := os.Lstat(n); !os.IsNotExist(err) {
+			t.Errorf("Lstat(%s): got %v, want ENOENT", n, err)
+		}
+	}
+	if _, err := os.Lstat(filepath.Join(ws, "other")); err != nil {
+		t.Errorf("link outside the mount was removed: %v", err)
+	}
+	if got := trimMount(got[filepath.Join(ws, "build")], mount); got != "gone" {
+		t.Errorf("got workspace %q, want %q", got, "gone")
+	}
+}
+
+func TestFetchManifestAt(t *testing.T) {
+	const sha = "ce34badf691d36e8048b63f89d1a86ee5fa4325c"
+	files := map[string]string{
+		"default.xml": `<manifest><project name="platform/build"/><include name="extra.xml"/></manifest>`,
+		"extra.xml":   `<manifest><project name="platform/art"/></manifest>`,
+	}
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		content, ok := files[strings.TrimPrefix(r.URL.Path, "/platform/manifest/+show/"+sha+"/")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		w.Write([]byte(base64.StdEncoding.EncodeToString([]byte(content))))
+	}
+	ts := httptest.NewServer(http.HandlerFunc(handler))
+	defer ts.Close()
+
+	service, err := gitiles.NewService(gitiles.Options{Address: ts.URL})
+	if err != nil {
+		t.Fatalf("NewService: %v", err)
+	}
+	mf, err := FetchManifestAt(service, "platform/manifest", sha, "default.xml")
+	if err != nil {
+		t.Fatalf("FetchManifestAt: %v", err)
+	}
+	if len(mf.Project) != 2 || mf.Project[0].Name != "platform/build" || mf.Project[1].Name != "platform/art" {
+		t.Errorf("got projects %v", mf.Project)
+	}
+}
+
+func TestDerefManifestTag(t *testing.T) {
+	const tagged = "ce34badf691d36e8048b63f89d1a86ee5fa4325c"
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			w.Write([]byte(`)]}'
+{"platform/build": {"name": "platform/build", "clone_url": "https://host/platform/build", "branches": {}}}`))
+		case "/platform/build/+/android-7.0.0_r1", "/platform/build/+/refs/tags/android-7.0.0_r1":
+			w.Write([]byte(`)]}'
+{"commit": "` + tagged + `", "tree": "1111111111111111111111111111111111111111"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	service, err := gitiles.NewService(gitiles.Options{Address: ts.URL})
+	if err != nil {
+		t.Fatalf("NewService: %v", err)
+	}
+	for _, rev := range []string{"android-7.0.0_r1", "refs/tags/android-7.0.0_r1"} {
+		mf := &manifest.Manifest{
+			Project: []manifest.Project{{Name: "platform/build", Revision: rev}},
+		}
+		if err := DerefManifest(service, mf); err != nil {
+			t.Fatalf("DerefManifest(%s): %v", rev, err)
+		}
+		if got := mf.Project[0].Revision; got != tagged {
+			t.Errorf("%s: got revision %s, want %s", rev, got, tagged)
+		}
+	}
+
+	mf := &manifest.Manifest{
+		Project: []manifest.Project{{Name: "platform/build", Revision: "nonexistent"}},
+	}
+	if err := DerefManifest(service, mf); err == nil {
+		t.Errorf("DerefManifest succeeded for a nonexistent ref")
+	}
+}
+
+func TestCheckoutIncremental(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tree := func(id int, entries ...string) *gitiles.Tree {
+		t := &gitiles.Tree{ID: testID(id)}
+		for i, e := range entries {
+			t.Entries = append(t.Entries, gitiles.TreeEntry{
+				Name: e,
+				Type: "blob",
+				Mode: 0100644,
+				ID:   testID(100*id + i),
+			})
+		}
+		return t
+	}
+
+	m1 := filepath.Join(dir, "mnt", "m1")
+	m2 := filepath.Join(dir, "mnt", "m2")
+	if err := createWorkspace(m1, map[string]*gitiles.Tree{
+		"build":       tree(1, "core.mk", "sub/file"),
+		"build/soong": tree(2, "soong.go"),
+		"art":         tree(3, "art.cc"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := createWorkspace(m2, map[string]*gitiles.Tree{
+		"build":       tree(4, "core.mk", "sub/file"),
+		"build/soong": tree(2, "soong.go"),
+		"bionic":      tree(5, "libc.c"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	full := filepath.Join(dir, "full")
+	incr := filepath.Join(dir, "incr")
+	dry := filepath.Join(dir, "dry")
+	for _, ws := range []string{full, incr, dry} {
+		gitDir := filepath.Join(ws, "build", "soong", ".git")
+		if err := os.MkdirAll(gitDir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(gitDir, "HEAD"), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Checkout(context.Background(), m1, ws, CheckoutOptions{}); err != nil {
+			t.Fatalf("Checkout(m1, %s): %v", ws, err)
+		}
+	}
+
+	dryLinks, err := readLinks(dry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan, err := Checkout(context.Background(), m2, dry, CheckoutOptions{DryRun: true, TouchMode: TouchNow})
+	if err != nil {
+		t.Fatalf("Checkout(m2, dry): %v", err)
+	}
+	if links, err := readLinks(dry); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(links, dryLinks) {
+		t.Errorf("dry run changed links from %v to %v", dryLinks, links)
+	}
+
+	want, err := Checkout(context.Background(), m2, full, CheckoutOptions{})
+	if err != nil {
+		t.Fatalf("Checkout(m2, full): %v", err)
+	}
+	got, err := Checkout(context.Background(), m2, incr, CheckoutOptions{Incremental: true})
+	if err != nil {
+		t.Fatalf("Checkout(m2, incr): %v", err)
+	}
+	if !reflect.DeepEqual(got.Added, want.Added) {
+		t.Errorf("got added %v, want %v", got.Added, want.Added)
+	}
+	if !reflect.DeepEqual(got.Changed, want.Changed) {
+		t.Errorf("got changed %v, want %v", got.Changed, want.Changed)
+	}
+	if got.PreviousWorkspace != m1 || want.PreviousWorkspace != m1 {
+		t.Errorf("got previous workspaces %q and %q, want %q", got.PreviousWorkspace, want.PreviousWorkspace, m1)
+	}
+
+	// Both modes should report the same net symlink changes.
+	trim := func(dir string, paths []string) []string {
+		var r []string
+		for _, p := range paths {
+			rel, err := filepath.Rel(dir, p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r = append(r, rel)
+		}
+		return r
+	}
+	if g, w := trim(incr, got.Created), trim(full, want.Created); !reflect.DeepEqual(g, w) {
+		t.Errorf("got created %v, want %v", g, w)
+	}
+	if g, w := trim(incr, got.Removed), trim(full, want.Removed); !reflect.DeepEqual(g, w) {
+		t.Errorf("got removed %v, want %v", g, w)
+	}
+	if g, w := trim(dry, plan.Created), trim(full, want.Created); !reflect.DeepEqual(g, w) {
+		t.Errorf("dry run: got created %v, want %v", g, w)
+	}
+	if g, w := trim(dry, plan.Removed), trim(full, want.Removed); !reflect.DeepEqual(g, w) {
+		t.Errorf("dry run: got removed %v, want %v", g, w)
+	}
+	if !reflect.DeepEqual(plan.Changed, want.Changed) || plan.Touched != 0 {
+		t.Errorf("dry run: got changed %v, touched %d, want %v, 0", plan.Changed, plan.Touched, want.Changed)
+	}
+	if w := []string{"art"}; !reflect.DeepEqual(trim(full, want.Removed), w) {
+		t.Errorf("got removed %v, want %v", trim(full, want.Removed), w)
+	}
+
+	wantLinks, err := readLinks(full)
+	if err != nil {
+		t.Fatal(err)
+	}
+	links, err := readLinks(incr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(links, wantLinks) {
+		t.Errorf("got links %v, want %v", links, wantLinks)
+	}
+	if _, err := os.Lstat(filepath.Join(incr, "art")); !os.IsNotExist(err) {
+		t.Errorf("Lstat(art): got %v, want ENOENT", err)
+	}
+
+	// Rerunning for the same workspace should leave all links alone.
+	before, err := os.Lstat(filepath.Join(incr, "bionic"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Checkout(context.Background(), m2, incr, CheckoutOptions{Incremental: true}); err != nil {
+		t.Fatalf("Checkout(m2, incr): %v", err)
+	}
+	after, err := os.Lstat(filepath.Join(incr, "bionic"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !os.SameFile(before, after) {
+		t.Errorf("symlink bionic was recreated")
+	}
+}
+
+func TestCheckoutCancelled(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ro := filepath.Join(dir, "mnt", "m1")
+	if err := createWorkspace(ro, map[string]*gitiles.Tree{
+		"build": {ID: testID(1)},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	rw := filepath.Join(dir, "ws")
+	if err := os.MkdirAll(rw, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := Checkout(ctx, ro, rw, CheckoutOptions{}); err == nil {
+		t.Fatalf("Checkout with cancelled context succeeded")
+	} else if !strings.Contains(err.Error(), context.Canceled.Error()) {
+		t.Errorf("got error %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestCheckoutPlainRO(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ro := filepath.Join(dir, "snapshot", "ro")
+	for _, p := range []string{"build/.git", "build/kati/.git", "build/core"} {
+		if err := os.MkdirAll(filepath.Join(ro, p), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, p := range []string{"build/core/root.mk", "build/kati/main.cc", "Makefile"} {
+		if err := ioutil.WriteFile(filepath.Join(ro, p), []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rw := filepath.Join(dir, "rw")
+	if err := os.MkdirAll(rw, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Checkout(context.Background(), ro, rw, CheckoutOptions{}); err == nil {
+		t.Fatal("Checkout without metadata succeeded without PlainRO")
+	}
+
+	res, err := Checkout(context.Background(), ro, rw, CheckoutOptions{PlainRO: true})
+	if err != nil {
+		t.Fatalf("Checkout: %v", err)
+	}
+	for _, p := range []string{"build", "Makefile"} {
+		if got, err := os.Readlink(filepath.Join(rw, p)); err != nil {
+			t.Errorf("Readlink(%s): %v", p, err)
+		} else if want := filepath.Join(ro, p); got != want {
+			t.Errorf("Readlink(%s): got %q, want %q", p, got, want)
+		}
+	}
+	if len(res.Added) != 2 {
+		t.Errorf("got added %v, want the 2 files in repositories", res.Added)
+	}
+}
+
+func TestListWorkspaces(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := filepath.Join(dir, "config")
+	if err := os.MkdirAll(config, 0755); err != nil {
+		t.Fatalf("MkdirAll: %v", err)
+	}
+	mfs := map[string]*manifest.Manifest{
+		"ws2": {Project: []manifest.Project{{Name: "a"}, {Name: "b"}}},
+		"ws1": {Project: []manifest.Project{{Name: "c"}}},
+	}
+	for name, mf := range mfs {
+		content, err := mf.MarshalXML()
+		if err != nil {
+			t.Fatalf("MarshalXML: %v", err)
+		}
+		xmlFile := filepath.Join(dir, name+".xml")
+		if err := ioutil.WriteFile(xmlFile, content, 0644); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+		if err := os.Symlink(xmlFile, filepath.Join(config, name)); err != nil {
+			t.Fatalf("Symlink: %v", err)
+		}
+	}
+
+	got, err := ListWorkspaces(dir)
+	if err != nil {
+		t.Fatalf("ListWorkspaces: %v", err)
+	}
+	var want []WorkspaceInfo
+	for _, name := range []string{"ws1", "ws2"} {
+		fp, err := mfs[name].Fingerprint()
+		if err != nil {
+			t.Fatalf("Fingerprint: %v", err)
+		}
+		want = append(want, WorkspaceInfo{Name: name, Fingerprint: fp, Projects: len(mfs[name].Project)})
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if _, err := ListWorkspaces(filepath.Join(dir, "missing")); err == nil {
+		t.Errorf("ListWorkspaces on missing mount succeeded")
+	}
+}
+
+func TestChangedFiles(t *testing.T) {
+	id := func(i int) *plumbing.Hash {
+		h := plumbing.NewHash(testID(i))
+		return &h
+	}
+	oldInfos := map[string]*fileInfo{
+		"same":    {sha1: id(1)},
+		"changed": {sha1: id(2)},
+		"nosha1":  {},
+		"removed": {sha1: id(3)},
+	}
+	newInfos := map[string]*fileInfo{
+		"same":    {sha1: id(1)},
+		"changed": {sha1: id(4)},
+		"nosha1":  {sha1: id(5)},
+		"b-added": {sha1: id(6)},
+		"a-added": {},
+	}
+	added, changed, err := changedFiles(oldInfos, newInfos)
+	if err != nil {
+		t.Fatalf("changedFiles: %v", err)
+	}
+	if want := []string{"a-added", "b-added"}; !reflect.DeepEqual(added, want) {
+		t.Errorf("got added %v, want %v", added, want)
+	}
+	if want := []string{"changed", "nosha1"}; !reflect.DeepEqual(changed, want) {
+		t.Errorf("got changed %v, want %v", changed, want)
+	}
+}
+
+func TestCheckoutSameManifest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	trees := map[string]*gitiles.Tree{
+		"build": {
+			ID: testID(1),
+			Entries: []gitiles.TreeEntry{
+				{Name: "core.mk", Type: "blob", Mode: 0100644, ID: testID(2)},
+			},
+		},
+	}
+	m1 := filepath.Join(dir, "mnt", "m1")
+	m2 := filepath.Join(dir, "mnt", "m2")
+	for _, m := range []string{m1, m2} {
+		if err := createWorkspace(m, trees); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rw := filepath.Join(dir, "rw")
+	if err := os.MkdirAll(rw, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Checkout(context.Background(), m1, rw, CheckoutOptions{}); err != nil {
+		t.Fatalf("Checkout(m1): %v", err)
+	}
+
+	// Make the old tree unreadable; it should not be needed.
+	if err := os.Remove(filepath.Join(m1, "build", ".slothfs", "tree.json")); err != nil {
+		t.Fatal(err)
+	}
+	res, err := Checkout(context.Background(), m2, rw, CheckoutOptions{})
+	if err != nil {
+		t.Fatalf("Checkout(m2): %v", err)
+	}
+	if res.PreviousWorkspace != m1 {
+		t.Errorf("got previous workspace %q, want %q", res.PreviousWorkspace, m1)
+	}
+	if len(res.Added) > 0 || len(res.Changed) > 0 {
+		t.Errorf("got added %v, changed %v, want none", res.Added, res.Changed)
+	}
+	links, err := readLinks(rw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"build": filepath.Join(m2, "build")}; !reflect.DeepEqual(links, want) {
+		t.Errorf("got links %v, want %v", links, want)
+	}
+}
+
+func TestCheckoutUnchangedProjects(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tree := func(id int, blob int) *gitiles.Tree {
+		return &gitiles.Tree{
+			ID: testID(id),
+			Entries: []gitiles.TreeEntry{
+				{Name: "file", Type: "blob", Mode: 0100644, ID: testID(blob)},
+			},
+		}
+	}
+	m1 := filepath.Join(dir, "mnt", "m1")
+	m2 := filepath.Join(dir, "mnt", "m2")
+	if err := createWorkspace(m1, map[string]*gitiles.Tree{
+		"build": tree(1, 10),
+		"art":   tree(2, 20),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := createWorkspace(m2, map[string]*gitiles.Tree{
+		"build": tree(3, 30),
+		"art":   tree(2, 20),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := unchangedProjects(m1, m2, DefaultMetaDir), map[string]bool{"art": true}; !reflect.DeepEqual(got, want) {
+		t.Errorf("unchangedProjects: got %v, want %v", got, want)
+	}
+
+	rw := filepath.Join(dir, "rw")
+	if err := os.MkdirAll(rw, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Checkout(context.Background(), m1, rw, CheckoutOptions{}); err != nil {
+		t.Fatalf("Checkout(m1): %v", err)
+	}
+
+	// art is at the same commit, so its old tree is not needed.
+	if err := os.Remove(filepath.Join(m1, "art", ".slothfs", "tree.json")); err != nil {
+		t.Fatal(err)
+	}
+	res, err := Checkout(context.Background(), m2, rw, CheckoutOptions{})
+	if err != nil {
+		t.Fatalf("Checkout(m2): %v", err)
+	}
+	if len(res.Added) > 0 {
+		t.Errorf("got added %v, want none", res.Added)
+	}
+	if want := []string{filepath.Join(m2, "build", "file")}; !reflect.DeepEqual(res.Changed, want) {
+		t.Errorf("got changed %v, want %v", res.Changed, want)
+	}
+}
+
+func TestCheckoutMetaDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ro := filepath.Join(dir, "mnt", "ws")
+	if err := createWorkspace(ro, map[string]*gitiles.Tree{
+		"build": {
+			ID: testID(1),
+			Entries: []gitiles.TreeEntry{
+				{Name: "core.mk", Type: "blob", Mode: 0100644, ID: testID(2)},
+			},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range []string{ro, filepath.Join(ro, "build")} {
+		if err := os.Rename(filepath.Join(d, ".slothfs"), filepath.Join(d, ".gitfs")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rw := filepath.Join(dir, "rw")
+	if err := os.MkdirAll(rw, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Checkout(context.Background(), ro, rw, CheckoutOptions{}); err == nil {
+		t.Errorf("Checkout with default MetaDir succeeded")
+	}
+	res, err := Checkout(context.Background(), ro, rw, CheckoutOptions{MetaDir: ".gitfs"})
+	if err != nil {
+		t.Fatalf("Checkout: %v", err)
+	}
+	if want := []string{filepath.Join(ro, "build", "core.mk")}; !reflect.DeepEqual(res.Added, want) {
+		t.Errorf("got added %v, want %v", res.Added, want)
+	}
+}
+
+func TestCheckoutTouchMode(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tree := func(id int) *gitiles.Tree {
+		return &gitiles.Tree{
+			ID: testID(id),
+			Entries: []gitiles.TreeEntry{
+				{Name: "core.mk", Type: "blob", Mode: 0100644, ID: testID(100 * id)},
+			},
+		}
+	}
+	m1 := filepath.Join(dir, "mnt", "m1")
+	m2 := filepath.Join(dir, "mnt", "m2")
+	if err := createWorkspace(m1, map[string]*gitiles.Tree{"build": tree(1)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := createWorkspace(m2, map[string]*gitiles.Tree{"build": tree(2)}); err != nil {
+		t.Fatal(err)
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/build/+/"+testID(2) {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(")]}'\n{\"committer\": {\"time\": \"Fri Feb 26 14:29:31 2016 +0100\"}}"))
+	}))
+	defer ts.Close()
+	service, err := gitiles.NewService(gitiles.Options{Address: ts.URL})
+	if err != nil {
+		t.Fatalf("NewService: %v", err)
+	}
+
+	rw := filepath.Join(dir, "rw")
+	if err := os.MkdirAll(rw, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Checkout(context.Background(), m1, rw, CheckoutOptions{TouchMode: "sometimes"}); err == nil {
+		t.Errorf("Checkout with unknown TouchMode succeeded")
+	}
+	if _, err := Checkout(context.Background(), m1, rw, CheckoutOptions{TouchMode: TouchCommitTime}); err == nil {
+		t.Errorf("Checkout with TouchCommitTime and no Service succeeded")
+	}
+	if _, err := Checkout(context.Background(), m1, rw, CheckoutOptions{}); err != nil {
+		t.Fatalf("Checkout(m1): %v", err)
+	}
+
+	res, err := Checkout(context.Background(), m2, rw, CheckoutOptions{
+		TouchMode: TouchCommitTime,
+		Service:   service,
+	})
+	if err != nil {
+		t.Fatalf("Checkout(m2): %v", err)
+	}
+	if res.Touched != 1 {
+		t.Errorf("got %d files touched, want 1", res.Touched)
+	}
+	fi, err := os.Stat(filepath.Join(m2, "build", "core.mk"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2016, 2, 26, 13, 29, 31, 0, time.UTC); !fi.ModTime().Equal(want) {
+		t.Errorf("got mtime %v, want %v", fi.ModTime(), want)
+	}
+
+	start := time.Now().Add(-time.Second)
+	res, err = Checkout(context.Background(), m1, rw, CheckoutOptions{TouchMode: TouchNow})
+	if err != nil {
+		t.Fatalf("Checkout(m1): %v", err)
+	}
+	fi, err = os.Stat(filepath.Join(m1, "build", "core.mk"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Touched != 1 || fi.ModTime().Before(start) {
+		t.Errorf("got %d files touched, mtime %v; want 1, after %v", res.Touched, fi.ModTime(), start)
+	}
+}
+
+func benchmarkCheckout(b *testing.B, opts CheckoutOptions) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var workspaces []string
+	for w := 0; w < 2; w++ {
+		trees := map[string]*gitiles.Tree{}
+		for p := 0; p < 100; p++ {
+			id := p
+			if p == 0 {
+				// Only the first project differs between
+				// the workspaces.
+				id = 1000 + w
+			}
+			tree := &gitiles.Tree{ID: testID(id)}
+			for f := 0; f < 100; f++ {
+				tree.Entries = append(tree.Entries, gitiles.TreeEntry{
+					Name: fmt.Sprintf("dir%d/file%d", f%10, f),
+					Type: "blob",
+					Mode: 0100644,
+					ID:   testID(id*1000 + f),
+				})
+			}
+			trees[fmt.Sprintf("project%d/sub", p)] = tree
+		}
+
+		ws := filepath.Join(dir, "mnt", fmt.Sprintf("m%d", w))
+		if err := createWorkspace(ws, trees); err != nil {
+			b.Fatal(err)
+		}
+		workspaces = append(workspaces, ws)
+	}
+
+	rw := filepath.Join(dir, "rw")
+	for p := 0; p < 100; p += 10 {
+		// Force file level links for some projects.
+		gitDir := filepath.Join(rw, fmt.Sprintf("project%d/sub/dir0/.git", p))
+		if err := os.MkdirAll(gitDir, 0755); err != nil {
+			b.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(gitDir, "HEAD"), nil, 0644); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := Checkout(context.Background(), workspaces[i%2], rw, opts); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkCheckoutFull(b *testing.B) {
+	benchmarkCheckout(b, CheckoutOptions{})
+}
+
+func BenchmarkCheckoutIncremental(b *testing.B) {
+	benchmarkCheckout(b, CheckoutOptions{Incremental: true})
+}
+
+// BenchmarkChangedFiles compares two synthetic workspaces of 200
+// repositories holding 1000 files each.
+func BenchmarkChangedFiles(b *testing.B) {
+	synthetic := func(seed int) *repoTree {
+		root := makeRepoTree()
+		for r := 0; r < 200; r++ {
+			ch := makeRepoTree()
+			for f := 0; f < 1000; f++ {
+				h := plumbing.NewHash(testID(r*1000 + f + seed*(f%10)))
+				ch.entries[fmt.Sprintf("dir%d/file%d", f%10, f)] = &fileInfo{sha1: &h}
+			}
+			root.children[fmt.Sprintf("project%d/sub", r)] = ch
+		}
+		return root
+	}
+	oldTree, newTree := synthetic(0), synthetic(1)
+
+	b.ResetTimer()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := changedFiles(oldTree.allFiles(), newTree.allFiles()); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestCheckoutCopy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tree := func(id int, entries ...string) *gitiles.Tree {
+		t := &gitiles.Tree{ID: testID(id)}
+		for i, e := range entries {
+			t.Entries = append(t.Entries, gitiles.TreeEntry{
+				Name: e,
+				Type: "blob",
+				Mode: 0100644,
+				ID:   testID(100*id + i),
+			})
+		}
+		return t
+	}
+	m1 := filepath.Join(dir, "mnt", "m1")
+	m2 := filepath.Join(dir, "mnt", "m2")
+	if err := createWorkspace(m1, map[string]*gitiles.Tree{
+		"build": tree(1, "core.mk", "sub/file"),
+		"art":   tree(2, "art.cc"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := createWorkspace(m2, map[string]*gitiles.Tree{
+		"build":  tree(1, "core.mk", "sub/file"),
+		"bionic": tree(3, "libc.c"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	rw := filepath.Join(dir, "rw")
+	if err := os.MkdirAll(rw, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Checkout(context.Background(), m1, rw, CheckoutOptions{LinkMode: LinkCopy}); err != nil {
+		t.Fatalf("Checkout(m1): %v", err)
+	}
+	if fi, err := os.Lstat(filepath.Join(rw, "build", "sub", "file")); err != nil || !fi.Mode().IsRegular() {
+		t.Errorf("Lstat(build/sub/file): got %v, %v, want regular file", fi, err)
+	}
+	if c, err := ioutil.ReadFile(filepath.Join(rw, "art", "art.cc")); err != nil || string(c) != testID(200) {
+		t.Errorf("ReadFile(art/art.cc): got %q, %v, want %q", c, err, testID(200))
+	}
+	if _, err := os.Lstat(filepath.Join(rw, "build", ".slothfs")); !os.IsNotExist(err) {
+		t.Errorf("Lstat(build/.slothfs): got %v, want ENOENT", err)
+	}
+
+	res, err := Checkout(context.Background(), m2, rw, CheckoutOptions{LinkMode: LinkCopy})
+	if err != nil {
+		t.Fatalf("Checkout(m2): %v", err)
+	}
+	if res.PreviousWorkspace != m1 {
+		t.Errorf("got previous workspace %q, want %q", res.PreviousWorkspace, m1)
+	}
+	if _, err := os.Lstat(filepath.Join(rw, "art")); !os.IsNotExist(err) {
+		t.Errorf("Lstat(art): got %v, want ENOENT", err)
+	}
+	if _, err := os.Stat(filepath.Join(rw, "bionic", "libc.c")); err != nil {
+		t.Errorf("Stat(bionic/libc.c): %v", err)
+	}
+
+	// Going back to symlinks removes the copies.
+	if _, err := Checkout(context.Background(), m2, rw, CheckoutOptions{Incremental: true}); err != nil {
+		t.Fatalf("Checkout(m2, symlink): %v", err)
+	}
+	links, err := readLinks(rw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"build":  filepath.Join(m2, "build"),
+		"bionic": filepath.Join(m2, "bionic"),
+	}
+	if !reflect.DeepEqual(links, want) {
+		t.Errorf("got links %v, want %v", links, want)
+	}
+	if _, err := os.Lstat(filepath.Join(rw, placedFile)); !os.IsNotExist(err) {
+		t.Errorf("Lstat(%s): got %v, want ENOENT", placedFile, err)
+	}
+}
+
+func TestCheckoutHardlink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ro := filepath.Join(dir, "mnt", "m1")
+	names := []string{"core.mk", "uncached.mk", "tool.sh", "corrupt.mk"}
+	var entries []gitiles.TreeEntry
+	for i, nm := range names {
+		entries = append(entries, gitiles.TreeEntry{Name: nm, Type: "blob", Mode: 0100644, ID: testID(i + 2)})
+	}
+	if err := createWorkspace(ro, map[string]*gitiles.Tree{
+		"build": {ID: testID(1), Entries: entries},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(filepath.Join(ro, "build", "tool.sh"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// The files hold their test ID; the blobs are keyed by the
+	// real SHA1 of that, except for corrupt.mk.
+	cacheDir := filepath.Join(dir, "cache")
+	blobs := map[string]string{}
+	for i, nm := range names {
+		content := []byte(testID(i + 2))
+		id := plumbing.ComputeHash(plumbing.BlobObject, content)
+		if err := syscall.Setxattr(filepath.Join(ro, "build", nm), xattrName, []byte(id.String()), 0); err != nil {
+			t.Fatalf("Setxattr: %v", err)
+		}
+		if nm == "uncached.mk" {
+			continue
+		}
+		if nm == "corrupt.mk" {
+			content = []byte("bitrot")
+		}
+		blob := cache.BlobPath(cacheDir, id)
+		if err := os.MkdirAll(filepath.Dir(blob), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(blob, content, 0444); err != nil {
+			t.Fatal(err)
+		}
+		blobs[nm] = blob
+	}
+
+	rw := filepath.Join(dir, "rw")
+	if err := os.MkdirAll(rw, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Checkout(context.Background(), ro, rw, CheckoutOptions{}); err != nil {
+		t.Fatalf("Checkout(symlink): %v", err)
+	}
+	if _, err := Checkout(context.Background(), ro, rw, CheckoutOptions{LinkMode: LinkHardlink}); err == nil {
+		t.Errorf("Checkout without CacheDir succeeded")
+	}
+	if _, err := Checkout(context.Background(), ro, rw, CheckoutOptions{LinkMode: LinkHardlink, CacheDir: cacheDir}); err != nil {
+		t.Fatalf("Checkout(hardlink): %v", err)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		linked bool
+		mode   os.FileMode
+	}{
+		{"core.mk", true, 0444},
+		// A blob that is not in the cache is copied.
+		{"uncached.mk", false, 0644},
+		// Blobs are not executable, so executables are copied.
+		{"tool.sh", false, 0755},
+		// A corrupt blob is not shared with the checkout.
+		{"corrupt.mk", false, 0644},
+	} {
+		fn := filepath.Join(rw, "build", tc.name)
+		fi, err := os.Lstat(fn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		linked := false
+		if blob, ok := blobs[tc.name]; ok {
+			blobFI, err := os.Stat(blob)
+			if err != nil {
+				t.Fatal(err)
+			}
+			linked = os.SameFile(fi, blobFI)
+		}
+		if linked != tc.linked {
+			t.Errorf("%s: got hardlinked %v, want %v", tc.name, linked, tc.linked)
+		}
+		if fi.Mode().Perm() != tc.mode {
+			t.Errorf("%s: got mode %o, want %o", tc.name, fi.Mode().Perm(), tc.mode)
+		}
+		for i, nm := range names {
+			if nm != tc.name {
+				continue
+			}
+			if c, err := ioutil.ReadFile(fn); err != nil || string(c) != testID(i+2) {
+				t.Errorf("ReadFile(%s): got %q, %v, want %q", tc.name, c, err, testID(i+2))
+			}
+		}
+	}
+}
@@ -15,6 +15,7 @@
 package populate
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -31,6 +32,10 @@ import (
 	"github.com/google/slothfs/manifest"
 )
 
+// xattrName is the extended attribute holding the git SHA1 of a
+// file in slothfs.
+const xattrName = "user.gitsha1"
+
 // fileInfo holds data files contained in the git repository within a
 // repoTree node.
 type fileInfo struct {
@@ -75,6 +80,9 @@ func repoTreeFromManifest(xmlFile string) (*repoTree, error) {
 	if err != nil {
 		return nil, err
 	}
+	if err := checkProjectPaths(mf); err != nil {
+		return nil, fmt.Errorf("repoTreeFromManifest(%s): %v", xmlFile, err)
+	}
 
 	var byDepth [][]*manifest.Project
 	for i, p := range mf.Project {
@@ -113,10 +121,46 @@ func repoTreeFromManifest(xmlFile string) (*repoTree, error) {
 	return root, nil
 }
 
+// checkProjectPaths returns an error if two projects share a path,
+// or if a project is placed at or below the destination of a
+// copyfile or linkfile.
+func checkProjectPaths(mf *manifest.Manifest) error {
+	byPath := map[string]string{}
+	for _, p := range mf.Project {
+		if other, ok := byPath[p.GetPath()]; ok {
+			return fmt.Errorf("projects %q and %q both have path %q", other, p.Name, p.GetPath())
+		}
+		byPath[p.GetPath()] = p.Name
+	}
+
+	dests := map[string]string{}
+	for _, p := range mf.Project {
+		for _, c := range p.Copyfile {
+			dests[c.Dest] = p.Name
+		}
+		for _, c := range p.Linkfile {
+			dests[c.Dest] = p.Name
+		}
+	}
+
+	for _, p := range mf.Project {
+		path := p.GetPath()
+		for i := 0; i <= len(path); i++ {
+			if i < len(path) && path[i] != '/' {
+				continue
+			}
+			if other, ok := dests[path[:i]]; ok {
+				return fmt.Errorf("project %q at %q conflicts with file %q of project %q", p.Name, path, path[:i], other)
+			}
+		}
+	}
+	return nil
+}
+
 // fillFromSlothFS reads tree.json to fill Entries for this repoTree
 // node only, and does not recurse.
-func (t *repoTree) fillFromSlothFS(dir string) error {
-	c, err := ioutil.ReadFile(filepath.Join(dir, ".slothfs", "tree.json"))
+func (t *repoTree) fillFromSlothFS(dir, metaDir string) error {
+	c, err := ioutil.ReadFile(filepath.Join(dir, metaDir, "tree.json"))
 	if err != nil {
 		return err
 	}
@@ -139,19 +183,30 @@ func (t *repoTree) fillFromSlothFS(dir string) error {
 	return nil
 }
 
-// repoTreeFromSlothFS reads data from .slothfs to construct a fully
-// populated repoTree tree.
-func repoTreeFromSlothFS(dir string) (*repoTree, error) {
-	root, err := repoTreeFromManifest(filepath.Join(dir, ".slothfs", "manifest.xml"))
+// repoTreeFromSlothFS reads data from the metaDir directories to
+// construct a fully populated repoTree tree, reading at most jobs
+// files in parallel. The files of the repositories whose path is in
+// skip are not read.
+func repoTreeFromSlothFS(ctx context.Context, dir, metaDir string, jobs int, skip map[string]bool) (*repoTree, error) {
+	root, err := repoTreeFromManifest(filepath.Join(dir, metaDir, "manifest.xml"))
 	if err != nil {
 		return nil, err
 	}
 
 	chs := root.allChildren()
+	for path := range skip {
+		delete(chs, path)
+	}
 	errs := make(chan error, len(chs))
-	for path, ch := range root.allChildren() {
+	sem := make(chan struct{}, jobs)
+	for path, ch := range chs {
+		sem <- struct{}{}
 		go func(p string, t *repoTree) {
-			err := t.fillFromSlothFS(p)
+			err := ctx.Err()
+			if err == nil {
+				err = t.fillFromSlothFS(p, metaDir)
+			}
+			<-sem
 			errs <- err
 		}(filepath.Join(dir, path), ch)
 	}
@@ -165,6 +220,40 @@ func repoTreeFromSlothFS(dir string) (*repoTree, error) {
 	return root, nil
 }
 
+// isSlothFS returns whether dir holds slothfs workspace metadata.
+func isSlothFS(dir, metaDir string) bool {
+	_, err := os.Stat(filepath.Join(dir, metaDir, "manifest.xml"))
+	return err == nil
+}
+
+// repoTreeFromPlainDir constructs a repoTree for a directory without
+// slothfs metadata by scanning it. SHA1s are read from extended
+// attributes where available; files without them have no SHA1.
+func repoTreeFromPlainDir(ctx context.Context, dir, metaDir string, ignore []string, jobs int) (*repoTree, error) {
+	root, err := newRepoTree(ctx, dir, metaDir, ignore, jobs)
+	if err != nil {
+		return nil, err
+	}
+
+	// Files outside of repositories are copyfile and linkfile
+	// destinations.
+	for nm := range root.entries {
+		root.copied = append(root.copied, nm)
+	}
+	sort.Strings(root.copied)
+	root.entries = map[string]*fileInfo{}
+
+	for path, ch := range root.allChildren() {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		for nm, fi := range ch.entries {
+			fi.sha1, _ = getSHA1(filepath.Join(dir, path, nm))
+		}
+	}
+	return root, nil
+}
+
 // makeRepoTree returns a repoTree struct with maps initialized.
 func makeRepoTree() *repoTree {
 	return &repoTree{
@@ -173,10 +262,64 @@ func makeRepoTree() *repoTree {
 	}
 }
 
-// newRepoTree returns a repoTree constructed from filesystem data.
-func newRepoTree(dir string) (*repoTree, error) {
+// DefaultIgnoreDirs are the directories skipped when reading a
+// workspace, unless CheckoutOptions.IgnoreDirs is set. "/out" skips
+// the Android build output: a directory named out directly below the
+// root of any repository, including the top of the workspace, but not
+// deeper ones such as foo/out. The .git and slothfs metadata
+// directories are always skipped.
+var DefaultIgnoreDirs = []string{"/out"}
+
+// dirFilter holds gitignore-style patterns for directories to skip
+// while reading a workspace. Patterns starting with "/" match paths
+// relative to the repository root; others match the directory name
+// at any depth. Patterns use filepath.Match syntax.
+type dirFilter []string
+
+// ignore returns whether to skip dir, a path relative to its
+// repository root.
+func (f dirFilter) ignore(dir string) bool {
+	for _, pat := range f {
+		name := filepath.Base(dir)
+		if strings.HasPrefix(pat, "/") {
+			pat = pat[1:]
+			name = dir
+		}
+		if ok, _ := filepath.Match(pat, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// treeWalker holds the settings for reading a workspace.
+type treeWalker struct {
+	filter dirFilter
+
+	// metaDir is the name of the slothfs metadata directory.
+	metaDir string
+
+	// sem bounds the number of extra goroutines reading
+	// repositories.
+	sem chan struct{}
+}
+
+// readDir is ioutil.ReadDir; it can be replaced for testing.
+var readDir = ioutil.ReadDir
+
+// newRepoTree returns a repoTree constructed from filesystem data,
+// skipping directories matched by ignore. Directories holding a .git
+// or metaDir directory are repositories. At most jobs repositories
+// are read in parallel.
+func newRepoTree(ctx context.Context, dir, metaDir string, ignore []string, jobs int) (*repoTree, error) {
+	w := &treeWalker{
+		filter:  dirFilter(ignore),
+		metaDir: metaDir,
+		// The calling goroutine also reads.
+		sem: make(chan struct{}, jobs-1),
+	}
 	t := makeRepoTree()
-	if err := t.fill(dir, ""); err != nil {
+	if err := t.fill(ctx, w, dir, ""); err != nil {
 		return nil, err
 	}
 	return t, nil
@@ -196,32 +339,68 @@ func (t *repoTree) allChildren() map[string]*repoTree {
 
 // allFiles returns all the files below this repoTree.
 func (t *repoTree) allFiles() map[string]*fileInfo {
+	r := make(map[string]*fileInfo, t.fileCount())
+	t.addFiles("", r)
+	return r
+}
+
+// filesExcept is like allFiles, but leaves out the files of the
+// repositories whose path is in skip.
+func (t *repoTree) filesExcept(skip map[string]bool) map[string]*fileInfo {
 	r := map[string]*fileInfo{}
+	for p, ch := range t.allChildren() {
+		if skip[p] {
+			continue
+		}
+		prefix := ""
+		if p != "" {
+			prefix = p + "/"
+		}
+		for nm, info := range ch.entries {
+			r[prefix+nm] = info
+		}
+	}
+	return r
+}
+
+// fileCount returns the number of files below this repoTree.
+func (t *repoTree) fileCount() int {
+	n := len(t.entries)
+	for _, ch := range t.children {
+		n += ch.fileCount()
+	}
+	return n
+}
+
+// addFiles adds the files below this repoTree to r, prefixing their
+// names with prefix.
+func (t *repoTree) addFiles(prefix string, r map[string]*fileInfo) {
 	for nm, info := range t.entries {
-		r[nm] = info
+		r[prefix+nm] = info
 	}
 	for nm, ch := range t.children {
-		for sub, subCh := range ch.allFiles() {
-			r[filepath.Join(nm, sub)] = subCh
-		}
+		ch.addFiles(prefix+nm+"/", r)
 	}
-	return r
 }
 
 // returns whether path is the topdirectory of some git repository,
 // either in plain git or in slothfs.
-func isRepoDir(path string) bool {
+func isRepoDir(path, metaDir string) bool {
 	if stat, err := os.Stat(filepath.Join(path, ".git")); err == nil && stat.IsDir() {
 		return true
-	} else if stat, err := os.Stat(filepath.Join(path, ".slothfs")); err == nil && stat.IsDir() {
+	} else if stat, err := os.Stat(filepath.Join(path, metaDir)); err == nil && stat.IsDir() {
 		return true
 	}
 	return false
 }
 
 // construct fills `parent` looking through `dir` subdir of `repoRoot`.
-func (t *repoTree) fill(repoRoot, dir string) error {
-	entries, err := ioutil.ReadDir(filepath.Join(repoRoot, dir))
+// It stops with an error if ctx is cancelled.
+func (t *repoTree) fill(ctx context.Context, w *treeWalker, repoRoot, dir string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	entries, err := readDir(filepath.Join(repoRoot, dir))
 	if err != nil {
 		log.Println(repoRoot, err)
 		return err
@@ -229,33 +408,46 @@ func (t *repoTree) fill(repoRoot, dir string) error {
 
 	todo := map[string]*repoTree{}
 	for _, e := range entries {
-		if e.IsDir() && (e.Name() == ".git" || e.Name() == ".slothfs") {
-			continue
-		}
-		if e.IsDir() && e.Name() == "out" && dir == "" {
-			// Ignore the build output directory.
+		if e.IsDir() && (e.Name() == ".git" || e.Name() == w.metaDir) {
 			continue
 		}
 
 		subName := filepath.Join(dir, e.Name())
+		if e.IsDir() && w.filter.ignore(subName) {
+			continue
+		}
 		if e.IsDir() {
-			if newRoot := filepath.Join(repoRoot, subName); isRepoDir(newRoot) {
+			if newRoot := filepath.Join(repoRoot, subName); isRepoDir(newRoot, w.metaDir) {
 				ch := makeRepoTree()
 				t.children[subName] = ch
 				todo[newRoot] = ch
 			} else {
-				t.fill(repoRoot, subName)
+				t.fill(ctx, w, repoRoot, subName)
+				if err := ctx.Err(); err != nil {
+					return err
+				}
 			}
 		} else {
 			t.entries[subName] = &fileInfo{}
 		}
 	}
 
+	// Read subrepositories in a new goroutine if we are below the
+	// limit, and inline otherwise. Waiting for a slot instead could
+	// deadlock, as the goroutines holding them wait for their own
+	// subrepositories.
 	errs := make(chan error, len(todo))
 	for newRoot, ch := range todo {
-		go func(r string, t *repoTree) {
-			errs <- t.fill(r, "")
-		}(newRoot, ch)
+		select {
+		case w.sem <- struct{}{}:
+			go func(r string, t *repoTree) {
+				err := t.fill(ctx, w, r, "")
+				<-w.sem
+				errs <- err
+			}(newRoot, ch)
+		default:
+			errs <- ch.fill(ctx, w, newRoot, "")
+		}
 	}
 
 	for range todo {
@@ -0,0 +1,67 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package populate
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/google/slothfs/manifest"
+)
+
+// WorkspaceInfo describes a workspace configured in a slothfs mount.
+type WorkspaceInfo struct {
+	// Name is the name of the workspace directory.
+	Name string
+
+	// Fingerprint is the fingerprint of the workspace manifest.
+	Fingerprint string
+
+	// Projects is the number of projects in the manifest.
+	Projects int
+}
+
+// ListWorkspaces reads the manifests configured under the config/
+// directory of a slothfs mount, and returns the workspaces sorted by
+// name.
+func ListWorkspaces(mount string) ([]WorkspaceInfo, error) {
+	configDir := filepath.Join(mount, "config")
+	if _, err := os.Stat(configDir); err != nil {
+		return nil, fmt.Errorf("ListWorkspaces(%s): %v", mount, err)
+	}
+	names, err := filepath.Glob(filepath.Join(configDir, "*"))
+	if err != nil {
+		return nil, fmt.Errorf("ListWorkspaces(%s): %v", mount, err)
+	}
+
+	var result []WorkspaceInfo
+	for _, n := range names {
+		mf, err := manifest.ParseFile(n)
+		if err != nil {
+			return nil, fmt.Errorf("ListWorkspaces(%s): %v", mount, err)
+		}
+		fp, err := mf.Fingerprint()
+		if err != nil {
+			return nil, fmt.Errorf("ListWorkspaces(%s): %v", mount, err)
+		}
+		result = append(result, WorkspaceInfo{
+			Name:        filepath.Base(n),
+			Fingerprint: fp,
+			Projects:    len(mf.Project),
+		})
+	}
+	return result, nil
+}
@@ -0,0 +1,58 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+
+package populate
+
+import (
+	"bytes"
+	"fmt"
+	"syscall"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+)
+
+// getSHA1 reads the git SHA1 of a file from the extended attribute
+// that slothfs sets on its blobs. This is the same value that
+// `getfattr -n user.gitsha1` prints.
+func getSHA1(name string) (*plumbing.Hash, error) {
+	var buf []byte
+	for {
+		// Ask for the size first, so we don't depend on the
+		// attribute having a fixed length.
+		sz, err := syscall.Getxattr(name, xattrName, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		buf = make([]byte, sz)
+		sz, err = syscall.Getxattr(name, xattrName, buf)
+		if err == syscall.ERANGE {
+			// The attribute grew in between calls; try again.
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		buf = buf[:sz]
+		break
+	}
+
+	val := bytes.TrimRight(buf, "\x00\n")
+	if len(val) != 40 {
+		return nil, fmt.Errorf("%s: %s has %d bytes %q, want 40 hex digits", name, xattrName, len(val), val)
+	}
+	return parseID(string(val))
+}